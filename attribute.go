@@ -17,6 +17,9 @@ const (
 	visibleAttribute
 	vipAttribute
 	fromLevelAttribute
+	shieldedAttribute
+	takenAttribute
+	afkAttribute
 )
 
 type ByteAttributeType uint8
@@ -32,6 +35,7 @@ const (
 	// TODO: not really attributes?
 	healthByteAttribute
 	juiceByteAttribute
+	armorByteAttribute
 )
 
 type IntAttributeType uint8
@@ -42,6 +46,7 @@ const (
 
 	killIntAttribute
 	deathIntAttribute
+	assistIntAttribute
 )
 
 type FloatAttributeType uint8
@@ -370,4 +375,65 @@ func (a *Attribute) SetData(data Data) {
 			a.floatAttributes[floatAttribute] = val
 		}
 	}
+}
+
+type AttributeSnapshot struct {
+	Attributes map[AttributeType]bool
+	InternalAttributes map[AttributeType]bool
+	ByteAttributes map[ByteAttributeType]uint8
+	IntAttributes map[IntAttributeType]int
+	FloatAttributes map[FloatAttributeType]float64
+}
+
+func (a Attribute) SnapshotAttributes() AttributeSnapshot {
+	snapshot := AttributeSnapshot {
+		Attributes: make(map[AttributeType]bool),
+		InternalAttributes: make(map[AttributeType]bool),
+		ByteAttributes: make(map[ByteAttributeType]uint8),
+		IntAttributes: make(map[IntAttributeType]int),
+		FloatAttributes: make(map[FloatAttributeType]float64),
+	}
+
+	for attribute, has := range(a.attributes) {
+		snapshot.Attributes[attribute] = has
+	}
+	for attribute, has := range(a.internalAttributes) {
+		// Restored objects are new to every client
+		if has && attribute != initializedAttribute {
+			snapshot.InternalAttributes[attribute] = true
+		}
+	}
+	for attribute, value := range(a.byteAttributes) {
+		snapshot.ByteAttributes[attribute] = value
+	}
+	for attribute, value := range(a.intAttributes) {
+		snapshot.IntAttributes[attribute] = value
+	}
+	for attribute, value := range(a.floatAttributes) {
+		snapshot.FloatAttributes[attribute] = value
+	}
+	return snapshot
+}
+
+// Goes through the setters so the changes are sent to clients
+func (a *Attribute) RestoreAttributes(snapshot AttributeSnapshot) {
+	for attribute, has := range(snapshot.Attributes) {
+		if has {
+			a.AddAttribute(attribute)
+		} else {
+			a.RemoveAttribute(attribute)
+		}
+	}
+	for attribute := range(snapshot.InternalAttributes) {
+		a.AddInternalAttribute(attribute)
+	}
+	for attribute, value := range(snapshot.ByteAttributes) {
+		a.SetByteAttribute(attribute, value)
+	}
+	for attribute, value := range(snapshot.IntAttributes) {
+		a.SetIntAttribute(attribute, value)
+	}
+	for attribute, value := range(snapshot.FloatAttributes) {
+		a.SetFloatAttribute(attribute, value)
+	}
 }
\ No newline at end of file
@@ -35,6 +35,9 @@ var blockSizes = map[SpaceType]map[BlockType]Vec2 {
 	},
 }
 
+// Pickups placed left to right by weaponsBlockTemplate
+var templateWeapons = [...]EquipType { uziWeapon, starWeapon, bazookaWeapon, sniperWeapon, laserWeapon, meleeEquip }
+
 var blockDimZs = map[BlockType]float64 {
 	archBlock: 8.0,
 }
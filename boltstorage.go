@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	matchesBucket = []byte("matches")
+	playersBucket = []byte("players")
+	roomsBucket = []byte("rooms")
+)
+
+type BoltStorage struct {
+	db *bbolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(matchesBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(roomsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(playersBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage {
+		db: db,
+	}, nil
+}
+
+// Stores the match and updates the stats of everyone in it in one transaction.
+func (bs *BoltStorage) RecordMatch(match MatchRecord) error {
+	return bs.db.Update(func(tx *bbolt.Tx) error {
+		matches := tx.Bucket(matchesBucket)
+		seq, err := matches.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(match)
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := matches.Put(key, b); err != nil {
+			return err
+		}
+
+		players := tx.Bucket(playersBucket)
+		stats := make(map[string]PlayerStats)
+		for _, player := range(match.Players) {
+			playerStats := NewPlayerStats(player.Name)
+			if v := players.Get([]byte(player.Name)); v != nil {
+				if err := json.Unmarshal(v, &playerStats); err != nil {
+					return err
+				}
+			}
+			stats[player.Name] = playerStats
+		}
+
+		applyMatch(match, stats)
+		for name, playerStats := range(stats) {
+			b, err := json.Marshal(playerStats)
+			if err != nil {
+				return err
+			}
+			if err := players.Put([]byte(name), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *BoltStorage) GetPlayerStats(name string) (PlayerStats, bool, error) {
+	stats := NewPlayerStats(name)
+	found := false
+
+	err := bs.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(playersBucket).Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &stats)
+	})
+	return stats, found, err
+}
+
+func (bs *BoltStorage) SaveRoomSnapshot(name string, snapshot []byte) error {
+	return bs.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(roomsBucket).Put([]byte(name), snapshot)
+	})
+}
+
+func (bs *BoltStorage) DeleteRoomSnapshot(name string) error {
+	return bs.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(roomsBucket).Delete([]byte(name))
+	})
+}
+
+// Values are copied since bolt's are only valid during the transaction
+func (bs *BoltStorage) GetRoomSnapshots() (map[string][]byte, error) {
+	snapshots := make(map[string][]byte)
+	err := bs.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(roomsBucket).ForEach(func(k, v []byte) error {
+			snapshots[string(k)] = append([]byte(nil), v...)
+			return nil
+		})
+	})
+	return snapshots, err
+}
+
+func (bs *BoltStorage) Close() error {
+	return bs.db.Close()
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"math"
+)
+
+type BroadphaseType uint8
+const (
+	unknownBroadphase BroadphaseType = iota
+	mapBroadphase
+	hashBroadphase
+)
+
+// Coarse spatial index used to find objects that might overlap before running the real profile checks.
+type Broadphase interface {
+	// Insert or move the object. Should be cheap when the object stays in the same cells.
+	Upsert(object Object)
+	Delete(sid SpacedId)
+	Has(sid SpacedId) bool
+
+	// Appends every indexed object with bounds that share a cell with box, each object at most once.
+	Query(box AABB, results []Object) []Object
+}
+
+func NewBroadphase(broadphaseType BroadphaseType, unitLength int, unitHeight int) Broadphase {
+	switch broadphaseType {
+	case mapBroadphase:
+		return NewMapBroadphase(unitLength, unitHeight)
+	case hashBroadphase:
+		return NewHashBroadphase(unitLength, unitHeight)
+	}
+	return NewHashBroadphase(unitLength, unitHeight)
+}
+
+type AABB struct {
+	Min Vec2
+	Max Vec2
+}
+
+func NewAABB(pos Vec2, dim Vec2) AABB {
+	return AABB {
+		Min: NewVec2(pos.X - dim.X / 2, pos.Y - dim.Y / 2),
+		Max: NewVec2(pos.X + dim.X / 2, pos.Y + dim.Y / 2),
+	}
+}
+
+func NewAABBFromLine(line Line) AABB {
+	end := line.Endpoint()
+	return AABB {
+		Min: NewVec2(Min(line.O.X, end.X), Min(line.O.Y, end.Y)),
+		Max: NewVec2(Max(line.O.X, end.X), Max(line.O.Y, end.Y)),
+	}
+}
+
+func ObjectAABB(object Object) AABB {
+	return NewAABB(object.Pos(), object.Dim())
+}
+
+func (box AABB) Overlaps(other AABB) bool {
+	return box.Min.X <= other.Max.X && box.Max.X >= other.Min.X && box.Min.Y <= other.Max.Y && box.Max.Y >= other.Min.Y
+}
+
+// Inclusive range of cell indices covered by a box
+type cellRange struct {
+	xmin, xmax, ymin, ymax int
+}
+
+func newCellRange(box AABB, unitLength int, unitHeight int) cellRange {
+	length := float64(unitLength)
+	height := float64(unitHeight)
+	return cellRange {
+		xmin: int(math.Floor(box.Min.X / length)),
+		xmax: int(math.Floor(box.Max.X / length)),
+		ymin: int(math.Floor(box.Min.Y / height)),
+		ymax: int(math.Floor(box.Max.Y / height)),
+	}
+}
+
+// Original broadphase: a set of objects per cell plus a list of cells per object.
+// Allocates on every move and every query, so it's mostly kept around for comparison.
+type MapBroadphase struct {
+	unitLength int
+	unitHeight int
+
+	cells map[GridCoord]map[SpacedId]Object
+	reverseCells map[SpacedId][]GridCoord
+}
+
+func NewMapBroadphase(unitLength int, unitHeight int) *MapBroadphase {
+	return &MapBroadphase {
+		unitLength: unitLength,
+		unitHeight: unitHeight,
+
+		cells: make(map[GridCoord]map[SpacedId]Object),
+		reverseCells: make(map[SpacedId][]GridCoord),
+	}
+}
+
+func (mb *MapBroadphase) Upsert(object Object) {
+	sid := object.GetSpacedId()
+	coords := mb.getCoords(ObjectAABB(object))
+
+	if currentCoords, ok := mb.reverseCells[sid]; ok && len(coords) == len(currentCoords) {
+		equal := true
+		for i := range(coords) {
+			if coords[i] != currentCoords[i] {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return
+		}
+	}
+
+	mb.Delete(sid)
+	for _, coord := range(coords) {
+		if _, ok := mb.cells[coord]; !ok {
+			mb.cells[coord] = make(map[SpacedId]Object)
+		}
+		mb.cells[coord][sid] = object
+	}
+	mb.reverseCells[sid] = coords
+}
+
+func (mb *MapBroadphase) Delete(sid SpacedId) {
+	if coords, ok := mb.reverseCells[sid]; ok {
+		for _, coord := range(coords) {
+			delete(mb.cells[coord], sid)
+		}
+		delete(mb.reverseCells, sid)
+	}
+}
+
+func (mb MapBroadphase) Has(sid SpacedId) bool {
+	_, ok := mb.reverseCells[sid]
+	return ok
+}
+
+func (mb *MapBroadphase) Query(box AABB, results []Object) []Object {
+	seen := make(map[SpacedId]bool)
+	for _, coord := range(mb.getCoords(box)) {
+		for sid, object := range(mb.cells[coord]) {
+			if seen[sid] {
+				continue
+			}
+			seen[sid] = true
+			results = append(results, object)
+		}
+	}
+	return results
+}
+
+func (mb MapBroadphase) getCoords(box AABB) []GridCoord {
+	cells := newCellRange(box, mb.unitLength, mb.unitHeight)
+	coords := make([]GridCoord, 0)
+	for x := cells.xmin; x <= cells.xmax; x += 1 {
+		for y := cells.ymin; y <= cells.ymax; y += 1 {
+			coords = append(coords, GridCoord{x: x, y: y})
+		}
+	}
+	return coords
+}
+
+type hashEntry struct {
+	object Object
+	cells cellRange
+	queryId uint32
+}
+
+// Spatial hash that reuses its cell slices and entries, so steady state movement and queries don't allocate.
+type HashBroadphase struct {
+	unitLength int
+	unitHeight int
+
+	cells map[GridCoord][]*hashEntry
+	entries map[SpacedId]*hashEntry
+	freeEntries []*hashEntry
+
+	// Incremented per query to skip entries spanning multiple cells without building a set
+	queryId uint32
+}
+
+func NewHashBroadphase(unitLength int, unitHeight int) *HashBroadphase {
+	return &HashBroadphase {
+		unitLength: unitLength,
+		unitHeight: unitHeight,
+
+		cells: make(map[GridCoord][]*hashEntry),
+		entries: make(map[SpacedId]*hashEntry),
+		freeEntries: make([]*hashEntry, 0),
+
+		queryId: 0,
+	}
+}
+
+func (hb *HashBroadphase) Upsert(object Object) {
+	sid := object.GetSpacedId()
+	cells := newCellRange(ObjectAABB(object), hb.unitLength, hb.unitHeight)
+
+	entry, ok := hb.entries[sid]
+	if ok {
+		// Object may have been replaced with the same id
+		entry.object = object
+		if entry.cells == cells {
+			return
+		}
+		hb.removeFromCells(entry)
+	} else {
+		entry = hb.newEntry(object)
+		hb.entries[sid] = entry
+	}
+
+	entry.cells = cells
+	hb.addToCells(entry)
+}
+
+func (hb *HashBroadphase) Delete(sid SpacedId) {
+	entry, ok := hb.entries[sid]
+	if !ok {
+		return
+	}
+
+	hb.removeFromCells(entry)
+	delete(hb.entries, sid)
+
+	entry.object = nil
+	hb.freeEntries = append(hb.freeEntries, entry)
+}
+
+func (hb HashBroadphase) Has(sid SpacedId) bool {
+	_, ok := hb.entries[sid]
+	return ok
+}
+
+func (hb *HashBroadphase) Query(box AABB, results []Object) []Object {
+	hb.queryId += 1
+	cells := newCellRange(box, hb.unitLength, hb.unitHeight)
+
+	for x := cells.xmin; x <= cells.xmax; x += 1 {
+		for y := cells.ymin; y <= cells.ymax; y += 1 {
+			for _, entry := range(hb.cells[GridCoord{x: x, y: y}]) {
+				if entry.queryId == hb.queryId {
+					continue
+				}
+				entry.queryId = hb.queryId
+				results = append(results, entry.object)
+			}
+		}
+	}
+	return results
+}
+
+func (hb *HashBroadphase) newEntry(object Object) *hashEntry {
+	if n := len(hb.freeEntries); n > 0 {
+		entry := hb.freeEntries[n - 1]
+		hb.freeEntries = hb.freeEntries[:n - 1]
+		entry.object = object
+		entry.queryId = 0
+		return entry
+	}
+
+	return &hashEntry {
+		object: object,
+	}
+}
+
+func (hb *HashBroadphase) addToCells(entry *hashEntry) {
+	for x := entry.cells.xmin; x <= entry.cells.xmax; x += 1 {
+		for y := entry.cells.ymin; y <= entry.cells.ymax; y += 1 {
+			coord := GridCoord{x: x, y: y}
+			hb.cells[coord] = append(hb.cells[coord], entry)
+		}
+	}
+}
+
+func (hb *HashBroadphase) removeFromCells(entry *hashEntry) {
+	for x := entry.cells.xmin; x <= entry.cells.xmax; x += 1 {
+		for y := entry.cells.ymin; y <= entry.cells.ymax; y += 1 {
+			coord := GridCoord{x: x, y: y}
+			cell := hb.cells[coord]
+			for i, other := range(cell) {
+				if other != entry {
+					continue
+				}
+
+				// Swap remove, order within a cell doesn't matter
+				last := len(cell) - 1
+				cell[i] = cell[last]
+				cell[last] = nil
+				hb.cells[coord] = cell[:last]
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const (
+	benchPlayers int = 20
+	benchProjectiles int = 500
+)
+
+// Everything with cell bounds that share a cell with box, which is what a broadphase should return
+func bruteForceQuery(objects map[SpacedId]Object, box AABB, unitLength int, unitHeight int) map[SpacedId]bool {
+	cells := newCellRange(box, unitLength, unitHeight)
+	results := make(map[SpacedId]bool)
+	for sid, object := range(objects) {
+		other := newCellRange(ObjectAABB(object), unitLength, unitHeight)
+		if other.xmin <= cells.xmax && other.xmax >= cells.xmin && other.ymin <= cells.ymax && other.ymax >= cells.ymin {
+			results[sid] = true
+		}
+	}
+	return results
+}
+
+func queryIds(t *testing.T, name string, results []Object) map[SpacedId]bool {
+	t.Helper()
+
+	ids := make(map[SpacedId]bool)
+	for _, object := range(results) {
+		if ids[object.GetSpacedId()] {
+			t.Errorf("%s: returned %v more than once", name, object.GetSpacedId())
+		}
+		ids[object.GetSpacedId()] = true
+	}
+	return ids
+}
+
+func randomAABB(r *rand.Rand) (Vec2, Vec2) {
+	return NewVec2(r.Float64() * 60 - 10, r.Float64() * 40 - 10), NewVec2(0.1 + r.Float64() * 6, 0.1 + r.Float64() * 6)
+}
+
+func TestBroadphaseMatchesBruteForce(t *testing.T) {
+	grid := NewGrid(4, 4)
+	r := rand.New(rand.NewSource(1))
+	broadphases := map[string]Broadphase {
+		"map": NewMapBroadphase(4, 4),
+		"hash": NewHashBroadphase(4, 4),
+	}
+	objects := make(map[SpacedId]Object)
+	deleted := make(map[SpacedId]bool)
+
+	for i := 0; i < 2000; i += 1 {
+		switch op := r.Intn(10); {
+		case op < 4 || len(objects) == 0:
+			pos, dim := randomAABB(r)
+			object := grid.New(NewInit(grid.NextSpacedId(wallSpace), pos, dim))
+			objects[object.GetSpacedId()] = object
+			for _, bp := range(broadphases) {
+				bp.Upsert(object)
+			}
+		case op < 7:
+			// Small moves mostly stay in the same cells, large ones don't
+			for _, object := range(objects) {
+				pos := object.Pos()
+				pos.Add(NewVec2(r.Float64() - 0.5, r.Float64() - 0.5), float64(1 + r.Intn(2) * 10))
+				object.SetPos(pos)
+				for _, bp := range(broadphases) {
+					bp.Upsert(object)
+				}
+				break
+			}
+		default:
+			for sid := range(objects) {
+				delete(objects, sid)
+				deleted[sid] = true
+				for _, bp := range(broadphases) {
+					bp.Delete(sid)
+				}
+				break
+			}
+		}
+
+		pos, dim := randomAABB(r)
+		box := NewAABB(pos, dim)
+		expected := bruteForceQuery(objects, box, 4, 4)
+		for name, bp := range(broadphases) {
+			results := queryIds(t, name, bp.Query(box, make([]Object, 0)))
+			if len(results) != len(expected) {
+				t.Fatalf("%s: step %d expected %d results, got %d", name, i, len(expected), len(results))
+			}
+			for sid := range(expected) {
+				if !results[sid] {
+					t.Fatalf("%s: step %d missing %v", name, i, sid)
+				}
+			}
+		}
+	}
+
+	for name, bp := range(broadphases) {
+		for sid := range(objects) {
+			if !bp.Has(sid) {
+				t.Errorf("%s: expected to have %v", name, sid)
+			}
+		}
+		for sid := range(deleted) {
+			if bp.Has(sid) {
+				t.Errorf("%s: expected %v to be deleted", name, sid)
+			}
+		}
+	}
+}
+
+// Callers own the result, so nested queries can't overwrite it
+func TestNearbyObjectsSurviveQueries(t *testing.T) {
+	grid := NewGrid(4, 4)
+	player := grid.New(NewInit(grid.NextSpacedId(playerSpace), NewVec2(0, 0), NewVec2(0.8, 1.44)))
+	grid.Upsert(player)
+	wall := grid.New(NewInit(grid.NextSpacedId(wallSpace), NewVec2(0.5, 0), NewVec2(1, 1)))
+	grid.Upsert(wall)
+	far := grid.New(NewInit(grid.NextSpacedId(wallSpace), NewVec2(20, 0), NewVec2(1, 1)))
+	grid.Upsert(far)
+
+	nearby := grid.GetNearbyObjects(player, make([]Object, 0))
+	if len(nearby) != 1 || nearby[0] != wall {
+		t.Fatalf("expected the wall to be nearby, got %d objects", len(nearby))
+	}
+
+	options := NewColliderOptions()
+	options.SetSpaces(wallSpace)
+	if hit := grid.RaycastFirst(NewLine(NewVec2(15, 0), NewVec2(10, 0)), options); !hit.GetHit() || hit.GetObject() != far {
+		t.Fatalf("expected raycast to hit the far wall")
+	}
+	grid.GetNearbyObjects(far, make([]Object, 0))
+	grid.GetColliders(far)
+	if nearby[0] != wall {
+		t.Errorf("expected queries to leave nearby objects alone, got %v", nearby[0].GetSpacedId())
+	}
+}
+
+func newBenchGame(b *testing.B, broadphaseType BroadphaseType) *Game {
+	b.Helper()
+
+	game := NewGame()
+	game.grid = NewGridWithBroadphase(broadphaseType, 4, 4)
+	game.LoadLevel(birdTownLevel, 1234)
+
+	for i := 0; i < benchPlayers; i += 1 {
+		player := game.Add(NewInit(Id(playerSpace, IdType(i)), NewVec2(0, 0), NewVec2(0.8, 1.44))).(*Player)
+		player.SetTeam(uint8(1 + i % 2))
+		player.SetSpawn(game.GetGrid())
+		player.Respawn()
+	}
+	return game
+}
+
+func addBenchProjectile(game *Game, r *rand.Rand) {
+	grid := game.GetGrid()
+	players := grid.GetObjects(playerSpace)
+	owner := players[IdType(r.Intn(len(players)))]
+
+	space := starSpace
+	if r.Intn(2) == 0 {
+		space = pelletSpace
+	}
+
+	dir := NewVec2FromAngle(r.Float64() * 6.28)
+	init := NewInit(grid.NextSpacedId(space), owner.Pos(), NewVec2(0.3, 0.3))
+	init.SetInitDir(dir)
+	projectile := grid.New(init)
+	projectile.SetOwner(owner.GetSpacedId())
+	dir.Scale(25)
+	projectile.SetVel(dir)
+	grid.Upsert(projectile)
+}
+
+func countBenchProjectiles(game *Game) int {
+	grid := game.GetGrid()
+	return len(grid.GetObjects(starSpace)) + len(grid.GetObjects(pelletSpace))
+}
+
+// Full game tick with star and uzi spam, topping projectiles back up as they expire.
+func BenchmarkGameUpdate(b *testing.B) {
+	for _, bc := range([]struct {
+		name string
+		broadphaseType BroadphaseType
+	}{
+		{"map", mapBroadphase},
+		{"hash", hashBroadphase},
+	}) {
+		b.Run(bc.name, func(b *testing.B) {
+			game := newBenchGame(b, bc.broadphaseType)
+			r := rand.New(rand.NewSource(1))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1 {
+				for count := countBenchProjectiles(game); count < benchProjectiles; count += 1 {
+					addBenchProjectile(game, r)
+				}
+				game.Update()
+				game.createObjectUpdateMsg()
+			}
+		})
+	}
+}
+
+// Moves every object and runs a collision query for it, isolating the broadphase from game logic.
+func BenchmarkBroadphaseTick(b *testing.B) {
+	for _, bc := range([]struct {
+		name string
+		broadphaseType BroadphaseType
+	}{
+		{"map", mapBroadphase},
+		{"hash", hashBroadphase},
+	}) {
+		b.Run(bc.name, func(b *testing.B) {
+			game := newBenchGame(b, bc.broadphaseType)
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < benchProjectiles; i += 1 {
+				addBenchProjectile(game, r)
+			}
+
+			grid := game.GetGrid()
+			movers := make([]Object, 0)
+			nearby := make([]Object, 0)
+			for _, object := range(grid.GetAllObjects()) {
+				if !object.HasAttribute(fromLevelAttribute) {
+					movers = append(movers, object)
+				}
+			}
+
+			ts := float64(frameTime) / float64(time.Second)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1 {
+				for _, object := range(movers) {
+					// Bounce around the level so objects keep crossing cells without flying off forever
+					vel := object.Vel()
+					pos := object.Pos()
+					if pos.X < 0 || pos.X > 150 {
+						vel.X = -vel.X
+					}
+					if pos.Y < -10 || pos.Y > 40 {
+						vel.Y = -vel.Y
+					}
+					object.SetVel(vel)
+					pos.Add(vel, ts)
+					object.SetPos(pos)
+					grid.Upsert(object)
+					nearby = grid.GetNearbyObjects(object, nearby[:0])
+				}
+			}
+		})
+	}
+}
@@ -64,6 +64,7 @@ func (circ Circle) Intersects(line Line) IntersectResults {
   		result.hit = true
   		result.t = t1
   		result.tmax = t1
+  		result.normal = circ.normalAt(line.Point(t1))
   		results.Merge(result)
   	}
 
@@ -73,11 +74,19 @@ func (circ Circle) Intersects(line Line) IntersectResults {
 		result.hit = true
   		result.t = t2
   		result.tmax = t2
+  		result.normal = circ.normalAt(line.Point(t2))
   		results.Merge(result)
   	}
 	return results
 }
 
+func (c Circle) normalAt(point Vec2) Vec2 {
+	normal := point
+	normal.Sub(c.Pos(), 1.0)
+	normal.Normalize()
+	return normal
+}
+
 func (c Circle) OverlapProfile(profile Profile) CollideResult {
 	results := c.BaseProfile.OverlapProfile(profile)
 
@@ -5,10 +5,18 @@ import (
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
-	"log"
+	"math"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	// Give up on WebRTC and send game data over the websocket after this long
+	dataChannelTimeout time.Duration = 10 * time.Second
+
+	// Only send every nth frame of object data when falling back to the websocket
+	websocketFrameSkip int = 2
 )
 
 // Incoming client message to parse
@@ -24,9 +32,33 @@ type Client struct {
 	dc *webrtc.DataChannel
 	mu sync.Mutex
 
+	// Either side can start a renegotiation when voice tracks change
+	negotiationMu sync.Mutex
+	negotiationPending bool
+
+	// Chosen once, either when the data channel opens or when it times out
+	transport TransportType
+	transportOnce sync.Once
+	transportTimer *time.Timer
+	closed bool
+	frames int
+
 	id IdType
 	name string
 	voice bool
+
+	// Negotiated when connecting, used to adapt messages for older clients
+	protocol int
+	objectEncoding ObjectEncodingType
+
+	keyValidator *KeyValidator
+
+	// Team reserved by matchmaking, 0 if none
+	team uint8
+	// Counts against the room's max players until unregistered
+	member bool
+
+	log Logger
 }
 
 func NewClient(room* Room, ws *websocket.Conn, name string, id IdType) *Client {
@@ -36,9 +68,23 @@ func NewClient(room* Room, ws *websocket.Conn, name string, id IdType) *Client {
 		wrtc: nil,
 		dc: nil,
 
+		transport: unknownTransport,
+		transportTimer: nil,
+		closed: false,
+		frames: 0,
+
 		id: id,
 		name: name,
 		voice: false,
+		protocol: legacyProtocolVersion,
+		objectEncoding: msgpackObjectEncoding,
+
+		keyValidator: NewKeyValidator(),
+
+		team: 0,
+		member: false,
+
+		log: room.log.With("client", id),
 	}
 	go client.run()
 	return client
@@ -46,15 +92,16 @@ func NewClient(room* Room, ws *websocket.Conn, name string, id IdType) *Client {
 
 func (c *Client) run() {
 	defer func() {
-		c.room.unregister <- c
+		c.room.sendUnregister(c)
 	}()
+	defer c.recoverPanic(unknownType)
 
 	for {
 		_, b, err := c.ws.ReadMessage()
 
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				c.print(fmt.Sprintf("unexpected socket error: %v", err))
+				c.log.Warn("unexpected socket error: %v", err)
 			}
 			return
 		}
@@ -63,11 +110,11 @@ func (c *Client) run() {
 			b: b,
 			client: c,
 		}
-		c.room.incoming <- imsg
+		c.room.sendIncoming(imsg)
 	}
 }
 
-func (c Client) GetDisplayName() string {
+func (c *Client) GetDisplayName() string {
 	return c.name + " #" + strconv.Itoa(int(c.id))
 }
 
@@ -75,9 +122,20 @@ func (c *Client) GetClientData() ClientData {
 	return ClientData {
 		Id: c.id,
 		Name: c.name,
+		Transport: c.GetTransport(),
 	}
 }
 
+func (c *Client) SupportsProtocol(version int) bool {
+	return c.protocol >= version
+}
+
+func (c *Client) GetTransport() TransportType {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.transport
+}
+
 func (c *Client) Send(msg interface{}) error {
 	b := Pack(msg)
 	return c.SendBytes(b)
@@ -97,13 +155,34 @@ func (c *Client) SendUDP(msg interface{}) error {
 }
 
 func (c *Client) SendBytesUDP(b []byte) error {
-	if c.dc == nil {
-		return errors.New("Data channel not initialized")
+	switch c.GetTransport() {
+	case webRTCTransport:
+		return c.dc.Send(b)
+	case websocketTransport:
+		return c.SendBytes(b)
 	}
-	return c.dc.Send(b)
+	return errors.New("Data channel not initialized")
+}
+
+// Object data is resent every frame, so the websocket fallback can drop some of it to keep up
+func (c *Client) SendObjectDataUDP(b []byte) error {
+	if c.GetTransport() == websocketTransport {
+		c.frames += 1
+		if c.frames % websocketFrameSkip != 0 {
+			return nil
+		}
+	}
+	return c.SendBytesUDP(b)
 }
 
 func (c *Client) Close() {
+	c.mu.Lock()
+	c.closed = true
+	if c.transportTimer != nil {
+		c.transportTimer.Stop()
+	}
+	c.mu.Unlock()
+
 	if c.dc != nil {
 		c.dc.Close()
 	}
@@ -113,28 +192,46 @@ func (c *Client) Close() {
 	c.ws.Close()
 }
 
+// Tells the client why before disconnecting, the room unregisters the client once the socket closes
+func (c *Client) Kick(reason string) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason), time.Now().Add(time.Second))
+	c.mu.Unlock()
+
+	c.Close()
+}
+
+// Deferred by goroutines outside the room loop, like Pion callbacks, so a panic only disconnects the client
+func (c *Client) recoverPanic(msgType MessageType) {
+	if p := recover(); p != nil {
+		NewPanicReport(c.room, c, msgType, p).Log()
+		c.Kick("Server error")
+	}
+}
+
+// Calls onSuccess once game data can be sent, over WebRTC or the websocket fallback
 func (c *Client) InitWebRTC(onSuccess func()) error {
 	var err error
 	config := webrtc.Configuration{
 		ICEServers: []webrtc.ICEServer{
 			{
-				URLs: []string{
-					"stun:stun.l.google.com:19302",
-					"stun:stun2.l.google.com:19302",
-					"stun:openrelay.metered.ca:80",
-				},
+				URLs: stunURLs,
 			},
 		},
 	}
 
-	c.print("starting new WebRTC connection")
+	c.log.Debug("starting new WebRTC connection")
 	c.wrtc, err = webrtc.NewPeerConnection(config)
 	if err != nil {
 		return err
 	}
 
 	c.wrtc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		c.print(fmt.Sprintf("WebRTC connection state: %s", s.String()))
+		c.log.Info("WebRTC connection state: %s", s.String())
 	})
 
 	ordered := false
@@ -149,19 +246,36 @@ func (c *Client) InitWebRTC(onSuccess func()) error {
 	}
 
 	c.dc.OnOpen(func() {
-		onSuccess()
-		c.print(fmt.Sprintf("opened data channel: %s-%d", c.dc.Label(), c.dc.ID()))
+		defer c.recoverPanic(unknownType)
+		c.log.Info("opened data channel: %s-%d", c.dc.Label(), c.dc.ID())
+		c.setTransport(webRTCTransport, onSuccess)
+	})
+
+	c.mu.Lock()
+	c.transportTimer = time.AfterFunc(dataChannelTimeout, func() {
+		defer c.recoverPanic(unknownType)
+		c.setTransport(websocketTransport, onSuccess)
 	})
+	c.mu.Unlock()
 
 	c.dc.OnMessage(func(msg webrtc.DataChannelMessage) {
 		imsg := IncomingMsg{
 			b: msg.Data,
 			client: c,
 		}
-		c.room.incoming <- imsg
+		c.room.sendIncoming(imsg)
+	})
+
+	c.wrtc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		defer c.recoverPanic(unknownType)
+		if c.room.sfu == nil || remote.Kind() != webrtc.RTPCodecTypeAudio {
+			return
+		}
+		c.room.sfu.Publish(c, remote)
 	})
 
 	c.wrtc.OnICECandidate(func(ice *webrtc.ICECandidate) {
+		defer c.recoverPanic(candidateType)
 		if ice == nil {
 			return
 		}
@@ -177,18 +291,118 @@ func (c *Client) InitWebRTC(onSuccess func()) error {
 	return nil
 }
 
+// The first transport wins, so a data channel that opens late is only used for incoming messages
+func (c *Client) setTransport(transport TransportType, onSuccess func()) {
+	c.transportOnce.Do(func() {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		c.transport = transport
+		if c.transportTimer != nil {
+			c.transportTimer.Stop()
+		}
+		c.mu.Unlock()
+
+		if transport == websocketTransport {
+			c.log.Warn("data channel did not open after %v, falling back to websocket", dataChannelTimeout)
+		}
+		onSuccess()
+	})
+}
+
+// Sends a new offer when tracks were added or removed, or waits if a negotiation is in progress
+func (c *Client) Renegotiate() error {
+	c.negotiationMu.Lock()
+	defer c.negotiationMu.Unlock()
+
+	return c.renegotiate()
+}
+
+// Requires negotiationMu
+func (c *Client) renegotiate() error {
+	if c.wrtc.SignalingState() != webrtc.SignalingStateStable {
+		c.negotiationPending = true
+		return nil
+	}
+	c.negotiationPending = false
+
+	offer, err := c.wrtc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	err = c.wrtc.SetLocalDescription(offer)
+	if err != nil {
+		return err
+	}
+
+	offerMsg := JSONMsg {
+		T: offerType,
+		JSON: offer,
+	}
+	return c.Send(&offerMsg)
+}
+
+func (c *Client) processWebRTCAnswer(json interface{}) error {
+	c.log.Debug("received WebRTC answer")
+
+	answer, ok := json.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Unable to parse answer: %+v", json)
+	}
+	sdp, ok := answer["sdp"].(string)
+	if !ok {
+		return fmt.Errorf("Answer is missing SDP: %+v", json)
+	}
+
+	c.negotiationMu.Lock()
+	defer c.negotiationMu.Unlock()
+
+	err := c.wrtc.SetRemoteDescription(webrtc.SessionDescription {
+		Type: webrtc.SDPTypeAnswer,
+		SDP: sdp,
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.negotiationPending {
+		return c.renegotiate()
+	}
+	return nil
+}
+
 func (c *Client) processWebRTCOffer(json interface{}) error {
-	c.print("received WebRTC offer")
+	c.log.Debug("received WebRTC offer")
 
 	offer, ok := json.(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("Unable to parse offer: %+v", json)
 	}
+	sdp, ok := offer["sdp"].(string)
+	if !ok {
+		return fmt.Errorf("Offer is missing SDP: %+v", json)
+	}
 	var err error
 
+	c.negotiationMu.Lock()
+	defer c.negotiationMu.Unlock()
+
+	// Both sides offered at the same time, so drop ours and send it again afterwards
+	if c.wrtc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		err = c.wrtc.SetLocalDescription(webrtc.SessionDescription {
+			Type: webrtc.SDPTypeRollback,
+		})
+		if err != nil {
+			return err
+		}
+		c.negotiationPending = true
+	}
+
 	desc := webrtc.SessionDescription {
 		Type: webrtc.SDPTypeOffer,
-		SDP: offer["sdp"].(string),
+		SDP: sdp,
 	}
 	err = c.wrtc.SetRemoteDescription(desc)
 	if err != nil {
@@ -206,11 +420,15 @@ func (c *Client) processWebRTCOffer(json interface{}) error {
 		JSON: answer,
 	}
 	c.Send(&answerMsg)
+
+	if c.negotiationPending {
+		return c.renegotiate()
+	}
 	return nil
 }
 
 func (c *Client) processWebRTCCandidate(json interface{}) error {
-	c.print("received WebRTC ICE candidate")
+	c.log.Debug("received WebRTC ICE candidate")
 
 	candidate, ok := json.(map[string]interface{})
 	if !ok {
@@ -218,10 +436,22 @@ func (c *Client) processWebRTCCandidate(json interface{}) error {
 	}
 	var err error
 
-	sdpMid := candidate["sdpMid"].(string)
-	sdpMLineIndex := uint16(candidate["sdpMLineIndex"].(int8))
+	candidateString, ok := candidate["candidate"].(string)
+	if !ok {
+		return fmt.Errorf("Candidate is missing candidate: %+v", json)
+	}
+	sdpMid, ok := candidate["sdpMid"].(string)
+	if !ok {
+		return fmt.Errorf("Candidate is missing sdpMid: %+v", json)
+	}
+	// msgpack decodes small numbers to the smallest type that fits
+	sdpMLineIndex, ok := parseUint16(candidate["sdpMLineIndex"])
+	if !ok {
+		return fmt.Errorf("Candidate has invalid sdpMLineIndex: %+v", json)
+	}
+
 	candidateInit := webrtc.ICECandidateInit {
-		Candidate: candidate["candidate"].(string),
+		Candidate: candidateString,
 		SDPMid: &sdpMid,
 		SDPMLineIndex: &sdpMLineIndex,
 	}
@@ -232,12 +462,34 @@ func (c *Client) processWebRTCCandidate(json interface{}) error {
 	return nil
 }
 
-func (c Client) print(message string) {
-   	var sb strings.Builder
-   	sb.WriteString(c.room.name)
-   	sb.WriteString("/")
-   	sb.WriteString(c.GetDisplayName())
-   	sb.WriteString(": ")
-   	sb.WriteString(message)
-	log.Printf(sb.String())
-}
\ No newline at end of file
+func parseUint16(value interface{}) (uint16, bool) {
+	var n int64
+	switch v := value.(type) {
+	case int8:
+		n = int64(v)
+	case int16:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint8:
+		n = int64(v)
+	case uint16:
+		return v, true
+	case uint32:
+		n = int64(v)
+	case uint64:
+		if v > math.MaxUint16 {
+			return 0, false
+		}
+		n = int64(v)
+	default:
+		return 0, false
+	}
+
+	if n < 0 || n > math.MaxUint16 {
+		return 0, false
+	}
+	return uint16(n), true
+}
@@ -40,6 +40,9 @@ export class ClientHandler implements InterfaceHandler {
 	private _iceCandidates : Map<number, Array<RTCIceCandidate>>;
 	private _voiceEnabled : boolean;
 
+	// Server forwards voice over the main connection instead of a connection per peer
+	private _sfu : boolean;
+
 	private _stream : MediaStream;
 
 
@@ -49,6 +52,7 @@ export class ClientHandler implements InterfaceHandler {
 		this._peerConnections = new Map();
 		this._iceCandidates = new Map();
 		this._voiceEnabled = false;
+		this._sfu = false;
 	}
 
 	setup() : void {
@@ -59,6 +63,11 @@ export class ClientHandler implements InterfaceHandler {
 		connection.addHandler(voiceOfferType, (msg : { [k: string]: any }) => { this.processVoiceOffer(msg); });
 		connection.addHandler(voiceAnswerType, (msg : { [k: string]: any }) => { this.processVoiceAnswer(msg); });
 		connection.addHandler(voiceCandidateType, (msg : { [k: string]: any }) => { this.processVoiceCandidate(msg); });
+		connection.setTrackHandler((id : number, stream : MediaStream) => {
+			if (this._clients.has(id)) {
+				this._clients.get(id).enableVoiceControls(stream);
+			}
+		});
 
 		this._clientsElm.onclick = (e) => {
 			e.stopPropagation();
@@ -99,6 +108,7 @@ export class ClientHandler implements InterfaceHandler {
 	      	this._stream.getTracks().forEach((track) => { track.stop(); });
 			this._peerConnections.forEach((pc) => { pc.close(); });
 			this._peerConnections.clear();
+			connection.removeVoiceStream();
 
 			this._clients.forEach((client) => { client.disableVoiceControls(); });
 
@@ -136,6 +146,7 @@ export class ClientHandler implements InterfaceHandler {
 
 	private updateVoice(msg : { [k: string]: any }) : void {
 		if (msg.T === joinVoiceType) {
+			this._sfu = Util.defined(msg.SFU) && msg.SFU;
 			this.addVoice(msg.Client.Id, msg.Clients);
 		} else if (msg.T === leftVoiceType) {
 			this.removeVoice(msg.Client.Id);
@@ -150,6 +161,13 @@ export class ClientHandler implements InterfaceHandler {
 			return;
 		}
 
+		if (this._sfu) {
+			if (id === connection.id()) {
+				connection.addVoiceStream(this._stream);
+			}
+			return;
+		}
+
 		// Create connection for the new client.
 		if (id != connection.id()) {
 			this.createPeerConnection(id, /*sendOffer=*/false);
@@ -173,6 +191,11 @@ export class ClientHandler implements InterfaceHandler {
 			return;
 		}
 
+		if (this._sfu && this._clients.has(id)) {
+			this._clients.get(id).disableVoiceControls();
+			return;
+		}
+
 		if (this._peerConnections.has(id)) {
 			this._peerConnections.get(id).close();
 			this._peerConnections.delete(id);
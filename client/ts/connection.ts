@@ -1,4 +1,5 @@
 import { encode, decode } from "@msgpack/msgpack"
+import { ObjectDecoder } from './object_decoder.js'
 import { Pinger } from './pinger.js'
 import { PerSecondTracker } from './tracker.js'
 import { ui } from './ui.js'
@@ -6,8 +7,9 @@ import { LogUtil, Util } from './util.js'
 
 type MessageHandler = (msg : any) => void;
 type MessageSender = () => void;
+type TrackHandler = (id : number, stream : MediaStream) => void;
 class Connection {
-	private readonly _iceConfig = {
+	private _iceConfig : RTCConfiguration = {
     	"iceServers": [
 	    	{
 	    		urls: [
@@ -23,18 +25,28 @@ class Connection {
 	private _senders : Map<number, MessageSender>;
 
 	private _id : number;
+	private _transport : number;
+	private _dcSuccess : () => void;
 
 	private _ws : WebSocket;
+	// Set when the server says the room lives on another node
+	private _redirect : string;
+	private _vars : Map<string, string>;
 	private _wrtc : RTCPeerConnection;
 	private _dc : RTCDataChannel;
 	private _candidates : Array<RTCIceCandidate>;
 	private _pinger : Pinger;
 
+	// Voice tracks sent to and forwarded by the server
+	private _voiceSenders : Array<RTCRtpSender>;
+	private _trackHandler : TrackHandler;
+
 	private _dataTracker : PerSecondTracker;
 
 	constructor() {
 		this._handlers = new Map();
 		this._senders = new Map();
+		this._voiceSenders = new Array<RTCRtpSender>();
 
 		this._dataTracker = new PerSecondTracker();
 	}
@@ -43,7 +55,51 @@ class Connection {
 		this.addHandler(initType, (msg : any) => {
 			this._id = msg.Client.Id;
 			LogUtil.d("Initialized connection with id " + this._id);
+
+			// Wait for the ICE config since it may contain TURN credentials for this client
+			if (Util.defined(msg.ICE)) {
+				this._iceConfig = {
+					iceServers: msg.ICE.Servers.map((server : any) => {
+						return {
+							urls: server.URLs,
+							username: server.Username,
+							credential: server.Credential,
+						};
+					}),
+					iceTransportPolicy: msg.ICE.Policy,
+				};
+			}
+			this.initWebRTC(this._dcSuccess);
+		});
+		this.addHandler(joinType, (msg : any) => {
+			if (msg.Client.Id !== this._id || msg.Client.Transport !== websocketTransport) {
+				return;
+			}
+
+			// Data channel never opened, so the server sends everything over the websocket
+			LogUtil.d("Falling back to websocket");
+			this._transport = msg.Client.Transport;
+			if (Util.defined(this._dcSuccess)) {
+				this._dcSuccess();
+			}
+		});
+		this.addHandler(versionType, (msg : any) => {
+			if (msg.Accepted) {
+				LogUtil.d("Using protocol version " + protocolVersion + ", server is on " + msg.Protocol);
+				return;
+			}
+
+			// Server closes the connection afterwards, then reconnect to the node that has the room
+			if (Util.defined(msg.Redirect) && msg.Redirect.length > 0) {
+				LogUtil.d("Redirected to " + msg.Redirect);
+				this._redirect = msg.Redirect;
+				return;
+			}
+
+			LogUtil.e("Server rejected protocol version " + protocolVersion + ": " + msg.Reason);
+			ui.print(msg.Reason);
 		});
+		this.addHandler(offerType, (msg : any) => { this.answerOffer(msg); });
 		this.addHandler(answerType, (msg : any) => { this.setRemoteDescription(msg); });
 		this.addHandler(candidateType, (msg : any) => { this.addIceCandidate(msg); });
 	}
@@ -54,20 +110,27 @@ class Connection {
 	wsReady() : boolean { return Util.defined(this._ws) && this._ws.readyState === 1; }
 	dcConnecting() : boolean { return Util.defined(this._dc) && (this._dc.readyState === "connecting" || this._dc.readyState === "open"); }
 	dcReady() : boolean { return Util.defined(this._dc) && this._dc.readyState === "open"; }
-	ready() : boolean { return Util.defined(this._id) && this.wsReady() && this.dcReady(); }
+	websocketFallback() : boolean { return this._transport === websocketTransport; }
+	ready() : boolean { return Util.defined(this._id) && this.wsReady() && (this.dcReady() || this.websocketFallback()); }
 
 	connect(vars : Map<string, string>, socketSuccess : () => void, dcSuccess : () => void) : void {
-		const prefix = Util.isDev() ? "ws://" : "wss://";
 		const server = Util.isDev() ? "localhost:8080" : window.location.host.includes("herokuapp") ? window.location.host : "blockdudes3.uc.r.appspot.com";
+		this.connectTo(server, vars, socketSuccess, dcSuccess);
+	}
+
+	private connectTo(server : string, vars : Map<string, string>, socketSuccess : () => void, dcSuccess : () => void) : void {
+		const prefix = Util.isDev() ? "ws://" : "wss://";
 		let endpoint = prefix + server + "/bd3/"
 		console.log("Using endpoint " + endpoint);
 		for (const [key, value] of vars) {
 			endpoint += key + "=" + value + "&";
 		}
+		endpoint += "version=" + protocolVersion + "&game=" + wasmVersion;
 		if (endpoint.endsWith("&")) {
 			endpoint = endpoint.slice(0, -1);
 		}
 
+		this._vars = vars;
 		this.initWebSocket(endpoint, socketSuccess, dcSuccess);
 	}
 
@@ -107,6 +170,27 @@ class Connection {
 		this._senders.delete(type);
 	}
 
+	setTrackHandler(handler : TrackHandler) : void {
+		this._trackHandler = handler;
+	}
+
+	addVoiceStream(stream : MediaStream) : void {
+		stream.getTracks().forEach((track) => {
+			this._voiceSenders.push(this._wrtc.addTrack(track, stream));
+		});
+		this.sendOffer();
+	}
+
+	removeVoiceStream() : void {
+		if (this._voiceSenders.length === 0) {
+			return;
+		}
+
+		this._voiceSenders.forEach((sender) => { this._wrtc.removeTrack(sender); });
+		this._voiceSenders.length = 0;
+		this.sendOffer();
+	}
+
 	bytesPerSecond() : number {
 		return this._dataTracker.flush();
 	}
@@ -130,6 +214,9 @@ class Connection {
 	}
 
 	sendData(msg :any) : boolean {
+		if (!this.dcReady() && this.websocketFallback()) {
+			return this.send(msg);
+		}
 		if (!this.dcReady()) {
 			LogUtil.d("Trying to send message (type " + msg.T + ") before data channel is ready!");
 			return false;
@@ -160,13 +247,22 @@ class Connection {
 			if (!Util.defined(this._pinger)) {
 				this._pinger = new Pinger();
 			}
-			socketSuccess();		
-			this.initWebRTC(dcSuccess);
+			socketSuccess();
+
+			// WebRTC starts once the server sends initType
+			this._dcSuccess = dcSuccess;
 		};
 		this._ws.onmessage = (event) => {	
 			this.handlePayload(event.data);
 		};
 		this._ws.onclose = (event) => {
+			if (Util.defined(this._redirect)) {
+				const server = this._redirect;
+				this._redirect = undefined;
+				this.connectTo(server, this._vars, socketSuccess, dcSuccess);
+				return;
+			}
+
 			console.error("Websocket closed!");
 			if (Util.defined(this._wrtc)) {
 				this._wrtc.close();
@@ -174,11 +270,14 @@ class Connection {
 			if (Util.defined(this._dc)) {
 				this._dc.close();
 			}
-			ui.disconnected();
+			// Server explains why it closed the connection, e.g. wrong room password
+			ui.disconnected(event.reason);
 		};
 	}
 
 	private initWebRTC(dcSuccess : () => void) : void {
+		this._dcSuccess = dcSuccess;
+		this._transport = webRTCTransport;
 		if (Util.defined(this._wrtc)) {
 			this._wrtc.close();
 		}
@@ -206,10 +305,17 @@ class Connection {
 			}		
 		};
 
-		this._wrtc.createOffer((description) => {
-			this._wrtc.setLocalDescription(description);
-			this.send({ T: offerType, JSON: description });			
-		}, () => {});
+		this._voiceSenders.length = 0;
+		this._wrtc.ontrack = (event) => {
+			if (event.streams.length === 0 || !Util.defined(this._trackHandler)) {
+				return;
+			}
+
+			// Server sets the stream id to the speaker's client id
+			this._trackHandler(Number(event.streams[0].id), event.streams[0]);
+		};
+
+		this.sendOffer();
 
 		this._wrtc.ondatachannel = (event) => {
 			console.log("Successfully created data channel");
@@ -220,6 +326,33 @@ class Connection {
 		};
 	}
 
+	private sendOffer() : void {
+		this._wrtc.createOffer().then((description) => {
+			return this._wrtc.setLocalDescription(description);
+		}).then(() => {
+			this.send({ T: offerType, JSON: this._wrtc.localDescription.toJSON() });
+		}).catch((e) => LogUtil.e("Failed to create offer: " + e));
+	}
+
+	private answerOffer(msg : any) : void {
+		// The server rolls back its own offer and sends it again if both sides offered at once
+		if (this._wrtc.signalingState !== "stable") {
+			return;
+		}
+
+		const options = {
+			type: "offer" as RTCSdpType,
+			sdp: msg.JSON["SDP"],
+		}
+		this._wrtc.setRemoteDescription(new RTCSessionDescription(options)).then(() => {
+			return this._wrtc.createAnswer();
+		}).then((description) => {
+			return this._wrtc.setLocalDescription(description);
+		}).then(() => {
+			this.send({ T: answerType, JSON: this._wrtc.localDescription.toJSON() });
+		}).catch((e) => LogUtil.e("Failed to answer offer: " + e));
+	}
+
 	private setRemoteDescription(msg : any) : void {
 		const options = {
 			type: "answer" as RTCSdpType,
@@ -264,7 +397,13 @@ class Connection {
 			return;
 		}
 
-		const msg : any = decode(bytes);
+		let msg : any;
+		try {
+			msg = ObjectDecoder.isObjectState(bytes) ? new ObjectDecoder(bytes).decode() : decode(bytes);
+		} catch (e) {
+			console.error("Failed to decode payload: " + e);
+			return;
+		}
 
 		if (Util.isDev()) {
 			this._dataTracker.add(bytes.length);
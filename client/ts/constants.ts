@@ -1,6 +1,7 @@
 /* WASM variables */
 declare var frameMillis : number;
 declare var wasmVersion : string;
+declare var protocolVersion : number;
 
 declare var neutralTeamColor : number;
 declare var leftTeamColor : number;
@@ -28,6 +29,9 @@ declare var initType : number;
 declare var joinType : number;
 declare var leftType : number;
 
+declare var webRTCTransport : number;
+declare var websocketTransport : number;
+
 declare var initVoiceType : number;
 declare var joinVoiceType : number;
 declare var leftVoiceType : number;
@@ -39,6 +43,8 @@ declare var objectDataType : number;
 declare var objectUpdateType : number;
 declare var playerInitType : number;
 declare var levelInitType : number;
+declare var combatEventType : number;
+declare var versionType : number;
 
 declare var lobbyGameState : number;
 declare var activeGameState : number;
@@ -64,6 +70,7 @@ declare var pickupSpace : number;
 declare var portalSpace : number;
 declare var goalSpace : number;
 declare var spawnSpace : number;
+declare var tracerSpace : number;
 
 declare var attributesProp : number;
 declare var byteAttributesProp : number;
@@ -86,6 +93,7 @@ declare var stateProp : number;
 declare var scoreProp : number;
 declare var vipProp : number;
 declare var teamsProp : number;
+declare var endProp : number;
 
 declare var deletedAttribute : number;
 declare var attachedAttribute : number;
@@ -133,6 +141,18 @@ declare var starWeapon : number;
 declare var boosterEquip : number;
 declare var chargerEquip : number;
 declare var jetpackEquip : number;
+declare var laserWeapon : number;
+
+declare var killCombatEvent : number;
+declare var vipEscortedCombatEvent : number;
+declare var goalChargedCombatEvent : number;
+declare var roundWonCombatEvent : number;
+
+declare var bulletDamage : number;
+declare var explosiveDamage : number;
+declare var fallDamage : number;
+declare var voidDamage : number;
+declare var meleeDamage : number;
 
 declare var readyPartState : number;
 declare var activePartState : number;
@@ -160,6 +180,7 @@ declare var wasmDelete : any;
 declare var wasmUpdateKeys : any;
 declare var wasmGetData : any;
 declare var wasmSetData : any;
+declare var wasmLoadWeapons : any;
 declare var wasmLoadLevel : any;
 declare var wasmUpdate : any;
 declare var wasmReset : any;
@@ -246,6 +246,11 @@ class Game {
 		// TODO: make this announcement
 		LogUtil.d("Loading level " + msg.L + " with seed " + msg.S);
 
+		// Empty resets to the built in weapons
+		if (!wasmLoadWeapons(msg.W ? msg.W : "")) {
+			LogUtil.d("Failed to load weapon definitions from the server");
+		}
+
 		const level = JSON.parse(wasmLoadLevel(msg.L, msg.S));
 		for (const [stringSpace, objects] of Object.entries(level.Os) as [string, any]) {
 			for (const [stringId, data] of Object.entries(objects) as [string, any]) {
@@ -10,6 +10,7 @@ export namespace Html {
 	export const legendLogin = "legend-login";
 	export const inputName = "input-name";
 	export const inputRoom = "input-room";
+	export const inputPassword = "input-password";
 	export const loginInfo = "login-info";
 	export const formLogin = "form-login";
 	export const buttonLogin = "button-login";
@@ -29,6 +30,7 @@ export namespace Html {
 	export const divMainAnnouncement = "div-main-announcement";
 	export const divSubAnnouncement = "div-sub-announcement";
 	export const divScoreboard = "div-scoreboard";
+	export const divKillFeed = "div-kill-feed";
 
 	export const divPause = "div-pause";
 	export const pauseContinue = "pause-continue";
@@ -0,0 +1,137 @@
+import { connection } from './connection.js'
+import { game } from './game.js'
+import { Html } from './html.js'
+import { InterfaceHandler } from './interface_handler.js'
+import { SpecialName, SpecialNames } from './special_name.js'
+import { ui, InputMode } from './ui.js'
+import { Util } from './util.js'
+
+export class KillFeedHandler implements InterfaceHandler {
+	private readonly _maxLines = 5;
+	private readonly _lineTTL = 6000;
+
+	private _killFeedElm : HTMLElement;
+
+	constructor() {
+		this._killFeedElm = Html.elm(Html.divKillFeed);
+	}
+
+	setup() : void {
+		connection.addHandler(combatEventType, (msg : { [k: string]: any }) => { this.combatEvents(msg); });
+	}
+
+	reset() : void {
+		this._killFeedElm.innerHTML = "";
+	}
+
+	changeInputMode(mode : InputMode) : void {}
+
+	private combatEvents(msg : { [k: string]: any }) : void {
+		if (!Util.defined(msg.Es)) {
+			return;
+		}
+
+		for (const event of msg.Es) {
+			switch (event.T) {
+			case killCombatEvent:
+				this.kill(event);
+				break;
+			case vipEscortedCombatEvent:
+				this.addLine([SpecialNames.vip(), {text: "reached the"}, SpecialNames.goal()]);
+				break;
+			case goalChargedCombatEvent:
+				this.addLine([SpecialNames.goal(), {text: "charged"}]);
+				break;
+			case roundWonCombatEvent:
+				this.addLine([this.teamName(event.Team), {text: "won the round"}]);
+				break;
+			}
+		}
+	}
+
+	private kill(event : { [k: string]: any }) : void {
+		const victim = this.playerName(event.Victim);
+		const weapon = { text: "[" + this.weaponName(event) + "]" };
+
+		// Falling, the void and your own rocket don't have anyone else to credit
+		if (event.Killer.S !== playerSpace || event.Killer.Id === event.Victim.Id) {
+			this.addLine([weapon, victim]);
+			return;
+		}
+
+		let killer = this.playerName(event.Killer);
+		if (Util.defined(event.Assists) && event.Assists.length > 0) {
+			killer.text += " + " + event.Assists.length;
+		}
+		this.addLine([killer, weapon, victim]);
+	}
+
+	private addLine(names : Array<SpecialName>) : void {
+		let line = Html.div();
+		names.forEach((name, i) => {
+			let span = Html.span();
+			span.textContent = (i > 0 ? " " : "") + name.text;
+			if (name.color) {
+				Html.bold(span);
+				span.style.color = name.color;
+			}
+			line.append(span);
+		});
+
+		this._killFeedElm.append(line);
+		while (this._killFeedElm.childElementCount > this._maxLines) {
+			this._killFeedElm.firstElementChild.remove();
+		}
+		setTimeout(() => {
+			line.remove();
+		}, this._lineTTL);
+	}
+
+	private playerName(sid : { [k: string]: any }) : SpecialName {
+		const name = ui.hasClient(sid.Id) ? ui.getClientName(sid.Id) : "unknown";
+		const player = game.sceneMap().get(playerSpace, sid.Id);
+		if (!Util.defined(player)) {
+			return { text: name, color: Util.colorString(neutralTeamColor) };
+		}
+		return { text: name, color: this.teamName(player.byteAttribute(teamByteAttribute)).color };
+	}
+
+	private teamName(team : number) : SpecialName {
+		switch (team) {
+		case leftTeam:
+			return { text: "left team", color: Util.colorString(leftTeamColor) };
+		case rightTeam:
+			return { text: "right team", color: Util.colorString(rightTeamColor) };
+		default:
+			return { text: "neutral team", color: Util.colorString(neutralTeamColor) };
+		}
+	}
+
+	private weaponName(event : { [k: string]: any }) : string {
+		switch (event.Damage) {
+		case fallDamage:
+			return "fall";
+		case voidDamage:
+			return "void";
+		case meleeDamage:
+			return "melee";
+		}
+
+		switch (event.Weapon) {
+		case uziWeapon:
+			return "uzi";
+		case bazookaWeapon:
+			return "bazooka";
+		case sniperWeapon:
+			return "sniper";
+		case starWeapon:
+			return "star";
+		case laserWeapon:
+			return "laser";
+		case grapplingHookWeapon:
+			return "hook";
+		default:
+			return event.Damage === explosiveDamage ? "explosion" : "kill";
+		}
+	}
+}
@@ -12,6 +12,7 @@ export class LoginHandler implements InterfaceHandler {
 	private _legendElm : HTMLElement;
 	private _loginInfoElm : HTMLElement;
 	private _roomInputElm : HTMLInputElement;
+	private _passwordInputElm : HTMLInputElement;
 	private _nameInputElm : HTMLInputElement;
 	private _buttonElm : HTMLInputElement;
 	private _gameElm : HTMLElement;
@@ -27,6 +28,7 @@ export class LoginHandler implements InterfaceHandler {
 		this._legendElm = Html.elm(Html.legendLogin);
 		this._loginInfoElm = Html.elm(Html.loginInfo);
 		this._roomInputElm = Html.inputElm(Html.inputRoom);
+		this._passwordInputElm = Html.inputElm(Html.inputPassword);
 		this._nameInputElm = Html.inputElm(Html.inputName);
 		this._gameElm = Html.elm(Html.divGame);
 		this._buttonElm = Html.inputElm(Html.buttonLogin);
@@ -48,6 +50,11 @@ export class LoginHandler implements InterfaceHandler {
 			}
 
 			let vars = new Map([["room", room], ["name", name]]);
+			// Sets the password when creating the room, and is checked when joining
+			const password = Html.trimmedValue(this._passwordInputElm);
+			if (password.length > 0) {
+				vars.set("password", password);
+			}
 			if (connection.hasId()) {
 				vars.set("id", "" + connection.id());
 			}
@@ -0,0 +1,135 @@
+// Decodes object data sent with BinaryObjectEncoder into the same shape as msgpack, see objectencoder.go
+export class ObjectDecoder {
+	private static readonly _vec2Precision = 1000;
+
+	private _bytes : Uint8Array;
+	private _view : DataView;
+	private _index : number;
+
+	constructor(bytes : Uint8Array) {
+		this._bytes = bytes;
+		this._view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+		this._index = 0;
+	}
+
+	static isObjectState(bytes : Uint8Array) : boolean {
+		// Msgpack messages start with a map header
+		return bytes.length > 0 && (bytes[0] === objectDataType || bytes[0] === objectUpdateType);
+	}
+
+	decode() : any {
+		const msg = {
+			T: this.byte(),
+			S: this.uvarint(),
+			Os: {},
+		};
+
+		const numSpaces = this.uvarint();
+		for (let i = 0; i < numSpaces; ++i) {
+			const space = this.byte();
+			const objects = {};
+
+			const numObjects = this.uvarint();
+			for (let j = 0; j < numObjects; ++j) {
+				const id = this.uvarint();
+				const props = {};
+
+				for (const prop of this.bitset()) {
+					props[prop] = this.prop(prop);
+				}
+				objects[id] = props;
+			}
+			msg.Os[space] = objects;
+		}
+		return msg;
+	}
+
+	private prop(prop : number) : any {
+		switch (prop) {
+		case attributesProp:
+		case keysProp:
+			const keys = this.bitset();
+			const values = this.uvarint();
+			const flags = {};
+			keys.forEach((key) => { flags[key] = Math.floor(values / Math.pow(2, key)) % 2 === 1; });
+			return flags;
+		case byteAttributesProp:
+			const bytes = {};
+			this.bitset().forEach((key) => { bytes[key] = this.byte(); });
+			return bytes;
+		case intAttributesProp:
+			const ints = {};
+			this.bitset().forEach((key) => { ints[key] = this.varint(); });
+			return ints;
+		case floatAttributesProp:
+			const floats = {};
+			this.bitset().forEach((key) => { floats[key] = this.float32(); });
+			return floats;
+		case dimProp:
+		case posProp:
+		case velProp:
+		case accProp:
+		case jerkProp:
+		case dirProp:
+		case endProp:
+			const x = this.varint() / ObjectDecoder._vec2Precision;
+			const y = this.varint() / ObjectDecoder._vec2Precision;
+			return { X: x, Y: y };
+		case nameProp:
+			const length = this.uvarint();
+			const name = new TextDecoder().decode(this._bytes.subarray(this._index, this._index + length));
+			this._index += length;
+			return name;
+		case ownerProp:
+		case targetProp:
+			const space = this.byte();
+			return { S: space, Id: this.uvarint() };
+		}
+
+		throw new Error("Cannot decode prop " + prop);
+	}
+
+	private bitset() : Array<number> {
+		let mask = this.uvarint();
+		const set = new Array<number>();
+		for (let i = 0; mask > 0; ++i) {
+			if (mask % 2 === 1) {
+				set.push(i);
+			}
+			mask = Math.floor(mask / 2);
+		}
+		return set;
+	}
+
+	private byte() : number {
+		if (this._index >= this._bytes.length) {
+			throw new Error("Unexpected end of object data");
+		}
+		return this._bytes[this._index++];
+	}
+
+	private float32() : number {
+		const value = this._view.getFloat32(this._index, /*littleEndian=*/true);
+		this._index += 4;
+		return value;
+	}
+
+	// Bit operations are limited to 32 bits, so use arithmetic instead
+	private uvarint() : number {
+		let value = 0;
+		let scale = 1;
+		while (true) {
+			const b = this.byte();
+			value += (b & 0x7f) * scale;
+			if (b < 0x80) {
+				return value;
+			}
+			scale *= 128;
+		}
+	}
+
+	private varint() : number {
+		const zigzag = this.uvarint();
+		return zigzag % 2 === 0 ? zigzag / 2 : -(zigzag + 1) / 2;
+	}
+}
@@ -0,0 +1,47 @@
+import * as THREE from 'three';
+
+import { Sound } from './audio.js'
+import { RenderObject } from './render_object.js'
+import { renderer } from './renderer.js'
+
+// Hitscan shot drawn from the shooter to whatever it hit, fades out before the server deletes it
+export class RenderTracer extends RenderObject {
+	private _material : THREE.MeshBasicMaterial;
+
+	constructor(space : number, id : number) {
+		super(space, id);
+
+		// Position is the start of the shot, but the mesh is centered between the endpoints
+		this.disableAutoUpdatePos();
+	}
+
+	override ready() : boolean {
+		return super.ready() && this.hasColor() && this.msg().has(endProp);
+	}
+
+	override initialize() : void {
+		super.initialize();
+
+		const start = this.pos();
+		const end = new THREE.Vector2(this.msg().get(endProp).X, this.msg().get(endProp).Y);
+		let ray = end.clone().sub(start);
+
+		this._material = new THREE.MeshBasicMaterial({ color: this.color(), transparent: true, opacity: 1 });
+		const mesh = new THREE.Mesh(new THREE.BoxGeometry(Math.max(ray.length(), 0.01), 0.05, 0.05), this._material);
+		mesh.position.set((start.x + end.x) / 2, (start.y + end.y) / 2, 0);
+		mesh.rotation.z = ray.angle();
+		this.setMesh(mesh);
+
+		renderer.playSound(Sound.LASER, {pos: start});
+	}
+
+	override update() : void {
+		super.update();
+
+		if (!this.hasMesh()) {
+			return;
+		}
+
+		this._material.opacity = Math.max(this._material.opacity - 8 * this.timestep(), 0);
+	}
+}
@@ -22,6 +22,7 @@ import { RenderRocket } from './render_rocket.js'
 import { RenderRoofBlock } from './render_roof_block.js'
 import { RenderSpawn } from './render_spawn.js'
 import { RenderStar } from './render_star.js'
+import { RenderTracer } from './render_tracer.js'
 import { RenderWall } from './render_wall.js'
 import { RenderWeapon } from './render_weapon.js'
 import { SceneComponent, SceneComponentType } from './scene_component.js'
@@ -170,6 +171,8 @@ export class SceneMap {
 			renderObj = new RenderPortal(space, id);
 		} else if (space === spawnSpace) {
 			renderObj = new RenderSpawn(space, id);
+		} else if (space === tracerSpace) {
+			renderObj = new RenderTracer(space, id);
 		} else {
 			console.error("Unable to construct object for type " + space);
 			return null;
@@ -10,6 +10,7 @@ import { Icon } from './icon.js'
 import { InputHandler } from './input_handler.js'
 import { InterfaceHandler } from './interface_handler.js'
 import { KeyBindingsHandler } from './key_bindings_handler.js'
+import { KillFeedHandler } from './kill_feed_handler.js'
 import { LoginHandler } from './login_handler.js'
 import { options } from './options.js'
 import { OptionsHandler } from './options_handler.js'
@@ -54,6 +55,7 @@ class UI {
 	private _clientHandler : ClientHandler;
 	private _inputHandler : InputHandler;
 	private _keyBindingsHandler : KeyBindingsHandler;
+	private _killFeedHandler : KillFeedHandler;
 	private _loginHandler : LoginHandler;
 	private _optionsHandler : OptionsHandler;
 	private _pauseHandler : PauseHandler;
@@ -80,6 +82,9 @@ class UI {
 		this._keyBindingsHandler = new KeyBindingsHandler();
 		this._handlers.push(this._keyBindingsHandler);
 
+		this._killFeedHandler = new KillFeedHandler();
+		this._handlers.push(this._killFeedHandler);
+
 		this._loginHandler = new LoginHandler();
 		this._handlers.push(this._loginHandler);
 
@@ -121,10 +126,10 @@ class UI {
 
 	announce(announcement : Announcement) { this._announcementHandler.announce(announcement); }
 	tooltip(tooltip : Tooltip) { this._tooltipHandler.tooltip(tooltip); }
-	disconnected() : void {
+	disconnected(reason? : string) : void {
 		game.setInputMode(GameInputMode.PAUSE);
 		this.changeInputMode(InputMode.LOGIN);
-		this.print("Error: disconnected from server.")
+		this.print("Error: disconnected from server." + (Util.defined(reason) && reason.length > 0 ? " " + reason : ""));
 	}
 	print(message : string) : void {
 		this._chatHandler.print(message);
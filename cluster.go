@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Points per node on the hash ring, more points spread rooms more evenly
+	clusterReplicas int = 64
+
+	directoryTimeout time.Duration = 2 * time.Second
+)
+
+// Every process is configured with the same node list, so they all agree on where a room lives without talking
+// to each other, e.g. CLUSTER_NODES=localhost:8080,localhost:8081 CLUSTER_SELF=localhost:8081
+var cluster = NewCluster("", nil)
+
+type ringPoint struct {
+	hash uint32
+	node string
+}
+
+// Places rooms on nodes with consistent hashing on the room name
+type Cluster struct {
+	self string
+	nodes []string
+	ring []ringPoint
+
+	client *http.Client
+}
+
+// With no nodes every room is local
+func NewCluster(self string, nodes []string) *Cluster {
+	c := &Cluster {
+		self: self,
+		nodes: make([]string, 0, len(nodes)),
+		ring: make([]ringPoint, 0, len(nodes) * clusterReplicas),
+		client: &http.Client {
+			Timeout: directoryTimeout,
+		},
+	}
+
+	for _, node := range(nodes) {
+		node = strings.TrimSpace(node)
+		if node == "" {
+			continue
+		}
+		c.nodes = append(c.nodes, node)
+		for i := 0; i < clusterReplicas; i++ {
+			c.ring = append(c.ring, ringPoint {
+				hash: hashString(fmt.Sprintf("%s#%d", node, i)),
+				node: node,
+			})
+		}
+	}
+	sort.Slice(c.ring, func(i, j int) bool {
+		return c.ring[i].hash < c.ring[j].hash
+	})
+	return c
+}
+
+// Parses a comma separated node list, self has to be one of them
+func ParseCluster(self string, nodeList string) (*Cluster, error) {
+	if nodeList == "" {
+		return NewCluster(self, nil), nil
+	}
+
+	nodes := strings.Split(nodeList, ",")
+	for _, node := range(nodes) {
+		if strings.TrimSpace(node) == self {
+			return NewCluster(self, nodes), nil
+		}
+	}
+	return nil, fmt.Errorf("CLUSTER_SELF %s is not in CLUSTER_NODES %s", self, nodeList)
+}
+
+func (c Cluster) Enabled() bool {
+	return len(c.nodes) > 0
+}
+
+func (c Cluster) Self() string {
+	return c.self
+}
+
+func (c Cluster) Nodes() []string {
+	return c.nodes
+}
+
+// Node that hosts the room, empty if clustering is disabled
+func (c Cluster) Owner(room string) string {
+	if len(c.ring) == 0 {
+		return ""
+	}
+
+	hash := hashString(room)
+	i := sort.Search(len(c.ring), func(i int) bool {
+		return c.ring[i].hash >= hash
+	})
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.ring[i].node
+}
+
+func (c Cluster) IsLocal(room string) bool {
+	if !c.Enabled() {
+		return true
+	}
+	return c.Owner(room) == c.self
+}
+
+// Lists rooms on every node, nodes that don't respond are returned separately
+func (c Cluster) Directory() ([]RoomInfo, []string) {
+	if !c.Enabled() {
+		return GetRoomInfos(), []string{}
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	infos := make([]RoomInfo, 0)
+	unreachable := make([]string, 0)
+
+	for _, node := range(c.nodes) {
+		if node == c.self {
+			mutex.Lock()
+			infos = append(infos, GetRoomInfos()...)
+			mutex.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+
+			nodeInfos, err := c.fetchRoomInfos(node)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				logger.With("node", node).Warn("failed to list rooms: %v", err)
+				unreachable = append(unreachable, node)
+				return
+			}
+			infos = append(infos, nodeInfos...)
+		}(node)
+	}
+	wg.Wait()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	sort.Strings(unreachable)
+	return infos, unreachable
+}
+
+func (c Cluster) fetchRoomInfos(node string) ([]RoomInfo, error) {
+	resp, err := c.client.Get("http://" + node + roomsEndpoint + "local")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+
+	infos := make([]RoomInfo, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// FNV clumps similar names like room1 and room2 together on the ring
+func hashString(s string) uint32 {
+	sum := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testNodes = []string {"localhost:8080", "localhost:8081", "localhost:8082"}
+
+func testRoomNames(n int) []string {
+	names := make([]string, n)
+	for i := range(names) {
+		names[i] = fmt.Sprintf("room%d", i)
+	}
+	return names
+}
+
+func TestClusterDisabled(t *testing.T) {
+	c := NewCluster("", nil)
+	if c.Enabled() || !c.IsLocal("abcd") || c.Owner("abcd") != "" {
+		t.Errorf("expected every room to be local without nodes")
+	}
+}
+
+func TestClusterNodesAgree(t *testing.T) {
+	clusters := make([]*Cluster, len(testNodes))
+	for i, node := range(testNodes) {
+		clusters[i] = NewCluster(node, testNodes)
+	}
+
+	for _, room := range(testRoomNames(200)) {
+		owner := clusters[0].Owner(room)
+		local := 0
+		for _, c := range(clusters) {
+			if c.Owner(room) != owner {
+				t.Fatalf("nodes disagree on the owner of %s", room)
+			}
+			if c.IsLocal(room) {
+				local += 1
+			}
+		}
+		if local != 1 {
+			t.Errorf("expected %s to be local on one node, got %d", room, local)
+		}
+	}
+}
+
+func TestClusterSpread(t *testing.T) {
+	c := NewCluster(testNodes[0], testNodes)
+	counts := make(map[string]int)
+	rooms := testRoomNames(3000)
+	for _, room := range(rooms) {
+		counts[c.Owner(room)] += 1
+	}
+
+	for _, node := range(testNodes) {
+		if counts[node] < len(rooms) / 5 {
+			t.Errorf("expected a fair share of rooms on %s, got %d of %d", node, counts[node], len(rooms))
+		}
+	}
+}
+
+func TestClusterRemoveNode(t *testing.T) {
+	before := NewCluster(testNodes[0], testNodes)
+	after := NewCluster(testNodes[0], testNodes[:2])
+
+	for _, room := range(testRoomNames(500)) {
+		owner := before.Owner(room)
+		if owner != testNodes[2] && after.Owner(room) != owner {
+			t.Errorf("expected %s to stay on %s", room, owner)
+		}
+	}
+}
+
+func TestParseCluster(t *testing.T) {
+	c, err := ParseCluster("localhost:8081", "localhost:8080, localhost:8081")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Nodes()) != 2 {
+		t.Errorf("expected 2 nodes, got %v", c.Nodes())
+	}
+
+	if _, err := ParseCluster("localhost:9000", "localhost:8080,localhost:8081"); err == nil {
+		t.Errorf("expected error when self is not a node")
+	}
+}
+
+func TestClusterDirectory(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != roomsEndpoint + "local" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]RoomInfo {
+			{Name: "peer1", Host: "peer", Players: 2},
+			{Name: "peer0", Host: "peer", Players: 1},
+		})
+	}))
+	defer peer.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	downNode := strings.TrimPrefix(down.URL, "http://")
+	down.Close()
+
+	peerNode := strings.TrimPrefix(peer.URL, "http://")
+	c := NewCluster("localhost:0", []string {"localhost:0", peerNode, downNode})
+
+	infos, unreachable := c.Directory()
+	if len(infos) != 2 || infos[0].Name != "peer0" || infos[1].Players != 2 {
+		t.Errorf("expected sorted rooms from the peer, got %+v", infos)
+	}
+	if len(unreachable) != 1 || unreachable[0] != downNode {
+		t.Errorf("expected %s to be unreachable, got %v", downNode, unreachable)
+	}
+}
+
+func TestNewRoomNameIsLocal(t *testing.T) {
+	prev := cluster
+	cluster = NewCluster(testNodes[1], testNodes)
+	defer func() { cluster = prev }()
+
+	for i := 0; i < 20; i++ {
+		if name := newRoomName(); !cluster.IsLocal(name) {
+			t.Errorf("expected matchmaking room %s to be local", name)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/vmihailenco/msgpack/v5"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	keyInterval time.Duration = time.Second / 60
+	pingInterval time.Duration = 500 * time.Millisecond
+	// Pings without a reply after this long are counted as lost
+	pingTimeout time.Duration = 5 * time.Second
+
+	// How often the random key presses change
+	keyChangeInterval time.Duration = 300 * time.Millisecond
+)
+
+// Simulated browser client, mirrors the handshake in client/ts/connection.ts
+type LoadClient struct {
+	name string
+	stats *Stats
+	random *rand.Rand
+
+	// Skip WebRTC to test the websocket fallback
+	webRTC bool
+	encoding string
+	params string
+
+	ws *websocket.Conn
+	wrtc *webrtc.PeerConnection
+	dc *webrtc.DataChannel
+	wsMutex sync.Mutex
+
+	mutex sync.Mutex
+	id uint16
+	started bool
+	remoteSet bool
+	candidates []webrtc.ICECandidateInit
+	pingTimes map[SeqNumType]time.Time
+
+	done chan struct{}
+}
+
+func NewLoadClient(name string, stats *Stats, seed int64, webRTC bool, encoding string, params string) *LoadClient {
+	return &LoadClient {
+		name: name,
+		stats: stats,
+		random: rand.New(rand.NewSource(seed)),
+
+		webRTC: webRTC,
+		encoding: encoding,
+		params: params,
+
+		candidates: make([]webrtc.ICECandidateInit, 0),
+		pingTimes: make(map[SeqNumType]time.Time),
+		done: make(chan struct{}),
+	}
+}
+
+// The origin has to be one the server allows
+func (c *LoadClient) Run(addr string, origin string, room string, duration time.Duration) error {
+	var err error
+	c.ws, err = c.dial(addr, origin, room)
+	if err != nil {
+		return err
+	}
+	defer c.ws.Close()
+
+	c.stats.AddConnected(1)
+	defer c.stats.AddConnected(-1)
+
+	defer func() {
+		if c.wrtc != nil {
+			c.wrtc.Close()
+		}
+	}()
+
+	go func() {
+		time.Sleep(duration)
+		c.Close()
+	}()
+
+	for {
+		_, b, err := c.ws.ReadMessage()
+		if err != nil {
+			select {
+			case <-c.done:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		err = c.handleMessage(false, b)
+		if err != nil {
+			log.Printf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func (c *LoadClient) Close() {
+	select {
+	case <-c.done:
+		return
+	default:
+		close(c.done)
+	}
+	c.ws.Close()
+}
+
+// Uses the ICE config from initType, which may include TURN credentials
+func (c *LoadClient) initWebRTC(ice *ICEConfig) error {
+	config := webrtc.Configuration{}
+	if ice != nil {
+		for _, server := range(ice.Servers) {
+			config.ICEServers = append(config.ICEServers, webrtc.ICEServer {
+				URLs: server.URLs,
+				Username: server.Username,
+				Credential: server.Credential,
+			})
+		}
+		if ice.Policy == "relay" {
+			config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+		}
+	}
+
+	var err error
+	c.wrtc, err = webrtc.NewPeerConnection(config)
+	if err != nil {
+		return err
+	}
+
+	ordered := false
+	maxRetransmits := uint16(0)
+	_, err = c.wrtc.CreateDataChannel("data", &webrtc.DataChannelInit {
+		Ordered: &ordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.wrtc.OnICECandidate(func(ice *webrtc.ICECandidate) {
+		if ice == nil {
+			return
+		}
+
+		// Same layout the browser sends, the server expects a small int for the line index
+		candidate := ice.ToJSON()
+		json := map[string]interface{} {
+			"candidate": candidate.Candidate,
+			"sdpMid": "",
+			"sdpMLineIndex": int8(0),
+		}
+		if candidate.SDPMid != nil {
+			json["sdpMid"] = *candidate.SDPMid
+		}
+		if candidate.SDPMLineIndex != nil {
+			json["sdpMLineIndex"] = int8(*candidate.SDPMLineIndex)
+		}
+		c.send(&JSONMsg {
+			T: candidateType,
+			JSON: json,
+		})
+	})
+
+	// The server creates the channel used for game data
+	c.wrtc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnOpen(func() {
+			c.mutex.Lock()
+			c.dc = dc
+			c.mutex.Unlock()
+
+			c.stats.AddDataChannel(1)
+			c.start()
+		})
+		dc.OnClose(func() {
+			c.stats.AddDataChannel(-1)
+		})
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			if err := c.handleMessage(true, msg.Data); err != nil {
+				log.Printf("%s: %v", c.name, err)
+			}
+		})
+	})
+
+	offer, err := c.wrtc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	err = c.wrtc.SetLocalDescription(offer)
+	if err != nil {
+		return err
+	}
+
+	return c.send(&JSONMsg {
+		T: offerType,
+		JSON: map[string]interface{} {
+			"type": "offer",
+			"sdp": offer.SDP,
+		},
+	})
+}
+
+// Follows one redirect to the node that hosts the room
+func (c *LoadClient) dial(addr string, origin string, room string) (*websocket.Conn, error) {
+	endpoint := url.URL {
+		Scheme: "ws",
+		Host: addr,
+		Path: fmt.Sprintf("/bd3/room=%s&name=%s&version=%d&encoding=%s", room, c.name, protocolVersion, c.encoding),
+	}
+	if c.params != "" {
+		endpoint.Path += "&" + c.params
+	}
+
+	header := http.Header{}
+	header.Set("Origin", origin)
+	ws, _, err := websocket.DefaultDialer.Dial(endpoint.String(), header)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server always starts with its version
+	_, b, err := ws.ReadMessage()
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	msg := VersionMsg{}
+	if err := msgpack.Unmarshal(b, &msg); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if msg.Redirect == "" {
+		if err := c.handleMessage(false, b); err != nil {
+			ws.Close()
+			return nil, err
+		}
+		return ws, nil
+	}
+
+	ws.Close()
+	if addr != msg.Redirect {
+		c.stats.AddRedirect()
+		return c.dial(msg.Redirect, origin, room)
+	}
+	return nil, fmt.Errorf("redirected to the same node %s", addr)
+}
+
+func (c *LoadClient) handleMessage(udp bool, b []byte) error {
+	header := HeaderMsg{}
+	if len(b) > 0 && (MessageType(b[0]) == objectDataType || MessageType(b[0]) == objectUpdateType) {
+		// Binary object data starts with the type instead of a msgpack map
+		header.T = MessageType(b[0])
+	} else if err := msgpack.Unmarshal(b, &header); err != nil {
+		return err
+	}
+	c.stats.AddMessage(udp, header.T, len(b))
+
+	switch header.T {
+	case versionType:
+		msg := VersionMsg{}
+		if err := msgpack.Unmarshal(b, &msg); err != nil {
+			return err
+		}
+		if !msg.Accepted {
+			return fmt.Errorf("server on protocol %d rejected version %d: %s", msg.Protocol, protocolVersion, msg.Reason)
+		}
+	case initType:
+		msg := ClientMsg{}
+		if err := msgpack.Unmarshal(b, &msg); err != nil {
+			return err
+		}
+		c.mutex.Lock()
+		c.id = msg.Client.Id
+		c.mutex.Unlock()
+
+		if c.webRTC {
+			return c.initWebRTC(msg.ICE)
+		}
+	case joinType:
+		msg := ClientMsg{}
+		if err := msgpack.Unmarshal(b, &msg); err != nil {
+			return err
+		}
+		c.mutex.Lock()
+		fallback := msg.Client.Id == c.id && msg.Client.Transport == websocketTransport
+		c.mutex.Unlock()
+		if fallback {
+			c.stats.AddFallback()
+			c.start()
+		}
+	case pingType:
+		msg := PingMsg{}
+		if err := msgpack.Unmarshal(b, &msg); err != nil {
+			return err
+		}
+		c.mutex.Lock()
+		sent, ok := c.pingTimes[msg.S]
+		delete(c.pingTimes, msg.S)
+		c.mutex.Unlock()
+		if ok {
+			c.stats.AddPing(time.Now().Sub(sent))
+		}
+	case answerType:
+		return c.setRemoteDescription(b)
+	case candidateType:
+		return c.addIceCandidate(b)
+	}
+	return nil
+}
+
+func (c *LoadClient) setRemoteDescription(b []byte) error {
+	msg := struct {
+		JSON struct {
+			SDP string
+		}
+	}{}
+	err := msgpack.Unmarshal(b, &msg)
+	if err != nil {
+		return err
+	}
+
+	err = c.wrtc.SetRemoteDescription(webrtc.SessionDescription {
+		Type: webrtc.SDPTypeAnswer,
+		SDP: msg.JSON.SDP,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.remoteSet = true
+	candidates := c.candidates
+	c.candidates = nil
+	c.mutex.Unlock()
+
+	for _, candidate := range(candidates) {
+		if err := c.wrtc.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *LoadClient) addIceCandidate(b []byte) error {
+	msg := struct {
+		JSON webrtc.ICECandidateInit
+	}{}
+	err := msgpack.Unmarshal(b, &msg)
+	if err != nil {
+		return err
+	}
+
+	// Candidates can arrive before the answer
+	c.mutex.Lock()
+	if !c.remoteSet {
+		c.candidates = append(c.candidates, msg.JSON)
+		c.mutex.Unlock()
+		return nil
+	}
+	c.mutex.Unlock()
+
+	return c.wrtc.AddICECandidate(msg.JSON)
+}
+
+// Starts sending keys and pings once game data can flow
+func (c *LoadClient) start() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.started {
+		return
+	}
+	c.started = true
+	go c.sendKeys()
+	go c.sendPings()
+}
+
+// Holds random keys for a while like a real player would
+func (c *LoadClient) sendKeys() {
+	ticker := time.NewTicker(keyInterval)
+	defer ticker.Stop()
+
+	seqNum := SeqNumType(0)
+	keys := make([]KeyType, 0)
+	mouse := Vec2{}
+	lastChange := time.Time{}
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(lastChange) > keyChangeInterval {
+				keys = c.randomKeys()
+				mouse = Vec2 {
+					X: c.random.Float64() * 40 - 20,
+					Y: c.random.Float64() * 20 - 10,
+				}
+				lastChange = now
+			}
+
+			seqNum += 1
+			err := c.sendData(&OutgoingKeyMsg {
+				T: keyType,
+				Key: KeyMsg {
+					S: seqNum,
+					K: keys,
+					M: mouse,
+					D: Vec2{X: 1, Y: 0},
+				},
+			})
+			if err != nil {
+				return
+			}
+			c.stats.AddKeySent()
+		}
+	}
+}
+
+func (c *LoadClient) randomKeys() []KeyType {
+	keys := make([]KeyType, 0)
+	for key := upKey; key <= altMouseClick; key += 1 {
+		if c.random.Intn(4) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (c *LoadClient) sendPings() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	seqNum := SeqNumType(0)
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mutex.Lock()
+			for s, sent := range(c.pingTimes) {
+				if now.Sub(sent) > pingTimeout {
+					delete(c.pingTimes, s)
+					c.stats.AddLostPing()
+				}
+			}
+			c.pingTimes[seqNum] = now
+			c.mutex.Unlock()
+
+			err := c.sendData(&OutgoingPingMsg {
+				T: pingType,
+				Ping: PingMsg {
+					S: seqNum,
+				},
+			})
+			if err != nil {
+				return
+			}
+			seqNum += 1
+		}
+	}
+}
+
+func (c *LoadClient) send(msg interface{}) error {
+	b, err := msgpack.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.wsMutex.Lock()
+	defer c.wsMutex.Unlock()
+	return c.ws.WriteMessage(websocket.BinaryMessage, b)
+}
+
+func (c *LoadClient) sendData(msg interface{}) error {
+	b, err := msgpack.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	dc := c.dc
+	c.mutex.Unlock()
+
+	if dc == nil {
+		c.wsMutex.Lock()
+		defer c.wsMutex.Unlock()
+		return c.ws.WriteMessage(websocket.BinaryMessage, b)
+	}
+	return dc.Send(b)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Opens a bunch of simulated clients against a running server and reports ping, snapshot rate and message sizes.
+//
+//   go run ./cmd/loadtest -addr localhost:8080 -clients 40 -per-room 8 -duration 2m
+func main() {
+	addr := flag.String("addr", "localhost:8080", "server host and port")
+	origin := flag.String("origin", "http://localhost:8080", "origin header, must be allowed by the server")
+	prefix := flag.String("room", "load", "room name prefix, 1-6 chars")
+	clients := flag.Int("clients", 10, "number of clients")
+	perRoom := flag.Int("per-room", 10, "clients per room")
+	duration := flag.Duration("duration", 1 * time.Minute, "how long each client stays connected")
+	ramp := flag.Duration("ramp", 100 * time.Millisecond, "delay between connecting clients")
+	interval := flag.Duration("interval", 5 * time.Second, "time between reports")
+	webRTC := flag.Bool("webrtc", true, "set to false to test the websocket fallback")
+	encoding := flag.String("encoding", "binary", "object data encoding, binary or msgpack")
+	params := flag.String("params", "", "extra connection params, e.g. password=abc&max=4")
+	flag.Parse()
+
+	if len(*prefix) == 0 || len(*prefix) > 6 {
+		log.Fatalf("room prefix %s should be 1-6 chars long", *prefix)
+	}
+	if *perRoom <= 0 {
+		log.Fatalf("per-room should be positive")
+	}
+
+	stats := NewStats()
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		report(stats, *interval, done)
+		close(reported)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < *clients; i += 1 {
+		// Room names need at least 4 chars
+		room := fmt.Sprintf("%s%04d", *prefix, i / *perRoom)
+		name := fmt.Sprintf("load%d", i)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			client := NewLoadClient(name, stats, time.Now().UnixNano() + int64(i), *webRTC, *encoding, *params)
+			if err := client.Run(*addr, *origin, room, *duration); err != nil {
+				stats.AddFailed()
+				log.Printf("%s: %v", name, err)
+			}
+		}(i)
+		time.Sleep(*ramp)
+	}
+
+	wg.Wait()
+	close(done)
+	<-reported
+}
+
+func report(stats *Stats, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-done:
+			log.Printf("final report\n%s", stats.Report(time.Now().Sub(last)))
+			return
+		case now := <-ticker.C:
+			log.Printf("report\n%s", stats.Report(now.Sub(last)))
+			last = now
+		}
+	}
+}
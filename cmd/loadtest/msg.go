@@ -0,0 +1,148 @@
+package main
+
+// Copied from the server since it lives in its own main package, keep in sync with msg.go and keys.go
+const protocolVersion int = 4
+
+type MessageType uint8
+type SeqNumType uint32
+const (
+	unknownType MessageType = 0
+
+	pingType MessageType = 1
+	candidateType MessageType = 2
+	offerType MessageType = 3
+	answerType MessageType = 4
+	voiceCandidateType MessageType = 5
+
+	voiceOfferType MessageType = 6
+	voiceAnswerType MessageType = 7
+	initType MessageType = 8
+	joinType MessageType = 9
+	leftType MessageType = 10
+
+	initVoiceType MessageType = 11
+	joinVoiceType MessageType = 12
+	leftVoiceType MessageType = 13
+	chatType MessageType = 14
+	keyType MessageType = 15
+
+	gameStateType MessageType = 16
+	objectDataType MessageType = 17
+	objectUpdateType MessageType = 18
+	playerInitType MessageType = 19
+	levelInitType MessageType = 20
+	combatEventType MessageType = 21
+	stateChangeType MessageType = 22
+
+	versionType MessageType = 23
+)
+
+var messageNames = map[MessageType]string {
+	pingType: "ping",
+	candidateType: "candidate",
+	answerType: "answer",
+	initType: "init",
+	joinType: "join",
+	leftType: "left",
+	chatType: "chat",
+	gameStateType: "gameState",
+	objectDataType: "objectData",
+	objectUpdateType: "objectUpdate",
+	playerInitType: "playerInit",
+	levelInitType: "levelInit",
+	combatEventType: "combatEvent",
+	stateChangeType: "stateChange",
+	versionType: "version",
+}
+
+type KeyType uint16
+const (
+	unknownKey KeyType = iota
+
+	upKey
+	downKey
+	leftKey
+	rightKey
+
+	jumpKey
+	interactKey
+
+	mouseClick
+	altMouseClick
+)
+
+type Vec2 struct {
+	X float64
+	Y float64
+}
+
+// Only the type is decoded for most incoming messages
+type HeaderMsg struct {
+	T MessageType
+}
+
+type PingMsg struct {
+	T MessageType
+	S SeqNumType
+}
+
+type OutgoingPingMsg struct {
+	T MessageType
+	Ping PingMsg
+}
+
+type KeyMsg struct {
+	T MessageType
+	S SeqNumType
+	K []KeyType
+	M Vec2
+	D Vec2
+}
+
+type OutgoingKeyMsg struct {
+	T MessageType
+	Key KeyMsg
+}
+
+type JSONMsg struct {
+	T MessageType
+	JSON interface{}
+}
+
+type TransportType uint8
+const (
+	unknownTransport TransportType = iota
+	webRTCTransport
+	websocketTransport
+)
+
+type VersionMsg struct {
+	T MessageType
+	Accepted bool
+	Protocol int
+	Reason string
+	Redirect string
+}
+
+type ClientData struct {
+	Id uint16
+	Name string
+	Transport TransportType
+}
+
+type ICEServer struct {
+	URLs []string
+	Username string
+	Credential string
+}
+
+type ICEConfig struct {
+	Servers []ICEServer
+	Policy string
+}
+
+type ClientMsg struct {
+	T MessageType
+	Client ClientData
+	ICE *ICEConfig
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type messageStats struct {
+	count int
+	bytes int
+	maxBytes int
+}
+
+// Shared by all clients, reset after every report
+type Stats struct {
+	mutex sync.Mutex
+
+	connected int
+	dataChannels int
+	fallbacks int
+	failed int
+	redirects int
+
+	pings []time.Duration
+	lostPings int
+	keysSent int
+	ws map[MessageType]*messageStats
+	dc map[MessageType]*messageStats
+}
+
+func NewStats() *Stats {
+	return &Stats {
+		pings: make([]time.Duration, 0),
+		ws: make(map[MessageType]*messageStats),
+		dc: make(map[MessageType]*messageStats),
+	}
+}
+
+func (s *Stats) AddConnected(delta int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected += delta
+}
+
+func (s *Stats) AddDataChannel(delta int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dataChannels += delta
+}
+
+func (s *Stats) AddFallback() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fallbacks += 1
+}
+
+func (s *Stats) AddRedirect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.redirects += 1
+}
+
+func (s *Stats) AddFailed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failed += 1
+}
+
+func (s *Stats) AddPing(ping time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pings = append(s.pings, ping)
+}
+
+func (s *Stats) AddLostPing() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lostPings += 1
+}
+
+func (s *Stats) AddKeySent() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keysSent += 1
+}
+
+func (s *Stats) AddMessage(udp bool, msgType MessageType, size int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := s.ws
+	if udp {
+		messages = s.dc
+	}
+	if _, ok := messages[msgType]; !ok {
+		messages[msgType] = &messageStats{}
+	}
+
+	stats := messages[msgType]
+	stats.count += 1
+	stats.bytes += size
+	if size > stats.maxBytes {
+		stats.maxBytes = size
+	}
+}
+
+// Summarizes everything since the last report
+func (s *Stats) Report(elapsed time.Duration) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seconds := elapsed.Seconds()
+	clients := s.dataChannels + s.fallbacks
+	if clients == 0 {
+		clients = 1
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("clients: %d connected, %d data channels, %d websocket fallbacks, %d failed, %d redirected\n", s.connected, s.dataChannels, s.fallbacks, s.failed, s.redirects))
+
+	if len(s.pings) > 0 {
+		sort.Slice(s.pings, func(i, j int) bool {
+			return s.pings[i] < s.pings[j]
+		})
+		sum := time.Duration(0)
+		for _, ping := range(s.pings) {
+			sum += ping
+		}
+		sb.WriteString(fmt.Sprintf("  ping: avg %v, p50 %v, p95 %v, max %v, %d lost\n",
+			(sum / time.Duration(len(s.pings))).Round(time.Microsecond),
+			percentile(s.pings, 0.5).Round(time.Microsecond),
+			percentile(s.pings, 0.95).Round(time.Microsecond),
+			s.pings[len(s.pings) - 1].Round(time.Microsecond),
+			s.lostPings))
+	}
+
+	// Snapshots come over the websocket for clients that fell back
+	snapshots := 0
+	if stats, ok := s.dc[objectDataType]; ok {
+		snapshots += stats.count
+	}
+	if stats, ok := s.ws[objectDataType]; ok {
+		snapshots += stats.count
+	}
+	sb.WriteString(fmt.Sprintf("  snapshots: %.1f/s per client\n", float64(snapshots) / seconds / float64(clients)))
+	sb.WriteString(fmt.Sprintf("  keys sent: %.1f/s per client\n", float64(s.keysSent) / seconds / float64(clients)))
+
+	writeMessages(&sb, "websocket", s.ws, seconds, clients)
+	writeMessages(&sb, "data channel", s.dc, seconds, clients)
+
+	s.pings = s.pings[:0]
+	s.lostPings = 0
+	s.keysSent = 0
+	s.ws = make(map[MessageType]*messageStats)
+	s.dc = make(map[MessageType]*messageStats)
+	return sb.String()
+}
+
+func writeMessages(sb *strings.Builder, label string, messages map[MessageType]*messageStats, seconds float64, clients int) {
+	types := make([]MessageType, 0, len(messages))
+	total := 0
+	for msgType, stats := range(messages) {
+		types = append(types, msgType)
+		total += stats.bytes
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+
+	sb.WriteString(fmt.Sprintf("  %s: %.1f KB/s per client\n", label, float64(total) / 1024 / seconds / float64(clients)))
+	for _, msgType := range(types) {
+		stats := messages[msgType]
+		name, ok := messageNames[msgType]
+		if !ok {
+			name = fmt.Sprintf("type %d", msgType)
+		}
+		sb.WriteString(fmt.Sprintf("    %-12s %8d msgs, avg %6d B, max %6d B\n", name, stats.count, stats.bytes / stats.count, stats.maxBytes))
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(float64(len(sorted) - 1) * p)
+	return sorted[index]
+}
@@ -17,9 +17,6 @@ const (
 	archWhite int = 0xffffff
 	archGray int = 0x444444
 
-	boltColor int = 0xffa610
-	chargedBoltColor int = 0x10b3ff
-	rocketExplosionColor int = 0xbb4444
 	tableColor int = 0x996312
 
 	starRed = 0xed0505
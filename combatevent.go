@@ -0,0 +1,62 @@
+package main
+
+type CombatEventType uint8
+const (
+	unknownCombatEvent CombatEventType = iota
+	killCombatEvent
+	vipEscortedCombatEvent
+	goalChargedCombatEvent
+	roundWonCombatEvent
+)
+
+// Only the fields relevant to the event type are set
+type CombatEvent struct {
+	T CombatEventType
+
+	// Kill
+	Killer SpacedId
+	Victim SpacedId
+	Weapon EquipType
+	Damage DamageType
+	Assists []SpacedId
+
+	// VIP and round events
+	Vip SpacedId
+	Team uint8
+	Scores map[uint8]int
+}
+
+func NewKillEvent(killer SpacedId, victim SpacedId) CombatEvent {
+	return CombatEvent {
+		T: killCombatEvent,
+		Killer: killer,
+		Victim: victim,
+		Assists: make([]SpacedId, 0),
+	}
+}
+
+func NewVipEvent(eventType CombatEventType, vip SpacedId, team uint8) CombatEvent {
+	return CombatEvent {
+		T: eventType,
+		Vip: vip,
+		Team: team,
+	}
+}
+
+func NewRoundWonEvent(team uint8, scores map[uint8]int) CombatEvent {
+	copied := make(map[uint8]int, len(scores))
+	for t, score := range(scores) {
+		copied[t] = score
+	}
+
+	return CombatEvent {
+		T: roundWonCombatEvent,
+		Team: team,
+		Scores: copied,
+	}
+}
+
+type CombatEventMsg struct {
+	T MessageType
+	Es []CombatEvent
+}
@@ -1,9 +1,5 @@
 package main
 
-import (
-	"fmt"
-)
-
 type Prop uint8
 const (
 	unknownProp Prop = iota
@@ -29,6 +25,12 @@ const (
 	scoreProp
 	vipProp
 	teamsProp
+
+	endProp
+
+	roundTimeProp
+	freezeTimeProp
+	overtimeProp
 )
 
 type PropMap map[Prop]interface{}
@@ -60,7 +62,7 @@ func (d Data) Valid(prop Prop) bool {
 
 func (d *Data) Set(prop Prop, data interface{}) {
 	if !d.Valid(prop) {
-		Log(fmt.Sprintf("Error: trying to set invalid prop %d", prop))
+		logger.Error("trying to set invalid prop %d", prop)
 		return
 	}
 	d.props[prop] = data
@@ -17,6 +17,12 @@ const (
 	boosterEquip
 	chargerEquip
 	jetpackEquip
+
+	laserWeapon
+	meleeEquip
+	shieldEquip
+
+	armorEquip
 )
 
 type PartStateType uint8
@@ -42,6 +48,15 @@ func NewEquipPart(equip* Equip, equipType EquipType) EquipPart {
 		return NewJetpack(equip.GetOwner())
 	}
 
+	if def, ok := GetWeaponDefinition(equipType); ok {
+		if def.melee != nil {
+			return NewMelee(equip.GetOwner(), *def.melee)
+		}
+		if def.shield != nil {
+			return NewShield(equip.GetOwner(), *def.shield)
+		}
+	}
+
 	return nil
 }
 
@@ -28,7 +28,7 @@ func (ec EquipCharger) State() PartStateType {
 
 func (ec *EquipCharger) SetPressed(pressed bool) {
 	if !ec.pressed && pressed {
-		ec.pressedTime = time.Now()
+		ec.pressedTime = timeNow()
 	}
 
 	ec.pressed = pressed
@@ -31,7 +31,7 @@ func NewExpiration() Expiration {
 func (e *Expiration) SetConstantTTL(ttl time.Duration) {
 	e.mode = constantExpirationMode
 
-	e.startTime = time.Now()
+	e.startTime = timeNow()
 	e.ttl = ttl
 }
 
@@ -51,7 +51,7 @@ func (e Expiration) Expired() bool {
 	}
 
 	if e.mode == constantExpirationMode {
-		return time.Now().Sub(e.startTime) >= e.ttl
+		return timeNow().Sub(e.startTime) >= e.ttl
 	}
 
 	if e.mode == variableExpirationMode {
@@ -65,4 +65,33 @@ func (e *Expiration) PostUpdate(updateSpeed float64) {
 	if e.mode == variableExpirationMode {
 		e.frames -= updateSpeed
 	}
+}
+
+type ExpirationSnapshot struct {
+	Mode ExpirationMode
+	Remaining time.Duration
+	Frames float64
+}
+
+func (e Expiration) SnapshotTTL() ExpirationSnapshot {
+	snapshot := ExpirationSnapshot {
+		Mode: e.mode,
+		Frames: e.frames,
+	}
+	if e.mode == constantExpirationMode {
+		snapshot.Remaining = e.ttl - timeNow().Sub(e.startTime)
+	}
+	return snapshot
+}
+
+func (e *Expiration) RestoreTTL(snapshot ExpirationSnapshot) {
+	switch snapshot.Mode {
+	case constantExpirationMode:
+		e.SetConstantTTL(snapshot.Remaining)
+	case variableExpirationMode:
+		e.mode = variableExpirationMode
+		e.frames = snapshot.Frames
+	default:
+		e.RemoveTTL()
+	}
 }
\ No newline at end of file
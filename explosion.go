@@ -8,6 +8,7 @@ type Explosion struct {
 	BaseObject
 	hits map[SpacedId]bool
 	activeFrames int
+	damage int
 }
 
 func NewExplosion(init Init) *Explosion {
@@ -15,6 +16,7 @@ func NewExplosion(init Init) *Explosion {
 		BaseObject: NewCircleObject(init),
 		hits: make(map[SpacedId]bool, 0),
 		activeFrames: 3,
+		damage: 0,
 	}
 	overlapOptions := NewColliderOptions()
 	overlapOptions.SetSpaces(playerSpace)
@@ -24,6 +26,10 @@ func NewExplosion(init Init) *Explosion {
 	return explosion
 }
 
+func (e *Explosion) SetDamage(damage int) {
+	e.damage = damage
+}
+
 func (e *Explosion) Hit(object Object, now time.Time) {
 	if isWasm {
 		return
@@ -53,6 +59,26 @@ func (e *Explosion) Hit(object Object, now time.Time) {
 
 	force.Add(object.Vel(), 1.0)
 	object.AddForce(force)
+
+	// Full damage near the center, falling off like the knockback
+	if player, ok := object.(*Player); ok && e.damage > 0 && e.canDamage(player) {
+		damage := NewDamage(e.GetOwner(), explosiveDamage, int(float64(e.damage) * distScalar), e.Pos())
+		damage.SetRegion(bodyRegion)
+		player.TakeDamage(damage)
+	}
+}
+
+// Owners and their teammates only get knocked back
+func (e Explosion) canDamage(player *Player) bool {
+	if player.GetSpacedId() == e.GetOwner() {
+		return false
+	}
+	team, ok := e.GetByteAttribute(teamByteAttribute)
+	if !ok || team == 0 {
+		return true
+	}
+	playerTeam, _ := player.GetByteAttribute(teamByteAttribute)
+	return team != playerTeam
 }
 
 func (e *Explosion) Update(grid *Grid, now time.Time) {
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 )
@@ -17,19 +16,19 @@ func serveFiles(dir string) {
 
 		info, err := os.Stat(url)
 		if os.IsNotExist(err) {
-			log.Printf("404 for url: %s", url)
+			logger.Warn("404 for url: %s", url)
 			notFound(w)
 			return
 		} else if info.IsDir() {
 			index := url + "/index.html"
 			if _, err := os.Stat(index); os.IsNotExist(err) {
-				log.Printf("404 for url (no index): %s", url)
+				logger.Warn("404 for url (no index): %s", url)
 				notFound(w)
 				return
 			}
 		} 
 
-		log.Printf("serving file %s", url)
+		logger.Debug("serving file %s", url)
 		http.ServeFile(w, r, url)
 	})
 }
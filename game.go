@@ -8,6 +8,9 @@ const (
 	frameMillis int = 16
 	frameTime time.Duration = 16 * time.Millisecond
 	gameVersion string = "0.1"
+
+	// Nothing on screen is further from the player than this
+	maxMouseDist float64 = 40
 )
 
 type GameUpdateType uint8
@@ -16,12 +19,17 @@ const (
 	objectGameUpdate
 	levelGameUpdate
 	gameStateUpdate
+	combatEventGameUpdate
+	stateChangeGameUpdate
 )
 
 type Game struct {
 	grid *Grid
 	level *Level
 	seqNum SeqNumType
+
+	// Transitions made during the last update
+	stateChanges []StateChange
 }
 
 func NewGame() *Game {
@@ -30,6 +38,7 @@ func NewGame() *Game {
 		grid: grid,
 		level: NewLevel(),
 		seqNum: 0,
+		stateChanges: make([]StateChange, 0),
 	}
 	return game
 }
@@ -85,10 +94,25 @@ func (g *Game) ProcessKeyMsg(id IdType, keyMsg KeyMsg) {
 		return
 	}
 	player := g.grid.Get(Id(playerSpace, id)).(*Player)
+
+	mouse := keyMsg.M
+	mouse.Sub(player.Pos(), 1)
+	if mouse.Len() > maxMouseDist {
+		mouse.Scale(maxMouseDist / mouse.Len())
+		mouse.Add(player.Pos(), 1)
+		keyMsg.M = mouse
+	}
+	keyMsg.D.Normalize()
+
 	player.UpdateKeys(keyMsg)
 }
 
 func (g *Game) Update() map[GameUpdateType]bool {
+	return g.UpdateAt(timeNow())
+}
+
+// Steps the game as if it were now, so frames can be simulated faster than real time
+func (g *Game) UpdateAt(now time.Time) map[GameUpdateType]bool {
 	updates := make(map[GameUpdateType]bool)
 
 	state, stateChanged := g.grid.GetGameState()
@@ -105,11 +129,22 @@ func (g *Game) Update() map[GameUpdateType]bool {
 		updates[gameStateUpdate] = true
 	}
 
-	now := time.Now()
 	g.grid.Update(now)
 	updates[objectGameUpdate] = true
+	if g.grid.PopGameModeUpdated() {
+		updates[gameStateUpdate] = true
+	}
 	g.seqNum++
 
+	if g.grid.HasCombatEvents() {
+		updates[combatEventGameUpdate] = true
+	}
+
+	g.stateChanges = g.grid.PopStateChanges()
+	if len(g.stateChanges) > 0 {
+		updates[stateChangeGameUpdate] = true
+	}
+
 	return updates
 }
 
@@ -132,6 +167,7 @@ func (g *Game) createLevelInitMsg() LevelInitMsg {
 		T: levelInitType,
 		L: g.level.GetId(),
 		S: g.level.GetSeed(),
+		W: weaponOverrides,
 	}
 }
 
@@ -188,9 +224,43 @@ func (g *Game) createObjectUpdateMsg() (ObjectStateMsg, bool) {
 	return msg, true
 }
 
+func (g *Game) createCombatEventMsg() CombatEventMsg {
+	return CombatEventMsg{
+		T: combatEventType,
+		Es: g.grid.PopCombatEvents(),
+	}
+}
+
+func (g Game) GetStateChanges() []StateChange {
+	return g.stateChanges
+}
+
+func (g *Game) createStateChangeMsg() StateChangeMsg {
+	return StateChangeMsg{
+		T: stateChangeType,
+		Cs: g.stateChanges,
+	}
+}
+
 func (g *Game) createGameStateMsg() GameStateMsg {
 	return GameStateMsg{
 		T: gameStateType,
 		G: g.grid.GetGameStateProps(),
 	}
+}
+
+// Copy of the message without the round timer props, which clients before roundTimerProtocolVersion can't parse
+func (msg GameStateMsg) withoutRoundTimer() GameStateMsg {
+	props := make(PropMap)
+	for prop, value := range(msg.G) {
+		switch prop {
+		case roundTimeProp, freezeTimeProp, overtimeProp:
+			continue
+		}
+		props[prop] = value
+	}
+	return GameStateMsg {
+		T: msg.T,
+		G: props,
+	}
 }
\ No newline at end of file
@@ -1,5 +1,9 @@
 package main
 
+import (
+	"time"
+)
+
 type GameStateType uint8
 const (
 	unknownGameState GameStateType = iota
@@ -9,15 +13,74 @@ const (
 	victoryGameState
 )
 
+type TeamAssignmentType uint8
+const (
+	unknownTeamAssignment TeamAssignmentType = iota
+	// Players walk into portals
+	portalTeamAssignment
+	// Keep team sizes even
+	countTeamAssignment
+	// Keep team sizes even and split the best players by K/D
+	kdTeamAssignment
+)
+
+type TeamOptions struct {
+	assignment TeamAssignmentType
+
+	// Randomize teams when going back to the lobby
+	shuffle bool
+
+	// Idle players are moved to team 0 and don't block the game from starting, 0 to disable
+	afkTimeout time.Duration
+}
+
+func NewTeamOptions() TeamOptions {
+	return TeamOptions {
+		assignment: portalTeamAssignment,
+		shuffle: false,
+		afkTimeout: 0,
+	}
+}
+
+type RoundOptions struct {
+	// 0 for no limit
+	timeLimit time.Duration
+
+	// Keys are disabled for this long at the start of every round
+	freeze time.Duration
+}
+
+func NewRoundOptions() RoundOptions {
+	return RoundOptions {
+		timeLimit: 0,
+		freeze: 0,
+	}
+}
+
 type GameMode interface {
 	DataMethods
 
 	GetConfig() GameModeConfig
 	GetState() (GameStateType, bool)
-	SetState(state GameStateType)
+	SetState(g *Grid, state GameStateType) error
+	HasStateChanges() bool
+	PopStateChanges() []StateChange
+	GetTeamScores() map[uint8]int
+	GetWinningTeam() uint8
+	// Whether the last round decided the match
+	MatchOver() bool
+	SetRequiredPlayers(count int, timeout time.Duration)
+	SetTeamOptions(options TeamOptions)
+	SetRoundOptions(options RoundOptions)
+
+	// Whether props changed without a state change since the last call
+	PopUpdated() bool
 
 	Update(g * Grid)
 	SetWinningTeam(team uint8)
+
+	Snapshot() GameModeSnapshot
+	Restore(g *Grid, snapshot GameModeSnapshot)
 }
 
 type GameModeConfig struct {
@@ -29,37 +92,80 @@ type GameModeConfig struct {
 	levelId LevelIdType
 }
 
+// Players are saved by id and looked up again on restore, so objects need to be restored first
+type GameModeSnapshot struct {
+	State GameStateType
+	StateTimer TimerSnapshot
+
+	LeftTeam uint8
+	RightTeam uint8
+	Reverse bool
+	NextState GameStateType
+	LevelId LevelIdType
+
+	Players []IdType
+	Teams map[uint8][]IdType
+	WinningTeam uint8
+	Scores map[uint8]int
+
+	RequiredPlayers int
+	RequiredTimer TimerSnapshot
+
+	Assignment TeamAssignmentType
+	Shuffle bool
+	AfkTimeout time.Duration
+	TimeLimit time.Duration
+	Freeze time.Duration
+
+	// VipMode
+	Vip SpacedId
+	NextVip map[uint8]int
+	FreezeTimer TimerSnapshot
+	RoundTimer TimerSnapshot
+	Overtime bool
+}
+
 type BaseGameMode struct {
 	config GameModeConfig
 
-	lastState GameStateType
-	state GameStateType
-	firstFrame bool
+	stateMachine StateMachine
 
 	players map[SpacedId]Object
 	teams map[uint8][]Object
 
 	winningTeam uint8
 	teamScores map[uint8]int
+
+	// Players to wait for before starting, e.g. when matchmaking fills the room
+	requiredPlayers int
+	requiredTimer Timer
+
+	teamOptions TeamOptions
+	roundOptions RoundOptions
+	updated bool
 }
 
 func NewBaseGameMode() BaseGameMode {
 	return BaseGameMode {
-		lastState: unknownGameState,
-		state: unknownGameState,
-		firstFrame: false,
+		stateMachine: NewStateMachine(unknownGameState),
 
 		players: make(map[SpacedId]Object),
 		teams: make(map[uint8][]Object),
 
 		winningTeam: 0,
 		teamScores: make(map[uint8]int),
+
+		requiredPlayers: 0,
+		requiredTimer: NewTimer(0),
+
+		teamOptions: NewTeamOptions(),
+		roundOptions: NewRoundOptions(),
+		updated: false,
 	}
 }
 
 func (bgm *BaseGameMode) Update(g * Grid) {
-	bgm.firstFrame = bgm.lastState != bgm.state
-	bgm.lastState = bgm.state
+	bgm.stateMachine.Update(g)
 }
 
 func (bgm BaseGameMode) GetConfig() GameModeConfig {
@@ -67,16 +173,66 @@ func (bgm BaseGameMode) GetConfig() GameModeConfig {
 }
 
 func (bgm BaseGameMode) GetState() (GameStateType, bool) {
-	return bgm.state, bgm.state != bgm.lastState
+	return bgm.stateMachine.State(), bgm.stateMachine.Changed()
+}
+
+// Invalid transitions are logged and leave the state unchanged
+func (bgm *BaseGameMode) SetState(g *Grid, state GameStateType) error {
+	err := bgm.stateMachine.Transition(g, state)
+	if err != nil {
+		logger.Error("%v", err)
+	}
+	return err
+}
+
+func (bgm BaseGameMode) HasStateChanges() bool {
+	return len(bgm.stateMachine.changes) > 0
+}
+
+func (bgm *BaseGameMode) PopStateChanges() []StateChange {
+	return bgm.stateMachine.PopChanges()
+}
+
+func (bgm BaseGameMode) GetTeamScores() map[uint8]int {
+	return bgm.teamScores
+}
+
+func (bgm BaseGameMode) GetWinningTeam() uint8 {
+	return bgm.winningTeam
+}
+
+func (bgm BaseGameMode) MatchOver() bool {
+	return false
+}
+
+func (bgm *BaseGameMode) SetRequiredPlayers(count int, timeout time.Duration) {
+	bgm.requiredPlayers = count
+	bgm.requiredTimer.SetDuration(timeout)
+	bgm.requiredTimer.Start()
+}
+
+func (bgm *BaseGameMode) SetTeamOptions(options TeamOptions) {
+	bgm.teamOptions = options
+}
+
+func (bgm *BaseGameMode) SetRoundOptions(options RoundOptions) {
+	bgm.roundOptions = options
+}
+
+func (bgm *BaseGameMode) PopUpdated() bool {
+	updated := bgm.updated
+	bgm.updated = false
+	return updated
 }
 
-func (bgm *BaseGameMode) SetState(state GameStateType) {
-	bgm.state = state
+// True once enough players joined or the wait timed out
+func (bgm BaseGameMode) hasRequiredPlayers(count int) bool {
+	return count >= bgm.requiredPlayers || !bgm.requiredTimer.On()
 }
 
 func (bgm* BaseGameMode) SetData(data Data) {
 	if data.Has(stateProp) {
-		bgm.SetState(data.Get(stateProp).(GameStateType))
+		bgm.stateMachine.ForceState(data.Get(stateProp).(GameStateType))
 	}
 }
 
@@ -90,7 +246,7 @@ func (bgm BaseGameMode) GetData() Data {
 
 func (bgm BaseGameMode) GetUpdates() Data {
 	data := NewData()
-	data.Set(stateProp, bgm.state)
+	data.Set(stateProp, bgm.stateMachine.State())
 	data.Set(scoreProp, bgm.teamScores)
 
 	teams := make(map[uint8][]IdType)
@@ -102,4 +258,96 @@ func (bgm BaseGameMode) GetUpdates() Data {
 	data.Set(teamsProp, teams)
 
 	return data
+}
+
+func (bgm BaseGameMode) Snapshot() GameModeSnapshot {
+	snapshot := GameModeSnapshot {
+		State: bgm.stateMachine.State(),
+		StateTimer: bgm.stateMachine.timer.Snapshot(),
+
+		LeftTeam: bgm.config.leftTeam,
+		RightTeam: bgm.config.rightTeam,
+		Reverse: bgm.config.reverse,
+		NextState: bgm.config.nextState,
+		LevelId: bgm.config.levelId,
+
+		Players: make([]IdType, 0, len(bgm.players)),
+		Teams: make(map[uint8][]IdType),
+		WinningTeam: bgm.winningTeam,
+		Scores: make(map[uint8]int),
+
+		RequiredPlayers: bgm.requiredPlayers,
+		RequiredTimer: bgm.requiredTimer.Snapshot(),
+
+		Assignment: bgm.teamOptions.assignment,
+		Shuffle: bgm.teamOptions.shuffle,
+		AfkTimeout: bgm.teamOptions.afkTimeout,
+		TimeLimit: bgm.roundOptions.timeLimit,
+		Freeze: bgm.roundOptions.freeze,
+
+		Vip: InvalidId(),
+		NextVip: make(map[uint8]int),
+	}
+
+	for sid := range(bgm.players) {
+		snapshot.Players = append(snapshot.Players, sid.GetId())
+	}
+	// Team order picks the next VIP
+	for team, players := range(bgm.teams) {
+		for _, player := range(players) {
+			snapshot.Teams[team] = append(snapshot.Teams[team], player.GetId())
+		}
+	}
+	for team, score := range(bgm.teamScores) {
+		snapshot.Scores[team] = score
+	}
+	return snapshot
+}
+
+// Resumes the saved state without running its hooks, players missing from the grid are dropped
+func (bgm *BaseGameMode) Restore(g *Grid, snapshot GameModeSnapshot) {
+	bgm.stateMachine.Restore(snapshot.State, snapshot.StateTimer)
+
+	bgm.config = GameModeConfig {
+		leftTeam: snapshot.LeftTeam,
+		rightTeam: snapshot.RightTeam,
+		reverse: snapshot.Reverse,
+		nextState: snapshot.NextState,
+		levelId: snapshot.LevelId,
+	}
+
+	bgm.players = make(map[SpacedId]Object)
+	for _, id := range(snapshot.Players) {
+		if player := g.Get(Id(playerSpace, id)); player != nil {
+			bgm.players[player.GetSpacedId()] = player
+		}
+	}
+	bgm.teams = make(map[uint8][]Object)
+	for team, ids := range(snapshot.Teams) {
+		for _, id := range(ids) {
+			if player := g.Get(Id(playerSpace, id)); player != nil {
+				bgm.teams[team] = append(bgm.teams[team], player)
+			}
+		}
+	}
+
+	bgm.winningTeam = snapshot.WinningTeam
+	bgm.teamScores = make(map[uint8]int)
+	for team, score := range(snapshot.Scores) {
+		bgm.teamScores[team] = score
+	}
+
+	bgm.requiredPlayers = snapshot.RequiredPlayers
+	bgm.requiredTimer.Restore(snapshot.RequiredTimer)
+
+	bgm.teamOptions = TeamOptions {
+		assignment: snapshot.Assignment,
+		shuffle: snapshot.Shuffle,
+		afkTimeout: snapshot.AfkTimeout,
+	}
+	bgm.roundOptions = RoundOptions {
+		timeLimit: snapshot.TimeLimit,
+		freeze: snapshot.Freeze,
+	}
+	bgm.updated = true
 }
\ No newline at end of file
@@ -5,8 +5,10 @@ go 1.17
 
 require (
 	github.com/gorilla/websocket v1.5.0
+	github.com/pion/turn/v2 v2.0.6
 	github.com/pion/webrtc/v3 v3.1.23
 	github.com/vmihailenco/msgpack/v5 v5.3.5
+	go.etcd.io/bbolt v1.3.6
 )
 
 require (
@@ -25,7 +27,6 @@ require (
 	github.com/pion/srtp/v2 v2.0.5 // indirect
 	github.com/pion/stun v0.3.5 // indirect
 	github.com/pion/transport v0.13.0 // indirect
-	github.com/pion/turn/v2 v2.0.6 // indirect
 	github.com/pion/udp v0.1.1 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	golang.org/x/crypto v0.0.0-20220131195533-30dcbda58838 // indirect
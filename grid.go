@@ -1,24 +1,15 @@
 package main
 
 import (
-	"fmt"
 	"time"
 )
 
+// Cell index in the broadphase
 type GridCoord struct {
 	x int
 	y int
 }
 
-func (gc *GridCoord) advance(grid *Grid, i int, j int) {
-	gc.x += i * grid.GetUnitLength()
-	gc.y += j * grid.GetUnitHeight()
-}
-
-func (gc *GridCoord) contains(grid *Grid, point Vec2) bool {
-	return point.X >= float64(gc.x) && point.X < float64(gc.x + grid.GetUnitLength()) && point.Y >= float64(gc.y) && point.Y < float64(gc.y + grid.GetUnitHeight())
-}
-
 type Grid struct {
 	unitLength int
 	unitHeight int
@@ -29,11 +20,20 @@ type Grid struct {
 	objects map[SpacedId]Object
 	spacedObjects map[SpaceType]map[IdType]Object
 
-	grid map[GridCoord]map[SpacedId]Object
-	reverseGrid map[SpacedId][]GridCoord
+	broadphase Broadphase
+	// Scratch space for raw broadphase results, always copied out before returning
+	queried []Object
+	// Reused by GetColliders, which never nests
+	colliders []Object
+
+	combatEvents []CombatEvent
 }
 
 func NewGrid(unitLength int, unitHeight int) *Grid {
+	return NewGridWithBroadphase(hashBroadphase, unitLength, unitHeight)
+}
+
+func NewGridWithBroadphase(broadphaseType BroadphaseType, unitLength int, unitHeight int) *Grid {
 	return &Grid {
 		unitLength: unitLength,
 		unitHeight: unitHeight,
@@ -43,8 +43,11 @@ func NewGrid(unitLength int, unitHeight int) *Grid {
 		lastId: make(map[SpaceType]IdType, 0),
 		objects: make(map[SpacedId]Object, 0),
 		spacedObjects: make(map[SpaceType]map[IdType]Object, 0),
-		grid: make(map[GridCoord]map[SpacedId]Object, 0),
-		reverseGrid: make(map[SpacedId][]GridCoord, 0),
+		broadphase: NewBroadphase(broadphaseType, unitLength, unitHeight),
+		queried: make([]Object, 0),
+		colliders: make([]Object, 0),
+
+		combatEvents: make([]CombatEvent, 0),
 	}
 }
 
@@ -58,9 +61,38 @@ func (g *Grid) GetUnitHeight() int {
 
 func (g Grid) GetGameState() (GameStateType, bool) { return g.gameMode.GetState() }
 func (g Grid) GetGameModeConfig() GameModeConfig { return g.gameMode.GetConfig() }
-func (g *Grid) SetGameState(state GameStateType) { g.gameMode.SetState(state) }
+func (g *Grid) SetGameState(state GameStateType) error { return g.gameMode.SetState(g, state) }
+func (g Grid) HasStateChanges() bool { return g.gameMode.HasStateChanges() }
+func (g *Grid) PopStateChanges() []StateChange { return g.gameMode.PopStateChanges() }
 func (g *Grid) SetWinningTeam(team uint8) { g.gameMode.SetWinningTeam(team) }
+func (g Grid) GetWinningTeam() uint8 { return g.gameMode.GetWinningTeam() }
+func (g Grid) GetTeamScores() map[uint8]int { return g.gameMode.GetTeamScores() }
+func (g Grid) MatchOver() bool { return g.gameMode.MatchOver() }
+func (g *Grid) SetRequiredPlayers(count int, timeout time.Duration) { g.gameMode.SetRequiredPlayers(count, timeout) }
+func (g *Grid) SetTeamOptions(options TeamOptions) { g.gameMode.SetTeamOptions(options) }
+func (g *Grid) SetRoundOptions(options RoundOptions) { g.gameMode.SetRoundOptions(options) }
+func (g *Grid) PopGameModeUpdated() bool { return g.gameMode.PopUpdated() }
 func (g Grid) GetGameStateProps() PropMap { return g.gameMode.GetUpdates().Props() }
+func (g Grid) SnapshotGameMode() GameModeSnapshot { return g.gameMode.Snapshot() }
+func (g *Grid) RestoreGameMode(snapshot GameModeSnapshot) { g.gameMode.Restore(g, snapshot) }
+
+func (g *Grid) AddCombatEvent(event CombatEvent) {
+	if isWasm {
+		return
+	}
+	g.combatEvents = append(g.combatEvents, event)
+}
+
+func (g Grid) HasCombatEvents() bool {
+	return len(g.combatEvents) > 0
+}
+
+// Returns and clears the events since the last call
+func (g *Grid) PopCombatEvents() []CombatEvent {
+	events := g.combatEvents
+	g.combatEvents = make([]CombatEvent, 0)
+	return events
+}
 
 func (g *Grid) New(init Init) Object {
 	switch init.GetSpace() {
@@ -104,56 +136,35 @@ func (g *Grid) New(init Init) Object {
 		return NewGoal(init)
 	case spawnSpace:
 		return NewSpawn(init)
+	case tracerSpace:
+		return NewTracer(init)
 	default:
-		Log(fmt.Sprintf("Unknown space! %+v", init))
+		logger.Error("unknown space: %+v", init)
 		return nil
 	}
 }
 
 func (g *Grid) Upsert(object Object) {
-	coords := g.getCoords(object)
 	sid := object.GetSpacedId()
 
 	if _, ok := g.spacedObjects[sid.GetSpace()]; !ok {
 		g.spacedObjects[sid.GetSpace()] = make(map[IdType]Object, 0)
 	}
 
-	if g.Has(sid) {
-		// Check for equality
-		currentCoords := g.reverseGrid[sid]
-
-		if len(coords) == len(currentCoords) {
-			equal := true
-			for i := range(coords) {
-				if coords[i] != currentCoords[i] {
-					equal = false
-					break
-				}
-			}
-			if equal {
-				return
-			}
-		}
-	} else {
+	if !g.Has(sid) {
 		// Insert since it's missing
-		g.insert(sid, object)
-	}
-
-	// Update coords
-	g.deleteCoords(sid)
-	for _, coord := range(coords) {
-		if _, ok := g.grid[coord]; !ok {
-			g.grid[coord] = make(map[SpacedId]Object)
+		if !g.insert(sid, object) {
+			return
 		}
-		g.grid[coord][sid] = object
 	}
-	g.reverseGrid[sid] = coords
+
+	g.broadphase.Upsert(object)
 }
 
-func (g *Grid) insert(sid SpacedId, object Object) {
+func (g *Grid) insert(sid SpacedId, object Object) bool {
 	if sid.Invalid() {
-		Log(fmt.Sprintf("Invalid ID: %+v", sid))
-		return
+		logger.Error("invalid ID: %+v", sid)
+		return false
 	}
 
 	g.objects[sid] = object
@@ -164,15 +175,7 @@ func (g *Grid) insert(sid SpacedId, object Object) {
 	} else if sid.GetId() > lastId {
 		g.lastId[sid.GetSpace()] = sid.GetId()
 	}
-}
-
-func (g *Grid) deleteCoords(sid SpacedId) {
-	if coords, ok := g.reverseGrid[sid]; ok {
-		for _, coord := range(coords) {
-			delete(g.grid[coord], sid)
-		}
-		delete(g.reverseGrid, sid)
-	}
+	return true
 }
 
 func (g *Grid) Update(now time.Time) {
@@ -227,7 +230,7 @@ func (g *Grid) HardDelete(sid SpacedId) {
 	if object, ok := g.objects[sid]; ok {
 		object.OnDelete(g)
 	}
-	g.deleteCoords(sid)
+	g.broadphase.Delete(sid)
 	delete(g.objects, sid)
 
 	if _, ok := g.spacedObjects[sid.GetSpace()]; ok {
@@ -359,27 +362,34 @@ func (g *Grid) GetManyObjects(spaces ...SpaceType) map[SpaceType]map[IdType]Obje
 	return objects
 }
 
-func (g *Grid) GetNearbyObjects(object Object) map[SpacedId]Object {
-	nearbyObjects := make(map[SpacedId]Object)
+// Appends objects the object might collide with to results, like Broadphase.Query.
+func (g *Grid) GetNearbyObjects(object Object, results []Object) []Object {
+	return g.appendNearbyObjects(object, ObjectAABB(object), results)
+}
 
-	for _, coord := range(g.getCoords(object)) {
-		for sid, other := range(g.grid[coord]) {
-			if sid == object.GetSpacedId() {
-				continue
-			}
-			if !object.GetOverlapOptions().Evaluate(other) && !object.GetSnapOptions().Evaluate(other) {
-				continue
-			}
-			nearbyObjects[sid] = other
+func (g *Grid) appendNearbyObjects(object Object, box AABB, results []Object) []Object {
+	sid := object.GetSpacedId()
+	overlapOptions := object.GetOverlapOptions()
+	snapOptions := object.GetSnapOptions()
+
+	g.queried = g.broadphase.Query(box, g.queried[:0])
+	for _, other := range(g.queried) {
+		if other.GetSpacedId() == sid {
+			continue
 		}
+		if !overlapOptions.Evaluate(other) && !snapOptions.Evaluate(other) {
+			continue
+		}
+		results = append(results, other)
 	}
-	return nearbyObjects
+	return results
 }
 
 func (g *Grid) GetColliders(object Object) ObjectHeap {
 	heap := make(ObjectHeap, 0)
 
-	for _, other := range(g.GetNearbyObjects(object)) {
+	g.colliders = g.GetNearbyObjects(object, g.colliders[:0])
+	for _, other := range(g.colliders) {
 		results := object.OverlapProfile(other.GetProfile())
 		if results.hit {
 			item := &ObjectItem {
@@ -395,7 +405,13 @@ func (g *Grid) GetColliders(object Object) ObjectHeap {
 func (g *Grid) GetCollidersCheckLine(object Object, line Line) ObjectHeap {
 	heap := make(ObjectHeap, 0)
 
-	for _, other := range(g.GetNearbyObjects(object)) {
+	box := ObjectAABB(object)
+	lineBox := NewAABBFromLine(line)
+	box.Min.X, box.Min.Y = Min(box.Min.X, lineBox.Min.X), Min(box.Min.Y, lineBox.Min.Y)
+	box.Max.X, box.Max.Y = Max(box.Max.X, lineBox.Max.X), Max(box.Max.Y, lineBox.Max.Y)
+
+	g.colliders = g.appendNearbyObjects(object, box, g.colliders[:0])
+	for _, other := range(g.colliders) {
 		results := object.OverlapProfile(other.GetProfile())
 		if results.hit {
 			item := &ObjectItem {
@@ -416,38 +432,3 @@ func (g *Grid) GetCollidersCheckLine(object Object, line Line) ObjectHeap {
 	}
 	return heap
 }
-
-
-func (g* Grid) getCoord(point Vec2) GridCoord {
-	cx := IntDown(point.X)
-	cy := IntDown(point.Y)
-
-	return GridCoord {
-		x: cx - Mod(cx, g.unitLength),
-		y: cy - Mod(cy, g.unitHeight),
-	}
-}
-
-func (g* Grid) getCoords(object Object) []GridCoord {
-	pos := object.Pos()
-	dim := object.Dim()
-
-	coords := make([]GridCoord, 0)
-
-	xmin := pos.X - dim.X / 2
-	xmax := pos.X + dim.X / 2
-	ymin := pos.Y - dim.Y / 2
-	ymax := pos.Y + dim.Y / 2
-
-	cxmin := IntDown(xmin) - Mod(IntDown(xmin), g.unitLength)
-	cxmax := IntUp(xmax) - Mod(IntUp(xmax), g.unitLength)
-	cymin := IntDown(ymin) - Mod(IntDown(ymin), g.unitHeight)
-	cymax := IntUp(ymax) - Mod(IntUp(ymax), g.unitHeight)
-
-	for x := cxmin; x <= cxmax; x += g.unitLength {
-		for y := cymin; y <= cymax; y += g.unitHeight {
-			coords = append(coords, GridCoord{x : x, y: y})
-		}
-	}
-	return coords
-}
\ No newline at end of file
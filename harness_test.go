@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Runs a Game without a Room or websockets. Frames are simulated with a fake clock.
+type testHarness struct {
+	t testing.TB
+	game *Game
+	now time.Time
+	seqNum SeqNumType
+}
+
+func newTestHarness(t testing.TB, level LevelIdType) *testHarness {
+	t.Helper()
+
+	h := &testHarness {
+		t: t,
+		now: time.Now(),
+		seqNum: 0,
+	}
+
+	// Timers run on the fake clock too
+	prev := timeNow
+	timeNow = func() time.Time { return h.now }
+	t.Cleanup(func() { timeNow = prev })
+
+	h.game = NewGame()
+	h.game.LoadLevel(level, 1234)
+	return h
+}
+
+func (h *testHarness) grid() *Grid {
+	return h.game.GetGrid()
+}
+
+func (h *testHarness) addPlayer(id IdType, team uint8) *Player {
+	h.t.Helper()
+
+	player := h.game.Add(NewInit(Id(playerSpace, id), NewVec2(0, 0), NewVec2(0.8, 1.44))).(*Player)
+	player.SetTeam(team)
+	player.SetSpawn(h.grid())
+	player.Respawn()
+	return player
+}
+
+func (h *testHarness) player(id IdType) *Player {
+	h.t.Helper()
+
+	object := h.grid().Get(Id(playerSpace, id))
+	if object == nil {
+		h.t.Fatalf("player %d does not exist", id)
+	}
+	return object.(*Player)
+}
+
+// Sends the set of keys currently held down by the player, like the client does every frame
+func (h *testHarness) keys(id IdType, mouse Vec2, keys ...KeyType) {
+	h.seqNum += 1
+	h.game.ProcessKeyMsg(id, KeyMsg {
+		T: keyType,
+		S: h.seqNum,
+		K: keys,
+		M: mouse,
+		D: NewVec2(1, 0),
+	})
+}
+
+func (h *testHarness) step(ticks int) {
+	for i := 0; i < ticks; i += 1 {
+		h.now = h.now.Add(frameTime)
+		h.game.UpdateAt(h.now)
+	}
+}
+
+func (h *testHarness) state() GameStateType {
+	state, _ := h.grid().GetGameState()
+	return state
+}
+
+func TestHarnessMovement(t *testing.T) {
+	h := newTestHarness(t, lobbyLevel)
+	player := h.addPlayer(0, 1)
+
+	// Let the player land first
+	h.step(60)
+	start := player.Pos()
+
+	h.keys(0, player.Pos(), rightKey)
+	h.step(30)
+	if player.Pos().X <= start.X {
+		t.Errorf("expected player to move right from %v, got %v", start, player.Pos())
+	}
+
+	h.keys(0, player.Pos())
+	h.step(60)
+	stopped := player.Pos()
+	h.step(10)
+	if player.Pos().X != stopped.X {
+		t.Errorf("expected player to stop at %v, got %v", stopped, player.Pos())
+	}
+}
+
+func TestHarnessClock(t *testing.T) {
+	h := newVipHarness(t)
+	h.step(2)
+	mode := h.grid().gameMode.(*VipMode)
+	if !mode.freezeTimer.Started() {
+		t.Fatalf("expected freeze timer to start with the round")
+	}
+
+	// Timers only move when the harness steps
+	remaining := mode.freezeTimer.Remaining()
+	time.Sleep(20 * time.Millisecond)
+	if r := mode.freezeTimer.Remaining(); r != remaining {
+		t.Errorf("expected timer to ignore the wall clock, went from %v to %v", remaining, r)
+	}
+	h.step(10)
+	if r := mode.freezeTimer.Remaining(); r != remaining - 10 * frameTime {
+		t.Errorf("expected %v remaining after 10 frames, got %v", remaining - 10 * frameTime, r)
+	}
+}
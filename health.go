@@ -9,8 +9,56 @@ const (
 	lastDamageTime time.Duration = 10 * time.Second
 )
 
+type DamageType uint8
+const (
+	unknownDamage DamageType = iota
+	bulletDamage
+	explosiveDamage
+	fallDamage
+	voidDamage
+	meleeDamage
+)
+
+type HitRegion uint8
+const (
+	unknownRegion HitRegion = iota
+	bodyRegion
+	headRegion
+	legsRegion
+)
+
+type Damage struct {
+	sid SpacedId
+	damageType DamageType
+	amount int
+
+	// Where the damage came from, e.g. the projectile or explosion center
+	source Vec2
+	region HitRegion
+}
+
+func NewDamage(sid SpacedId, damageType DamageType, amount int, source Vec2) Damage {
+	return Damage {
+		sid: sid,
+		damageType: damageType,
+		amount: amount,
+		source: source,
+		region: unknownRegion,
+	}
+}
+
+func (d Damage) GetSpacedId() SpacedId { return d.sid }
+func (d Damage) GetType() DamageType { return d.damageType }
+func (d Damage) GetAmount() int { return d.amount }
+func (d Damage) GetSource() Vec2 { return d.source }
+func (d Damage) GetRegion() HitRegion { return d.region }
+
+func (d *Damage) SetAmount(amount int) { d.amount = amount }
+func (d *Damage) SetRegion(region HitRegion) { d.region = region }
+
 type DamageTick struct {
 	sid SpacedId
+	damageType DamageType
 	damage int
 	time time.Time
 }
@@ -57,7 +105,7 @@ func (h Health) Dead() bool {
 }
 
 func (h Health) GetLastTicks(duration time.Duration) []DamageTick {
-	currentTime := time.Now()
+	currentTime := timeNow()
 	for i, tick := range(h.ticks) {
 		if currentTime.Sub(tick.time) <= duration {
 			return h.ticks[i:]
@@ -66,33 +114,55 @@ func (h Health) GetLastTicks(duration time.Duration) []DamageTick {
 	return make([]DamageTick, 0)
 }
 
+// Last object to deal damage, skipping damage without a source like falling or the void
 func (h Health) GetLastDamageId(duration time.Duration) SpacedId {
-	if len(h.ticks) == 0 {
-		return InvalidId()
-	}
-
-	tick := h.ticks[len(h.ticks)-1]
-
-	if time.Now().Sub(tick.time) <= duration {
-		return tick.sid
+	currentTime := timeNow()
+	for i := len(h.ticks) - 1; i >= 0; i -= 1 {
+		tick := h.ticks[i]
+		if currentTime.Sub(tick.time) > duration {
+			break
+		}
+		if tick.sid.Valid() {
+			return tick.sid
+		}
 	}
 	return InvalidId()
 }
 
-func (h *Health) TakeDamage(sid SpacedId, damage int) {
-	if !h.enabled || h.Dead() || isWasm || damage == 0 {
+func (h *Health) TakeDamage(damage Damage) {
+	if !h.enabled || h.Dead() || isWasm || damage.amount <= 0 {
 		return
 	}
-	h.SetHealth(h.health - damage)
+	h.SetHealth(h.health - damage.amount)
 
 	tick := DamageTick {
-		sid: sid,
-		damage: damage,
-		time: time.Now(),
+		sid: damage.sid,
+		damageType: damage.damageType,
+		damage: damage.amount,
+		time: timeNow(),
 	}
 	h.ticks = append(h.ticks, tick)
 
 	if len(h.ticks) > maxDamageTicks {
 		h.ticks = h.ticks[1 : maxDamageTicks + 1]
 	}
+}
+
+// Recent damage isn't saved, so kills right after a restore have no assists
+type HealthSnapshot struct {
+	Enabled bool
+	Health int
+}
+
+func (h Health) SnapshotHealth() HealthSnapshot {
+	return HealthSnapshot {
+		Enabled: h.enabled,
+		Health: h.health,
+	}
+}
+
+func (h *Health) RestoreHealth(snapshot HealthSnapshot) {
+	if snapshot.Enabled {
+		h.SetHealth(snapshot.Health)
+	}
 }
\ No newline at end of file
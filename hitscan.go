@@ -0,0 +1,134 @@
+package main
+
+import (
+	"time"
+)
+
+// Weapon part that resolves its shot instantly with a raycast instead of spawning a projectile.
+type Hitscan struct {
+	weapon *Weapon
+
+	pressed bool
+	state PartStateType
+
+	maxAmmo int
+	ammo int
+	ammoTimer Timer
+	reloadTimer Timer
+
+	damage int
+	distance float64
+	knockback float64
+	color int
+}
+
+func NewHitscan(weapon *Weapon, def HitscanDefinition) *Hitscan {
+	h := &Hitscan {
+		weapon: weapon,
+		pressed: false,
+		state: unknownPartState,
+
+		maxAmmo: def.maxAmmo,
+		ammo: 0,
+		ammoTimer: NewTimer(def.ammoDuration),
+		reloadTimer: NewTimer(def.reloadDuration),
+
+		damage: def.damage,
+		distance: def.distance,
+		knockback: def.knockback,
+		color: def.color,
+	}
+	h.Reload()
+	return h
+}
+
+func (h Hitscan) State() PartStateType { return h.state }
+
+func (h *Hitscan) SetPressed(pressed bool) { h.pressed = pressed }
+func (h *Hitscan) Reload() { h.ammo = h.maxAmmo }
+
+func (h *Hitscan) Update(grid *Grid, now time.Time) {
+	if h.ammo > 0 && h.pressed {
+		if h.ammoTimer.On() {
+			h.state = rechargingPartState
+			return
+		}
+
+		h.state = activePartState
+		h.Shoot(grid, now)
+		return
+	}
+
+	if h.ammo == 0 {
+		if h.reloadTimer.On() {
+			h.state = rechargingPartState
+			return
+		}
+		h.Reload()
+	}
+
+	h.state = readyPartState
+}
+
+func (h *Hitscan) Shoot(grid *Grid, now time.Time) {
+	h.ammo -= 1
+	h.ammoTimer.Start()
+	h.reloadTimer.Start()
+
+	if isWasm {
+		return
+	}
+
+	origin := h.weapon.GetShotOrigin()
+	dir := h.weapon.Dir()
+	ray := dir
+	ray.Scale(h.distance)
+	line := NewLine(origin, ray)
+
+	options := NewColliderOptions()
+	options.SetSpaces(playerSpace, wallSpace)
+	options.SetAttributes(deadAttribute)
+
+	owner := grid.Get(h.weapon.GetOwner())
+	if owner != nil {
+		options.SetIds(false, owner.GetSpacedId())
+		if team, ok := owner.GetByteAttribute(teamByteAttribute); ok && team > 0 {
+			options.ExcludeByteAttributes(teamByteAttribute, team)
+		}
+	}
+
+	end := line.Endpoint()
+	hit := grid.RaycastFirst(line, options)
+	if hit.GetHit() {
+		end = hit.GetPoint()
+		h.Hit(hit, origin, dir)
+	}
+
+	init := NewInit(grid.NextSpacedId(tracerSpace), origin, NewVec2(0.1, 0.1))
+	init.SetInitDir(dir)
+	tracer := NewTracer(init)
+	tracer.SetEnd(end)
+	tracer.SetIntAttribute(colorIntAttribute, h.color)
+	if owner != nil {
+		tracer.SetOwner(owner.GetSpacedId())
+	}
+	grid.Upsert(tracer)
+}
+
+func (h *Hitscan) Hit(hit RaycastHit, origin Vec2, dir Vec2) {
+	switch target := hit.GetObject().(type) {
+	case *Player:
+		if target.Blocks(dir) {
+			return
+		}
+		damage := NewDamage(h.weapon.GetOwner(), bulletDamage, h.damage, origin)
+		damage.SetRegion(target.GetHitRegion(hit.GetPoint()))
+		target.TakeDamage(damage)
+
+		force := dir
+		force.Scale(h.knockback)
+		target.AddForce(force)
+	}
+}
+
+func (h *Hitscan) OnDelete(grid *Grid) {}
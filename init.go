@@ -24,6 +24,7 @@ const (
 	portalSpace
 	goalSpace
 	spawnSpace
+	tracerSpace
 )
 
 type SpacedId struct {
@@ -1,5 +1,9 @@
 package main
 
+import (
+	"time"
+)
+
 // Can't get this to work with uint8 for some reason
 type KeyType uint16
 const (
@@ -24,6 +28,9 @@ type Keys struct {
 	dir Vec2
 	lastKeys map[KeyType]bool
 	lastKeyChange map[KeyType]SeqNumType
+
+	// Last time any key changed, used to find idle players
+	lastActive time.Time
 }
 
 func NewKeys() Keys {
@@ -34,6 +41,8 @@ func NewKeys() Keys {
 		dir: NewVec2(0, 0),
 		lastKeys: make(map[KeyType]bool),
 		lastKeyChange: make(map[KeyType]SeqNumType),
+
+		lastActive: timeNow(),
 	}
 }
 
@@ -41,6 +50,10 @@ func (k *Keys) SetEnabled(enabled bool) {
 	k.enabled = enabled
 }
 
+func (k Keys) Enabled() bool {
+	return k.enabled
+}
+
 func (k Keys) GetKeys() map[KeyType]bool {
 	if !k.enabled {
 		return make(map[KeyType]bool)
@@ -76,6 +89,10 @@ func (k Keys) MouseDir() Vec2 {
 	return k.dir
 }
 
+func (k Keys) IdleTime() time.Duration {
+	return timeNow().Sub(k.lastActive)
+}
+
 func (k *Keys) UpdateKeys(keyMsg KeyMsg) {
 	seqNum := keyMsg.S
 
@@ -116,11 +133,15 @@ func (k *Keys) maybeUpdateKey(key KeyType, update bool, seqNum SeqNumType) {
 	if !hasKey || !hasUpdate {
 		k.keys[key] = update
 		k.lastKeyChange[key] = seqNum
+		if update {
+			k.lastActive = timeNow()
+		}
 		return
 	}
 
 	if pressed != update && lastUpdate < seqNum {
 		k.keys[key] = update
 		k.lastKeyChange[key] = seqNum
+		k.lastActive = timeNow()
 	}
 }
\ No newline at end of file
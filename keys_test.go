@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+)
+
+type keyUpdate struct {
+	pressed bool
+	seqNum SeqNumType
+}
+
+func TestMaybeUpdateKey(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		updates []keyUpdate
+		pressed bool
+		lastChange SeqNumType
+	}{
+		{"first press", []keyUpdate{{true, 1}}, true, 1},
+		{"first release", []keyUpdate{{false, 3}}, false, 3},
+		{"press then release", []keyUpdate{{true, 1}, {false, 2}}, false, 2},
+		{"release arrives late", []keyUpdate{{true, 2}, {false, 1}}, true, 2},
+		{"same seq num", []keyUpdate{{true, 2}, {false, 2}}, true, 2},
+		{"repeated press keeps first change", []keyUpdate{{true, 1}, {true, 2}, {true, 3}}, true, 1},
+		{"out of order press", []keyUpdate{{true, 1}, {false, 4}, {true, 3}}, false, 4},
+		{"press release press", []keyUpdate{{true, 1}, {false, 2}, {true, 3}}, true, 3},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			keys := NewKeys()
+			for _, update := range(tc.updates) {
+				keys.maybeUpdateKey(jumpKey, update.pressed, update.seqNum)
+			}
+
+			if pressed := keys.keys[jumpKey]; pressed != tc.pressed {
+				t.Errorf("expected pressed %t, got %t", tc.pressed, pressed)
+			}
+			if lastChange := keys.lastKeyChange[jumpKey]; lastChange != tc.lastChange {
+				t.Errorf("expected last change %d, got %d", tc.lastChange, lastChange)
+			}
+		})
+	}
+}
+
+func TestUpdateKeysReleasesMissingKeys(t *testing.T) {
+	keys := NewKeys()
+	keys.UpdateKeys(KeyMsg {S: 1, K: []KeyType{leftKey, jumpKey}})
+	keys.UpdateKeys(KeyMsg {S: 2, K: []KeyType{leftKey}})
+
+	if !keys.KeyDown(leftKey) {
+		t.Errorf("expected left key to stay down")
+	}
+	if keys.KeyDown(jumpKey) {
+		t.Errorf("expected jump key to be released")
+	}
+
+	// A stale message shouldn't press the key again
+	keys.UpdateKeys(KeyMsg {S: 1, K: []KeyType{leftKey, jumpKey}})
+	if keys.KeyDown(jumpKey) {
+		t.Errorf("expected stale message to be ignored")
+	}
+}
+
+func TestProcessKeyMsgClampsMouse(t *testing.T) {
+	h := newTestHarness(t, lobbyLevel)
+	player := h.addPlayer(0, 1)
+	h.step(1)
+
+	pos := player.Pos()
+	h.keys(0, NewVec2(pos.X + 1000, pos.Y))
+
+	if dist := player.Mouse().Distance(pos); dist > maxMouseDist + 1e-6 {
+		t.Errorf("expected mouse within %.1f of the player, got %.1f", maxMouseDist, dist)
+	}
+	if !player.MouseDir().ApproxUnit() {
+		t.Errorf("expected unit mouse dir, got %v", player.MouseDir())
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// Clients send keys every frame, leave room for bursts after a lag spike
+	maxKeyMsgsPerSecond int = 120
+	maxKeysPerMsg int = 8
+
+	// Late messages are expected over the data channel, but not this late
+	maxSeqNumRewind SeqNumType = 120
+	// About 10s of dropped messages
+	maxSeqNumJump SeqNumType = 600
+
+	keyViolationWindow time.Duration = 10 * time.Second
+	// Kick after this many violations in one window
+	keyKickThreshold int = 100
+)
+
+var validKeys = map[KeyType]bool {
+	upKey: true,
+	downKey: true,
+	leftKey: true,
+	rightKey: true,
+	jumpKey: true,
+	interactKey: true,
+	mouseClick: true,
+	altMouseClick: true,
+}
+
+// Older than the last accepted message but within the normal reordering window, drop without counting it
+var errStaleKeyMsg = errors.New("stale key message")
+
+// Checks key messages from one client before they reach the game
+type KeyValidator struct {
+	lastSeqNum SeqNumType
+	hasSeqNum bool
+
+	rateStart time.Time
+	msgs int
+
+	violationStart time.Time
+	violations int
+}
+
+func NewKeyValidator() *KeyValidator {
+	return &KeyValidator {
+		hasSeqNum: false,
+		msgs: 0,
+		violations: 0,
+	}
+}
+
+// Violations in the current window
+func (kv KeyValidator) Violations() int {
+	return kv.violations
+}
+
+func (kv KeyValidator) ShouldKick() bool {
+	return kv.violations >= keyKickThreshold
+}
+
+// Returns an error if the message should be dropped
+func (kv *KeyValidator) Validate(msg KeyMsg, now time.Time) error {
+	if now.Sub(kv.rateStart) >= time.Second {
+		kv.rateStart = now
+		kv.msgs = 0
+	}
+	if now.Sub(kv.violationStart) >= keyViolationWindow {
+		kv.violationStart = now
+		kv.violations = 0
+	}
+
+	kv.msgs += 1
+	if kv.msgs > maxKeyMsgsPerSecond {
+		return kv.violation("sent more than %d key messages in a second", maxKeyMsgsPerSecond)
+	}
+
+	if len(msg.K) > maxKeysPerMsg {
+		return kv.violation("sent %d keys", len(msg.K))
+	}
+	for _, key := range(msg.K) {
+		if !validKeys[key] {
+			return kv.violation("sent unknown key %d", key)
+		}
+	}
+
+	if !finite(msg.M) || !finite(msg.D) {
+		return kv.violation("sent invalid mouse %v, dir %v", msg.M, msg.D)
+	}
+
+	if kv.hasSeqNum {
+		if msg.S <= kv.lastSeqNum {
+			if kv.lastSeqNum - msg.S > maxSeqNumRewind {
+				return kv.violation("replayed seq num %d, last was %d", msg.S, kv.lastSeqNum)
+			}
+			return errStaleKeyMsg
+		}
+		if msg.S - kv.lastSeqNum > maxSeqNumJump {
+			return kv.violation("skipped from seq num %d to %d", kv.lastSeqNum, msg.S)
+		}
+	}
+
+	kv.lastSeqNum = msg.S
+	kv.hasSeqNum = true
+	return nil
+}
+
+func (kv *KeyValidator) violation(format string, args ...interface{}) error {
+	kv.violations += 1
+	return fmt.Errorf(format, args...)
+}
+
+func finite(v Vec2) bool {
+	return !math.IsNaN(v.X) && !math.IsNaN(v.Y) && !math.IsInf(v.X, 0) && !math.IsInf(v.Y, 0)
+}
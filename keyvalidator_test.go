@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestKeyValidator(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		msgs []KeyMsg
+		// Whether the last message is accepted
+		ok bool
+		violations int
+	}{
+		{"valid", []KeyMsg{{S: 1, K: []KeyType{leftKey, jumpKey}}}, true, 0},
+		{"unknown key", []KeyMsg{{S: 1, K: []KeyType{KeyType(99)}}}, false, 1},
+		{"unknown key zero", []KeyMsg{{S: 1, K: []KeyType{unknownKey}}}, false, 1},
+		{"too many keys", []KeyMsg{{S: 1, K: make([]KeyType, maxKeysPerMsg + 1)}}, false, 1},
+		{"nan mouse", []KeyMsg{{S: 1, M: NewVec2(math.NaN(), 0)}}, false, 1},
+		{"infinite dir", []KeyMsg{{S: 1, D: NewVec2(0, math.Inf(1))}}, false, 1},
+		{"in order", []KeyMsg{{S: 1}, {S: 2}, {S: 3}}, true, 0},
+		{"late message", []KeyMsg{{S: 10}, {S: 8}}, false, 0},
+		{"duplicate", []KeyMsg{{S: 10}, {S: 10}}, false, 0},
+		{"replay", []KeyMsg{{S: 500}, {S: 500 - maxSeqNumRewind - 1}}, false, 1},
+		{"jump", []KeyMsg{{S: 1}, {S: 2 + maxSeqNumJump}}, false, 1},
+		{"any first seq num", []KeyMsg{{S: 123456}}, true, 0},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			kv := NewKeyValidator()
+			now := time.Now()
+
+			var err error
+			for _, msg := range(tc.msgs) {
+				now = now.Add(frameTime)
+				err = kv.Validate(msg, now)
+			}
+
+			if ok := err == nil; ok != tc.ok {
+				t.Errorf("expected ok %t, got error %v", tc.ok, err)
+			}
+			if kv.Violations() != tc.violations {
+				t.Errorf("expected %d violations, got %d", tc.violations, kv.Violations())
+			}
+		})
+	}
+}
+
+func TestKeyValidatorRateLimit(t *testing.T) {
+	kv := NewKeyValidator()
+	now := time.Now()
+
+	for i := 1; i <= maxKeyMsgsPerSecond; i += 1 {
+		if err := kv.Validate(KeyMsg {S: SeqNumType(i)}, now); err != nil {
+			t.Fatalf("message %d: unexpected error %v", i, err)
+		}
+	}
+	if err := kv.Validate(KeyMsg {S: SeqNumType(maxKeyMsgsPerSecond + 1)}, now); err == nil {
+		t.Errorf("expected message over the limit to be dropped")
+	}
+
+	// Next second has a fresh budget
+	if err := kv.Validate(KeyMsg {S: SeqNumType(maxKeyMsgsPerSecond + 2)}, now.Add(time.Second)); err != nil {
+		t.Errorf("unexpected error after a second: %v", err)
+	}
+}
+
+func TestKeyValidatorKick(t *testing.T) {
+	kv := NewKeyValidator()
+	now := time.Now()
+
+	for i := 0; i < keyKickThreshold - 1; i += 1 {
+		kv.Validate(KeyMsg {S: 1, K: []KeyType{KeyType(99)}}, now)
+	}
+	if kv.ShouldKick() {
+		t.Fatalf("kicked before reaching the threshold")
+	}
+
+	// Violations from an earlier window don't count
+	kv.Validate(KeyMsg {S: 1, K: []KeyType{KeyType(99)}}, now.Add(keyViolationWindow))
+	if kv.ShouldKick() {
+		t.Fatalf("kicked with violations from an old window")
+	}
+
+	for i := 0; i < keyKickThreshold; i += 1 {
+		kv.Validate(KeyMsg {S: 1, K: []KeyType{KeyType(99)}}, now.Add(keyViolationWindow))
+	}
+	if !kv.ShouldKick() {
+		t.Errorf("expected kick after %d violations", kv.Violations())
+	}
+}
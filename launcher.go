@@ -1,7 +1,6 @@
 package main
 
 import (
-	"math"
 	"time"
 )
 
@@ -34,72 +33,33 @@ type Launcher struct {
 	currentProjectiles map[SpacedId]bool
 }
 
-func NewLauncher(weapon *Weapon, space SpaceType) *Launcher {
+func NewLauncher(weapon *Weapon, def LauncherDefinition) *Launcher {
 	l := &Launcher {
 		weapon: weapon,
 		pressed: false,
-		space: space,
+		space: def.space,
 		state: unknownPartState,
 
-		maxAmmo: 0,
+		maxAmmo: def.maxAmmo,
 		ammo: 0,
-		ammoTimer: NewTimer(0),
-		reloadTimer: NewTimer(0),
-
-		projectileSize: NewVec2(0, 0),
-		projectileRelativeSpeed: false,
-		projectileDeleteOnRelease: false,
-		projectileVel: 0,
-		projectileAcc: 0,
-		projectileJerk: 0,
-		projectileLimit: 0,
-		projectileNumber: 1,
-		projectileSpread: 0,
-
-		chargedSize: NewVec2(0, 0),
-		chargedVel: 0,
+		ammoTimer: NewTimer(def.ammoDuration),
+		reloadTimer: NewTimer(def.reloadDuration),
+
+		projectileSize: def.size,
+		projectileRelativeSpeed: def.relativeSpeed,
+		projectileDeleteOnRelease: def.deleteOnRelease,
+		projectileVel: def.vel,
+		projectileAcc: def.acc,
+		projectileJerk: def.jerk,
+		projectileLimit: def.limit,
+		projectileNumber: def.number,
+		projectileSpread: def.spread,
+
+		chargedSize: def.chargedSize,
+		chargedVel: def.chargedVel,
 
 		currentProjectiles: make(map[SpacedId]bool),
 	}
-
-	switch space {
-	case pelletSpace:
-		l.maxAmmo = 2
-		l.ammoTimer.SetDuration(200 * time.Millisecond)
-		l.reloadTimer.SetDuration(800 * time.Millisecond)
-		l.projectileSize = NewVec2(0.2, 0.2)
-		l.projectileVel = 30
-		l.projectileNumber = 4
-		l.projectileSpread = 0.025 * math.Pi
-	case boltSpace:
-		l.maxAmmo = 3
-		l.ammoTimer.SetDuration(100 * time.Millisecond)
-		l.reloadTimer.SetDuration(400 * time.Millisecond)
-		l.projectileSize = NewVec2(0.5, 0.15)
-		l.projectileVel = 30
-		l.chargedSize = NewVec2(0.6, 0.25)
-		l.chargedVel = 45
-	case rocketSpace:
-		l.maxAmmo = 1
-		l.reloadTimer.SetDuration(1000 * time.Millisecond)
-		l.projectileSize = NewVec2(0.5, 0.5)
-		l.projectileVel = 5
-		l.projectileRelativeSpeed = true
-		l.projectileAcc = 50
-	case starSpace:
-		l.maxAmmo = 4
-		l.ammoTimer.SetDuration(125 * time.Millisecond)
-		l.reloadTimer.SetDuration(700 * time.Millisecond)
-		l.projectileVel = 25
-		l.projectileSize = NewVec2(0.3, 0.3)
-	case grapplingHookSpace:
-		l.maxAmmo = 1
-		l.reloadTimer.SetDuration(1000 * time.Millisecond)
-		l.projectileSize = NewVec2(0.3, 0.3)
-		l.projectileDeleteOnRelease = true
-		l.projectileVel = 30
-		l.projectileLimit = 1
-	}
 	l.Reload()
 	return l
 }
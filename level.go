@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"math/rand"
 )
 
@@ -45,7 +44,7 @@ func (l *Level) LoadLevel(id LevelIdType, seed LevelSeedType, grid *Grid) {
 	case birdTownLevel:
 		l.loadBirdTown(seed, grid)
 	default:
-		Log(fmt.Sprintf("Unknown map: %d", id))
+		logger.Error("unknown map: %d", id)
 		return
 	}
 
@@ -1,14 +1,170 @@
 package main
 
-import "log"
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
-func Log(message string) {
-	log.Printf("%s", message)
+type LogLevel uint8
+const (
+	unknownLogLevel LogLevel = iota
+	debugLogLevel
+	infoLogLevel
+	warnLogLevel
+	errorLogLevel
+)
+
+var logLevelNames = map[LogLevel]string {
+	debugLogLevel: "debug",
+	infoLogLevel: "info",
+	warnLogLevel: "warn",
+	errorLogLevel: "error",
+}
+
+// Set from LOG_LEVEL and LOG_FORMAT in main
+var minLogLevel = infoLogLevel
+var jsonLogs = false
+
+var logOutput io.Writer = os.Stderr
+var logMutex sync.Mutex
+
+// Default logger without any fields
+var logger = NewLogger()
+
+func ParseLogLevel(name string) (LogLevel, bool) {
+	for level, levelName := range(logLevelNames) {
+		if strings.EqualFold(name, levelName) {
+			return level, true
+		}
+	}
+	return unknownLogLevel, false
 }
 
-func WasmDebug(format string, v ...interface{}) {
-	if !isWasm {
+// Adds fields like room, client and msgType to every line
+type Logger struct {
+	fields map[string]interface{}
+}
+
+func NewLogger() Logger {
+	return Logger {
+		fields: make(map[string]interface{}),
+	}
+}
+
+// Returns a copy with the extra field
+func (l Logger) With(key string, value interface{}) Logger {
+	fields := make(map[string]interface{}, len(l.fields) + 1)
+	for k, v := range(l.fields) {
+		fields[k] = v
+	}
+	fields[key] = value
+	return Logger {
+		fields: fields,
+	}
+}
+
+func (l Logger) Debug(format string, v ...interface{}) {
+	l.log(debugLogLevel, format, v...)
+}
+
+func (l Logger) Info(format string, v ...interface{}) {
+	l.log(infoLogLevel, format, v...)
+}
+
+func (l Logger) Warn(format string, v ...interface{}) {
+	l.log(warnLogLevel, format, v...)
+}
+
+func (l Logger) Error(format string, v ...interface{}) {
+	l.log(errorLogLevel, format, v...)
+}
+
+func (l Logger) log(level LogLevel, format string, v ...interface{}) {
+	if level < minLogLevel {
 		return
 	}
-	log.Printf(format, v)
-}
\ No newline at end of file
+
+	message := format
+	if len(v) > 0 {
+		message = fmt.Sprintf(format, v...)
+	}
+	now := time.Now()
+
+	var line string
+	if jsonLogs {
+		line = l.formatJSON(now, level, message)
+	} else {
+		line = l.formatText(now, level, message)
+	}
+
+	logMutex.Lock()
+	io.WriteString(logOutput, line + "\n")
+	logMutex.Unlock()
+}
+
+func (l Logger) formatJSON(now time.Time, level LogLevel, message string) string {
+	entry := make(map[string]interface{}, len(l.fields) + 3)
+	for k, v := range(l.fields) {
+		entry[k] = v
+	}
+	entry["time"] = now.Format(time.RFC3339Nano)
+	entry["level"] = logLevelNames[level]
+	entry["msg"] = message
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return l.formatText(now, level, message)
+	}
+	return string(b)
+}
+
+func (l Logger) formatText(now time.Time, level LogLevel, message string) string {
+	keys := make([]string, 0, len(l.fields))
+	for k := range(l.fields) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(now.Format("2006/01/02 15:04:05 "))
+	sb.WriteString(strings.ToUpper(logLevelNames[level]))
+	sb.WriteString(" ")
+	for _, k := range(keys) {
+		sb.WriteString(fmt.Sprintf("%s=%v ", k, l.fields[k]))
+	}
+	sb.WriteString(message)
+	return sb.String()
+}
+
+// Lets one line through per interval and counts the rest, for messages that can repeat every second
+type LogSampler struct {
+	interval time.Duration
+	last time.Time
+	suppressed int
+}
+
+func NewLogSampler(interval time.Duration) *LogSampler {
+	return &LogSampler {
+		interval: interval,
+		suppressed: 0,
+	}
+}
+
+// Returns whether to log now and how many lines were skipped since the last one
+func (ls *LogSampler) Sample(now time.Time) (bool, int) {
+	if !ls.last.IsZero() && now.Sub(ls.last) < ls.interval {
+		ls.suppressed += 1
+		return false, 0
+	}
+
+	suppressed := ls.suppressed
+	ls.last = now
+	ls.suppressed = 0
+	return true, suppressed
+}
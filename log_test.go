@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Captures log lines until the test ends
+func captureLogs(t *testing.T, level LogLevel, json bool) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	prevOutput, prevLevel, prevJSON := logOutput, minLogLevel, jsonLogs
+	logOutput, minLogLevel, jsonLogs = &buf, level, json
+	t.Cleanup(func() {
+		logOutput, minLogLevel, jsonLogs = prevOutput, prevLevel, prevJSON
+	})
+	return &buf
+}
+
+func TestLoggerText(t *testing.T) {
+	buf := captureLogs(t, debugLogLevel, false)
+
+	logger.With("room", "abcd").With("client", IdType(3)).Warn("slow %s", "FPS")
+
+	line := strings.TrimSpace(buf.String())
+	if !strings.HasSuffix(line, "WARN client=3 room=abcd slow FPS") {
+		t.Errorf("unexpected line %q", line)
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	buf := captureLogs(t, debugLogLevel, true)
+
+	logger.With("room", "abcd").With("msgType", keyType).Error("bad input")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected JSON, got %q: %v", buf.String(), err)
+	}
+	for key, expected := range(map[string]interface{} {
+		"room": "abcd",
+		"msgType": float64(keyType),
+		"level": "error",
+		"msg": "bad input",
+	}) {
+		if entry[key] != expected {
+			t.Errorf("expected %s=%v, got %v", key, expected, entry[key])
+		}
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Errorf("expected time field")
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	buf := captureLogs(t, warnLogLevel, false)
+
+	logger.Debug("debug")
+	logger.Info("info")
+	if buf.Len() != 0 {
+		t.Errorf("expected lines below warn to be dropped, got %q", buf.String())
+	}
+
+	logger.Error("error")
+	if !strings.Contains(buf.String(), "ERROR error") {
+		t.Errorf("expected error line, got %q", buf.String())
+	}
+}
+
+func TestLoggerWithCopies(t *testing.T) {
+	base := logger.With("room", "abcd")
+	base.With("client", 1)
+
+	if _, ok := base.fields["client"]; ok {
+		t.Errorf("expected With to leave the original logger unchanged")
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	if level, ok := ParseLogLevel("WARN"); !ok || level != warnLogLevel {
+		t.Errorf("expected warn, got %d, %t", level, ok)
+	}
+	if _, ok := ParseLogLevel("verbose"); ok {
+		t.Errorf("expected unknown level to fail")
+	}
+}
+
+func TestLogSampler(t *testing.T) {
+	sampler := NewLogSampler(10 * time.Second)
+	start := time.Now()
+
+	if ok, _ := sampler.Sample(start); !ok {
+		t.Errorf("expected first line to be logged")
+	}
+	for i := 1; i <= 3; i++ {
+		if ok, _ := sampler.Sample(start.Add(time.Duration(i) * time.Second)); ok {
+			t.Errorf("expected line %d to be skipped", i)
+		}
+	}
+
+	ok, skipped := sampler.Sample(start.Add(10 * time.Second))
+	if !ok || skipped != 3 {
+		t.Errorf("expected to log after interval with 3 skipped, got %t, %d", ok, skipped)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -12,6 +14,9 @@ import (
 
 const (
 	clientEndpoint string = "/bd3/"
+	statsEndpoint string = "/stats/"
+	matchmakingEndpoint string = "/matchmaking/"
+	roomsEndpoint string = "/rooms/"
 )
 
 var allowedOrigins = map[string]bool {
@@ -32,7 +37,72 @@ var upgrader = websocket.Upgrader{
 }
 
 func main() {
+	if levelName := os.Getenv("LOG_LEVEL"); levelName != "" {
+		level, ok := ParseLogLevel(levelName)
+		if !ok {
+			log.Fatalf("Invalid log level %s", levelName)
+		}
+		minLogLevel = level
+	}
+	jsonLogs = os.Getenv("LOG_FORMAT") == "json"
+
+	// Split rooms across several processes, see cluster.go
+	if nodes := os.Getenv("CLUSTER_NODES"); nodes != "" {
+		var err error
+		cluster, err = ParseCluster(os.Getenv("CLUSTER_SELF"), nodes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logger.Info("Running as %s in a cluster of %d nodes", cluster.Self(), len(cluster.Nodes()))
+	}
+
+	// Optionally override the built in weapon definitions without rebuilding, clients get them with the level
+	if weaponsFile := os.Getenv("WEAPONS_FILE"); weaponsFile != "" {
+		b, err := os.ReadFile(weaponsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := OverrideWeaponDefinitions(b); err != nil {
+			log.Fatalf("Failed to load weapon definitions from %s: %v", weaponsFile, err)
+		}
+		logger.Info("Loaded weapon definitions from %s", weaponsFile)
+	}
+
+	// Stats and room snapshots are kept in memory unless a database file is given
+	var err error
+	storage, err = NewStorage(os.Getenv("STATS_DB"))
+	if err != nil {
+		log.Fatalf("Failed to open stats database: %v", err)
+	}
+	defer storage.Close()
+	RestoreSavedRooms()
+
+	// Relay for players who can't connect directly, e.g. TURN_PORT=3478 TURN_PUBLIC_IP=1.2.3.4
+	if turnPort := os.Getenv("TURN_PORT"); turnPort != "" {
+		port, err := strconv.Atoi(turnPort)
+		if err != nil {
+			log.Fatalf("Invalid TURN port %s: %v", turnPort, err)
+		}
+
+		publicIP := os.Getenv("TURN_PUBLIC_IP")
+		if publicIP == "" {
+			publicIP = "127.0.0.1"
+		}
+		forceRelay, _ := strconv.ParseBool(os.Getenv("TURN_FORCE_RELAY"))
+
+		turnRelay, err = NewTurnRelay(publicIP, port, forceRelay)
+		if err != nil {
+			log.Fatalf("Failed to start TURN server: %v", err)
+		}
+		defer turnRelay.Close()
+		logger.Info("TURN server listening on port %d, relaying on %s", port, publicIP)
+	}
+
 	http.HandleFunc(clientEndpoint, clientEndpointHandler)
+	http.HandleFunc(statsEndpoint, statsEndpointHandler)
+	http.HandleFunc(matchmakingEndpoint, matchmakingEndpointHandler)
+	http.HandleFunc(roomsEndpoint, roomsEndpointHandler)
+	go matchmaker.run()
 
 	// TODO: remove this eventually
 	serveFiles("/")
@@ -40,22 +110,146 @@ func main() {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
-		log.Printf("Defaulting to port %s", port)
+		logger.Info("Defaulting to port %s", port)
 	}
 
-	log.Printf("Listening on port %s", port)
+	logger.Info("Listening on port %s", port)
 	if err := http.ListenAndServe(":" + port, nil); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// Lifetime stats for the player name in the path, e.g. /stats/brian
+func statsEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := url.PathUnescape(r.URL.Path[len(statsEndpoint):])
+	if err != nil || len(name) == 0 || len(name) > 16 {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+
+	stats, ok, err := storage.GetPlayerStats(name)
+	if err != nil {
+		logger.Error("Failed to get stats for %s: %v", name, err)
+		http.Error(w, "failed to get stats", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "no stats for " + name, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(stats)
+}
+
+type roomsResponse struct {
+	Rooms []RoomInfo `json:"rooms"`
+	Unreachable []string `json:"unreachable,omitempty"`
+}
+
+// Every room in the cluster with /rooms/, or only the ones on this node with /rooms/local.
+// The room browser uses /rooms/public, which leaves out private rooms.
+func roomsEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path[len(roomsEndpoint):] {
+	case "":
+		infos, unreachable := cluster.Directory()
+		json.NewEncoder(w).Encode(roomsResponse {
+			Rooms: infos,
+			Unreachable: unreachable,
+		})
+	case "public":
+		infos, unreachable := cluster.Directory()
+		public := make([]RoomInfo, 0, len(infos))
+		for _, info := range(infos) {
+			if info.Public {
+				public = append(public, info)
+			}
+		}
+		json.NewEncoder(w).Encode(roomsResponse {
+			Rooms: public,
+			Unreachable: unreachable,
+		})
+	case "local":
+		json.NewEncoder(w).Encode(GetRoomInfos())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type matchmakingResponse struct {
+	Ticket string `json:"ticket"`
+	Status TicketStatusType `json:"status"`
+	Room string `json:"room,omitempty"`
+	Team uint8 `json:"team,omitempty"`
+	Tokens map[string]string `json:"tokens,omitempty"`
+}
+
+// Queue with /matchmaking/join?name=a&name=b, then poll /matchmaking/poll?ticket=x every few seconds
+// until it returns a room and a token per player to connect with.
+func matchmakingEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	query := r.URL.Query()
+
+	switch r.URL.Path[len(matchmakingEndpoint):] {
+	case "join":
+		names := query["name"]
+		if len(names) == 0 || len(names) > matchmakingTeamSize {
+			http.Error(w, "party should have 1-" + strconv.Itoa(matchmakingTeamSize) + " players", http.StatusBadRequest)
+			return
+		}
+		// Tokens are handed back per name
+		seen := make(map[string]bool)
+		for _, name := range(names) {
+			if len(name) == 0 || len(name) > 16 {
+				http.Error(w, "names should be 1-16 chars long", http.StatusBadRequest)
+				return
+			}
+			if seen[name] {
+				http.Error(w, "names should be unique within the party", http.StatusBadRequest)
+				return
+			}
+			seen[name] = true
+		}
+
+		ticket := matchmaker.Join(names)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(matchmakingResponse {
+			Ticket: ticket,
+			Status: queuedTicketStatus,
+		})
+	case "poll":
+		ticket, ok := matchmaker.Poll(query.Get("ticket"))
+		if !ok {
+			http.Error(w, "unknown ticket", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(matchmakingResponse {
+			Ticket: ticket.id,
+			Status: ticket.status,
+			Room: ticket.room,
+			Team: ticket.team,
+			Tokens: ticket.tokens,
+		})
+	case "leave":
+		matchmaker.Leave(query.Get("ticket"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
 func clientEndpointHandler(w http.ResponseWriter, r *http.Request) {
 	params := strings.Split(r.URL.Path[len(clientEndpoint):], "&")
 	vars := make(map[string]string)
 	for _, param := range(params) {
 		pair := strings.Split(param, "=")
 		if len(pair) != 2 {
-			log.Printf("Malformed request: ", r.URL.Path)
+			logger.Warn("Malformed request: %s", r.URL.Path)
 			return
 		}
 
@@ -64,21 +258,21 @@ func clientEndpointHandler(w http.ResponseWriter, r *http.Request) {
 
 	room, roomOk := vars["room"]
 	if !roomOk {
-		log.Printf("Missing room!")
+		logger.Warn("Missing room!")
 		return
 	}
 	if len(room) < 4 || len(room) > 10 {
-		log.Printf("Room %s should be 4-10 chars long", room)
+		logger.Warn("Room %s should be 4-10 chars long", room)
 		return
 	}
 
 	name, nameOk := vars["name"]
 	if !nameOk {
-		log.Printf("Missing name!")
+		logger.Warn("Missing name!")
 		return
 	}
 	if len(name) == 0 || len(name) > 16 {
-		log.Printf("Name %s should be 1-16 chars long", name)
+		logger.Warn("Name %s should be 1-16 chars long", name)
 		return
 	}
 
@@ -86,18 +280,33 @@ func clientEndpointHandler(w http.ResponseWriter, r *http.Request) {
 	if idOk {
 		_, err := strconv.Atoi(id)
 		if err != nil {
-			log.Printf("Invalid ID: ", err)
+			logger.Warn("Invalid ID: %v", err)
 			return
 		}
 	}
 
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Failed to create websocket: %v", err)
+		logger.Warn("Failed to create websocket: %v", err)
 		return
 	}
 	
+	protocol, err := checkProtocol(vars)
+	if err != nil {
+		rejectProtocol(ws, protocol, err)
+		return
+	}
+	if !cluster.IsLocal(room) {
+		redirectRoom(ws, protocol, room, cluster.Owner(room))
+		return
+	}
+	if err := acceptProtocol(ws, protocol); err != nil {
+		logger.Warn("Failed to send protocol version: %v", err)
+		ws.Close()
+		return
+	}
+
 	// Try to keep the socket alive?
 	ws.SetReadDeadline(time.Time{})
-	CreateOrJoinRoom(vars, ws)
+	CreateOrJoinRoom(vars, ws, protocol)
 }
\ No newline at end of file
@@ -43,25 +43,12 @@ func (mb *MainBlock) LoadTemplate(template BlockTemplate) {
 
 	switch (template) {
 	case weaponsBlockTemplate:
-		uzi := NewPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x - width / 3, y + mb.thick), NewVec2(1.2, 1.2), bottomCardinal))
-		uzi.SetByteAttribute(typeByteAttribute, uint8(uziWeapon))
-		uzi.SetByteAttribute(subtypeByteAttribute, uint8(grapplingHookWeapon))
-		mb.objects = append(mb.objects, uzi)
-
-		star := NewPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x - width / 9, y + mb.thick), NewVec2(1.2, 1.2), bottomCardinal))
-		star.SetByteAttribute(typeByteAttribute, uint8(starWeapon))
-		star.SetByteAttribute(subtypeByteAttribute, uint8(boosterEquip))
-		mb.objects = append(mb.objects, star)
-
-		bazooka := NewPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x + width / 9, y + mb.thick), NewVec2(1.2, 1.2), bottomCardinal))
-		bazooka.SetByteAttribute(typeByteAttribute, uint8(bazookaWeapon))
-		bazooka.SetByteAttribute(subtypeByteAttribute, uint8(jetpackEquip))
-		mb.objects = append(mb.objects, bazooka)
-
-		sniper := NewPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x + width / 3, y + mb.thick), NewVec2(1.2, 1.2), bottomCardinal))
-		sniper.SetByteAttribute(typeByteAttribute, uint8(sniperWeapon))
-		sniper.SetByteAttribute(subtypeByteAttribute, uint8(chargerEquip))
-		mb.objects = append(mb.objects, sniper)
+		spacing := width / float64(len(templateWeapons))
+		for i, weaponType := range(templateWeapons) {
+			offset := (float64(i) + 0.5) * spacing - width / 2
+			pickup := NewWeaponPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x + offset, y + mb.thick), NewVec2(1.2, 1.2), bottomCardinal), weaponType)
+			mb.objects = append(mb.objects, pickup)
+		}
 
 		mb.occupied.AddAll(bottomLeftCardinal, bottomCardinal, bottomRightCardinal)
 
@@ -76,6 +63,9 @@ func (mb *MainBlock) LoadTemplate(template BlockTemplate) {
 		table.SetIntAttribute(colorIntAttribute, tableColor)
 		mb.objects = append(mb.objects, table)
 
+		armor := NewArmorPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x, y + mb.thick + table.Dim().Y), NewVec2(1, 1), bottomCardinal))
+		mb.objects = append(mb.objects, armor)
+
 		mb.occupied.Add(bottomCardinal)
 	}
 }
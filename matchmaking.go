@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	matchmakingTeamSize int = 3
+
+	// Allowed rating spread in a match, growing the longer the oldest ticket has waited
+	matchmakingBaseWindow float64 = 100
+	matchmakingWindowPerSecond float64 = 10
+
+	ticketTimeout time.Duration = 30 * time.Second
+	reservationTimeout time.Duration = 1 * time.Hour
+
+	// How long a matched room waits for everyone before starting anyway
+	matchStartTimeout time.Duration = 45 * time.Second
+)
+
+type TicketStatusType uint8
+const (
+	unknownTicketStatus TicketStatusType = iota
+	queuedTicketStatus
+	matchedTicketStatus
+)
+
+// A party waiting in the queue
+type QueueTicket struct {
+	id string
+	names []string
+	rating float64
+	joined time.Time
+	lastPoll time.Time
+
+	status TicketStatusType
+	room string
+	team uint8
+	tokens map[string]string
+}
+
+// Slot in a matched room, claimed by connecting with the token
+type Reservation struct {
+	id IdType
+	name string
+	team uint8
+}
+
+type matchedRoom struct {
+	created time.Time
+	reservations map[string]Reservation
+}
+
+type Matchmaker struct {
+	mutex sync.Mutex
+
+	queue []*QueueTicket
+	tickets map[string]*QueueTicket
+	rooms map[string]*matchedRoom
+}
+
+var matchmaker = NewMatchmaker()
+
+func NewMatchmaker() *Matchmaker {
+	return &Matchmaker {
+		queue: make([]*QueueTicket, 0),
+		tickets: make(map[string]*QueueTicket),
+		rooms: make(map[string]*matchedRoom),
+	}
+}
+
+func (m *Matchmaker) run() {
+	ticker := time.NewTicker(1 * time.Second)
+	for range(ticker.C) {
+		m.update(time.Now())
+	}
+}
+
+// Queues a party of players, returning the ticket id to poll
+func (m *Matchmaker) Join(names []string) string {
+	rating := 0.0
+	for _, name := range(names) {
+		stats, ok, err := storage.GetPlayerStats(name)
+		if err != nil || !ok {
+			stats = NewPlayerStats(name)
+		}
+		rating += stats.Rating
+	}
+	rating /= float64(len(names))
+
+	now := time.Now()
+	ticket := &QueueTicket {
+		id: newToken(),
+		names: names,
+		rating: rating,
+		joined: now,
+		lastPoll: now,
+		status: queuedTicketStatus,
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.queue = append(m.queue, ticket)
+	m.tickets[ticket.id] = ticket
+	return ticket.id
+}
+
+func (m *Matchmaker) Leave(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.removeTicket(id)
+}
+
+// Returns a copy of the ticket so it can be read outside the lock
+func (m *Matchmaker) Poll(id string) (QueueTicket, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ticket, ok := m.tickets[id]
+	if !ok {
+		return QueueTicket{}, false
+	}
+	ticket.lastPoll = time.Now()
+	return *ticket, true
+}
+
+func (m *Matchmaker) HasRoom(room string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, ok := m.rooms[room]
+	return ok
+}
+
+func (m *Matchmaker) GetReservation(room string, token string) (Reservation, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if matched, ok := m.rooms[room]; ok {
+		reservation, ok := matched.reservations[token]
+		return reservation, ok
+	}
+	return Reservation{}, false
+}
+
+func (m *Matchmaker) GetNumReservations(room string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if matched, ok := m.rooms[room]; ok {
+		return len(matched.reservations)
+	}
+	return 0
+}
+
+func (m *Matchmaker) ReleaseRoom(room string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.rooms, room)
+}
+
+func (m *Matchmaker) update(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, ticket := range(m.tickets) {
+		if now.Sub(ticket.lastPoll) > ticketTimeout {
+			m.removeTicket(id)
+		}
+	}
+	for name, matched := range(m.rooms) {
+		if now.Sub(matched.created) > reservationTimeout {
+			delete(m.rooms, name)
+		}
+	}
+
+	for {
+		tickets, teams, ok := m.findMatch(now)
+		if !ok {
+			break
+		}
+		m.createMatch(tickets, teams, now)
+	}
+}
+
+// Greedily fills two teams from tickets with similar ratings
+func (m *Matchmaker) findMatch(now time.Time) ([]*QueueTicket, []uint8, bool) {
+	sort.Slice(m.queue, func(i, j int) bool {
+		return m.queue[i].rating < m.queue[j].rating
+	})
+
+	for i, first := range(m.queue) {
+		window := matchmakingBaseWindow + matchmakingWindowPerSecond * now.Sub(first.joined).Seconds()
+
+		candidates := make([]*QueueTicket, 0)
+		for _, ticket := range(m.queue[i:]) {
+			if ticket.rating - first.rating > window {
+				break
+			}
+			candidates = append(candidates, ticket)
+		}
+
+		// Place big parties first so they don't get stuck
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return len(candidates[i].names) > len(candidates[j].names)
+		})
+
+		sizes := make(map[uint8]int)
+		ratings := make(map[uint8]float64)
+		tickets := make([]*QueueTicket, 0)
+		teams := make([]uint8, 0)
+		for _, ticket := range(candidates) {
+			team := uint8(1)
+			if sizes[2] < sizes[1] || (sizes[2] == sizes[1] && ratings[2] < ratings[1]) {
+				team = 2
+			}
+			if sizes[team] + len(ticket.names) > matchmakingTeamSize {
+				team = 3 - team
+			}
+			if sizes[team] + len(ticket.names) > matchmakingTeamSize {
+				continue
+			}
+
+			sizes[team] += len(ticket.names)
+			ratings[team] += ticket.rating * float64(len(ticket.names))
+			tickets = append(tickets, ticket)
+			teams = append(teams, team)
+
+			if sizes[1] == matchmakingTeamSize && sizes[2] == matchmakingTeamSize {
+				return tickets, teams, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+func (m *Matchmaker) createMatch(tickets []*QueueTicket, teams []uint8, now time.Time) {
+	room := newRoomName()
+	matched := &matchedRoom {
+		created: now,
+		reservations: make(map[string]Reservation),
+	}
+
+	nextId := IdType(0)
+	for i, ticket := range(tickets) {
+		ticket.status = matchedTicketStatus
+		ticket.room = room
+		ticket.team = teams[i]
+		ticket.tokens = make(map[string]string)
+
+		for _, name := range(ticket.names) {
+			token := newToken()
+			ticket.tokens[name] = token
+			matched.reservations[token] = Reservation {
+				id: nextId,
+				name: name,
+				team: teams[i],
+			}
+			nextId += 1
+		}
+
+		// Keep the ticket around so the party can still poll for the result
+		for j, queued := range(m.queue) {
+			if queued == ticket {
+				m.queue = append(m.queue[:j], m.queue[j + 1:]...)
+				break
+			}
+		}
+	}
+
+	m.rooms[room] = matched
+	logger.With("room", room).Info("matchmaking created room for %d players", nextId)
+}
+
+func (m *Matchmaker) removeTicket(id string) {
+	delete(m.tickets, id)
+	for i, ticket := range(m.queue) {
+		if ticket.id == id {
+			m.queue = append(m.queue[:i], m.queue[i + 1:]...)
+			return
+		}
+	}
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// 10 characters to fit the room name limit, and to not collide with rooms people type in.
+// Reservations only live on this node, so the room has to hash here too.
+func newRoomName() string {
+	b := make([]byte, 5)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		if name := hex.EncodeToString(b); cluster.IsLocal(name) {
+			return name
+		}
+	}
+}
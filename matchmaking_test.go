@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchmakingJoinValidation(t *testing.T) {
+	for _, tc := range([]struct {
+		query string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{"name=a&name=b&name=c&name=d", http.StatusBadRequest},
+		{"name=", http.StatusBadRequest},
+		{"name=abcdefghijklmnopq", http.StatusBadRequest},
+		{"name=a&name=b&name=a", http.StatusBadRequest},
+	}) {
+		w := httptest.NewRecorder()
+		matchmakingEndpointHandler(w, httptest.NewRequest("GET", matchmakingEndpoint + "join?" + tc.query, nil))
+		if w.Code != tc.status {
+			t.Errorf("expected status %d for %q, got %d", tc.status, tc.query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"time"
+)
+
+// Turns the running kill and death totals into a record for storage once a match is decided.
+type MatchRecorder struct {
+	room string
+	names map[IdType]string
+
+	// Totals when the current match started
+	started bool
+	baseline map[IdType]MatchPlayerRecord
+}
+
+func NewMatchRecorder(room string) *MatchRecorder {
+	return &MatchRecorder {
+		room: room,
+		names: make(map[IdType]string),
+		baseline: make(map[IdType]MatchPlayerRecord),
+	}
+}
+
+func (mr *MatchRecorder) SetName(id IdType, name string) {
+	mr.names[id] = name
+}
+
+// Called at the start of every round, only the first one of a match counts
+func (mr *MatchRecorder) Start(game *Game) {
+	if mr.started {
+		return
+	}
+
+	mr.started = true
+	mr.baseline = make(map[IdType]MatchPlayerRecord)
+	for _, player := range(game.GetGrid().GetObjects(playerSpace)) {
+		mr.baseline[player.GetId()] = mr.getTotals(player)
+	}
+}
+
+// Drops a match that ended early, e.g. because a team emptied out
+func (mr *MatchRecorder) Abandon() {
+	mr.started = false
+}
+
+// Called at the end of every round, records once the match is over
+func (mr *MatchRecorder) Record(game *Game) {
+	grid := game.GetGrid()
+	if !mr.started || !grid.MatchOver() {
+		return
+	}
+	mr.started = false
+
+	scores := make(map[uint8]int)
+	for team, score := range(grid.GetTeamScores()) {
+		scores[team] = score
+	}
+
+	match := MatchRecord {
+		Room: mr.room,
+		Time: time.Now(),
+		Level: game.level.GetId(),
+		Seed: game.level.GetSeed(),
+		WinningTeam: grid.GetWinningTeam(),
+		Scores: scores,
+		Players: make([]MatchPlayerRecord, 0),
+	}
+
+	for _, player := range(grid.GetObjects(playerSpace)) {
+		totals := mr.getTotals(player)
+		last, ok := mr.baseline[player.GetId()]
+		mr.baseline[player.GetId()] = totals
+
+		if totals.Team == 0 || len(totals.Name) == 0 {
+			continue
+		}
+
+		// Player was recreated since the match started
+		if !ok || totals.Kills < last.Kills || totals.Deaths < last.Deaths || totals.Assists < last.Assists {
+			last = MatchPlayerRecord{}
+		}
+
+		match.Players = append(match.Players, MatchPlayerRecord {
+			Name: totals.Name,
+			Team: totals.Team,
+			Kills: totals.Kills - last.Kills,
+			Deaths: totals.Deaths - last.Deaths,
+			Assists: totals.Assists - last.Assists,
+		})
+	}
+
+	if len(match.Players) == 0 {
+		return
+	}
+
+	// Don't block the game loop on disk
+	go func() {
+		if err := storage.RecordMatch(match); err != nil {
+			logger.With("room", mr.room).Error("failed to record match: %v", err)
+		}
+	}()
+}
+
+func (mr MatchRecorder) getTotals(player Object) MatchPlayerRecord {
+	team, _ := player.GetByteAttribute(teamByteAttribute)
+	kills, _ := player.GetIntAttribute(killIntAttribute)
+	deaths, _ := player.GetIntAttribute(deathIntAttribute)
+	assists, _ := player.GetIntAttribute(assistIntAttribute)
+
+	return MatchPlayerRecord {
+		Name: mr.names[player.GetId()],
+		Team: team,
+		Kills: kills,
+		Deaths: deaths,
+		Assists: assists,
+	}
+}
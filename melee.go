@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+type Melee struct {
+	owner SpacedId
+	state PartStateType
+	pressed bool
+	timer Timer
+
+	damage int
+	distance float64
+	knockback float64
+	arc float64
+}
+
+func NewMelee(owner SpacedId, def MeleeDefinition) *Melee {
+	return &Melee {
+		owner: owner,
+		state: unknownPartState,
+		pressed: false,
+		timer: NewTimer(def.cooldown),
+
+		damage: def.damage,
+		distance: def.distance,
+		knockback: def.knockback,
+		arc: def.arc,
+	}
+}
+
+func (m Melee) State() PartStateType {
+	return m.state
+}
+
+func (m *Melee) SetPressed(pressed bool) {
+	m.pressed = pressed
+}
+
+func (m *Melee) Update(grid *Grid, now time.Time) {
+	player := grid.Get(m.owner)
+	if player == nil {
+		m.state = unknownPartState
+		return
+	}
+
+	if m.timer.On() {
+		m.state = rechargingPartState
+		return
+	}
+
+	if !m.pressed {
+		m.state = readyPartState
+		return
+	}
+
+	m.state = activePartState
+	m.timer.Start()
+
+	if isWasm {
+		return
+	}
+	m.Swing(grid, player)
+}
+
+func (m *Melee) Swing(grid *Grid, player Object) {
+	options := NewColliderOptions()
+	options.SetSpaces(playerSpace)
+	options.SetAttributes(deadAttribute)
+	options.SetIds(false, player.GetSpacedId())
+	if team, ok := player.GetByteAttribute(teamByteAttribute); ok && team > 0 {
+		options.ExcludeByteAttributes(teamByteAttribute, team)
+	}
+
+	dir := player.Dir()
+	minDot := math.Cos(m.arc / 2)
+	for _, object := range(grid.QueryCircle(player.Pos(), m.distance, options)) {
+		offset := object.Pos()
+		offset.Sub(player.Pos(), 1.0)
+		offset.Normalize()
+		if !offset.IsZero() && offset.Dot(dir) < minDot {
+			continue
+		}
+
+		target, ok := object.(*Player)
+		if !ok || target.Blocks(offset) {
+			continue
+		}
+
+		damage := NewDamage(m.owner, meleeDamage, m.damage, player.Pos())
+		damage.SetRegion(bodyRegion)
+		target.TakeDamage(damage)
+
+		force := offset
+		force.Y += 0.3
+		force.Normalize()
+		force.Scale(m.knockback)
+		target.AddForce(force)
+	}
+}
+
+func (m Melee) OnDelete(grid *Grid) {}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMeleeSwing(t *testing.T) {
+	def, ok := GetWeaponDefinition(meleeEquip)
+	if !ok || def.melee == nil {
+		t.Fatalf("expected melee definition")
+	}
+
+	for _, tc := range([]struct {
+		name string
+		offset Vec2
+		hit bool
+	}{
+		{"in front", NewVec2(1, 0), true},
+		{"behind", NewVec2(-1, 0), false},
+		{"out of range", NewVec2(def.melee.distance + 1, 0), false},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHarness(t, lobbyLevel)
+			attacker := h.addPlayer(0, 1)
+			target := h.addPlayer(1, 2)
+			attacker.SetDir(NewVec2(1, 0))
+			pos := attacker.Pos()
+			pos.Add(tc.offset, 1.0)
+			target.SetPos(pos)
+			h.grid().Upsert(target)
+			health := target.GetHealth()
+
+			NewMelee(attacker.GetSpacedId(), *def.melee).Swing(h.grid(), attacker)
+
+			ticks := target.GetLastTicks(lastDamageTime)
+			if hit := len(ticks) > 0; hit != tc.hit {
+				t.Fatalf("expected hit = %t, health went from %d to %d", tc.hit, health, target.GetHealth())
+			}
+			if tc.hit && (ticks[0].damageType != meleeDamage || ticks[0].damage != def.melee.damage) {
+				t.Errorf("expected %d melee damage, got %d of type %d", def.melee.damage, ticks[0].damage, ticks[0].damageType)
+			}
+		})
+	}
+}
@@ -12,34 +12,55 @@ type Msg struct {
 	Left ClientMsg
 }
 
+// Bump when messages change in a way older clients can't handle, and raise minProtocolVersion once they're gone
+const (
+	protocolVersion int = 4
+	minProtocolVersion int = 1
+
+	// Clients from before the handshake don't send a version
+	legacyProtocolVersion int = 1
+	// Combat events and round timer props came in with state changes, before the handshake
+	stateChangeProtocolVersion int = 2
+	combatEventProtocolVersion int = 2
+	roundTimerProtocolVersion int = 2
+	binaryObjectProtocolVersion int = 3
+	redirectProtocolVersion int = 4
+)
+
 type MessageType uint8
 type SeqNumType uint32
+
+// IDs are part of the protocol, never renumber or reuse them
 const (
-	unknownType MessageType = iota
-
-	pingType
-	candidateType
-	offerType
-	answerType
-	voiceCandidateType
-
-	voiceOfferType
-	voiceAnswerType
-	initType
-	joinType
-	leftType
-
-	initVoiceType
-	joinVoiceType
-	leftVoiceType
-	chatType
-	keyType
-
-	gameStateType
-	objectDataType
-	objectUpdateType
-	playerInitType
-	levelInitType
+	unknownType MessageType = 0
+
+	pingType MessageType = 1
+	candidateType MessageType = 2
+	offerType MessageType = 3
+	answerType MessageType = 4
+	voiceCandidateType MessageType = 5
+
+	voiceOfferType MessageType = 6
+	voiceAnswerType MessageType = 7
+	initType MessageType = 8
+	joinType MessageType = 9
+	leftType MessageType = 10
+
+	initVoiceType MessageType = 11
+	joinVoiceType MessageType = 12
+	leftVoiceType MessageType = 13
+	chatType MessageType = 14
+	keyType MessageType = 15
+
+	gameStateType MessageType = 16
+	objectDataType MessageType = 17
+	objectUpdateType MessageType = 18
+	playerInitType MessageType = 19
+	levelInitType MessageType = 20
+	combatEventType MessageType = 21
+	stateChangeType MessageType = 22
+
+	versionType MessageType = 23
 )
 
 type ShotPropMaps []PropMap
@@ -63,15 +84,55 @@ type JSONPeerMsg struct {
 	JSON interface{}
 }
 
+type TransportType uint8
+const (
+	unknownTransport TransportType = iota
+	webRTCTransport
+	// Used when the data channel never opens
+	websocketTransport
+)
+
 type ClientData struct {
 	Id IdType
 	Name string
+	Transport TransportType
+}
+
+type ICEServer struct {
+	URLs []string
+	Username string
+	Credential string
+}
+
+type ICEConfig struct {
+	Servers []ICEServer
+	// all or relay
+	Policy string
 }
 
 type ClientMsg struct {
 	T MessageType
 	Client ClientData
 	Clients map[IdType]ClientData
+
+	// Only sent with initType
+	ICE *ICEConfig
+
+	// Set for voice messages when the server forwards audio instead of clients connecting to each other
+	SFU bool
+}
+
+// Sent right after connecting, rejected clients are disconnected afterwards
+type VersionMsg struct {
+	T MessageType
+	Accepted bool
+	Protocol int
+	MinProtocol int
+	Game string
+	Reason string
+
+	// Host and port of the server that has the room, the client should reconnect there
+	Redirect string
 }
 
 type ChatMsg struct {
@@ -101,6 +162,9 @@ type LevelInitMsg struct {
 	T MessageType
 	L LevelIdType
 	S LevelSeedType
+
+	// Weapon definitions JSON if the server overrides the defaults
+	W string
 }
 
 type KeyMsg struct {
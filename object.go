@@ -31,6 +31,11 @@ type Object interface {
 	SetConstantTTL(duration time.Duration)
 	SetVariableTTL(duration time.Duration)
 	RemoveTTL()
+	SnapshotTTL() ExpirationSnapshot
+	RestoreTTL(snapshot ExpirationSnapshot)
+
+	SnapshotHealth() HealthSnapshot
+	RestoreHealth(snapshot HealthSnapshot)
 
 	AddAttribute(attribute AttributeType)
 	AddInternalAttribute(attribute AttributeType)
@@ -42,6 +47,8 @@ type Object interface {
 	GetIntAttribute(attribute IntAttributeType) (int, bool)
 	SetFloatAttribute(attribute FloatAttributeType, float float64)
 	GetFloatAttribute(attribute FloatAttributeType) (float64, bool)
+	SnapshotAttributes() AttributeSnapshot
+	RestoreAttributes(snapshot AttributeSnapshot)
 
 	KeyDown(key KeyType) bool
 	MouseDir() Vec2
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"math"
+	"math/bits"
+)
+
+type ObjectEncodingType uint8
+const (
+	unknownObjectEncoding ObjectEncodingType = iota
+	msgpackObjectEncoding
+	binaryObjectEncoding
+)
+
+const (
+	// Vec2 props are sent as integers in units of 1/vec2Precision
+	vec2Precision float64 = 1000
+	// Props are written in this order, so a uint32 mask covers all of them
+	maxEncodedProp Prop = 31
+)
+
+// Encodes object data and updates, the most common messages by far
+type ObjectStateEncoder interface {
+	Encode(msg *ObjectStateMsg) ([]byte, error)
+	Decode(b []byte) (ObjectStateMsg, error)
+}
+
+var objectEncoders = map[ObjectEncodingType]ObjectStateEncoder {
+	msgpackObjectEncoding: MsgpackObjectEncoder{},
+	binaryObjectEncoding: BinaryObjectEncoder{},
+}
+
+// Clients that understand the binary format get it unless they ask for msgpack, e.g. encoding=msgpack for debugging
+func parseObjectEncoding(vars map[string]string, protocol int) ObjectEncodingType {
+	if protocol < binaryObjectProtocolVersion || vars["encoding"] == "msgpack" {
+		return msgpackObjectEncoding
+	}
+	return binaryObjectEncoding
+}
+
+// Readable with any msgpack decoder, but uses reflection and sends every key
+type MsgpackObjectEncoder struct {}
+
+func (e MsgpackObjectEncoder) Encode(msg *ObjectStateMsg) ([]byte, error) {
+	return msgpack.Marshal(msg)
+}
+
+func (e MsgpackObjectEncoder) Decode(b []byte) (ObjectStateMsg, error) {
+	msg := ObjectStateMsg{}
+	err := Unpack(b, &msg)
+	return msg, err
+}
+
+// Layout:
+//   T byte, S uvarint, number of spaces uvarint
+//   per space: space byte, number of objects uvarint
+//   per object: id uvarint, prop mask uvarint, then each prop in increasing order
+//
+// Attribute maps are sent as a mask of keys followed by their values, Vec2s are quantized to zigzag varints,
+// strings are prefixed with their length and SpacedIds are a space byte followed by an id uvarint.
+type BinaryObjectEncoder struct {}
+
+func (e BinaryObjectEncoder) Encode(msg *ObjectStateMsg) ([]byte, error) {
+	b := make([]byte, 0, 64 + 32 * len(msg.Os))
+	b = append(b, byte(msg.T))
+	b = appendUvarint(b, uint64(msg.S))
+	b = appendUvarint(b, uint64(len(msg.Os)))
+
+	var err error
+	for space, objects := range(msg.Os) {
+		b = append(b, byte(space))
+		b = appendUvarint(b, uint64(len(objects)))
+
+		for id, props := range(objects) {
+			b = appendUvarint(b, uint64(id))
+
+			mask := uint64(0)
+			for prop := range(props) {
+				if prop > maxEncodedProp {
+					return nil, fmt.Errorf("Cannot encode prop %d", prop)
+				}
+				mask |= 1 << prop
+			}
+			b = appendUvarint(b, mask)
+
+			for bitset := mask; bitset != 0; bitset &= bitset - 1 {
+				prop := Prop(bits.TrailingZeros64(bitset))
+				b, err = appendProp(b, prop, props[prop])
+				if err != nil {
+					return nil, fmt.Errorf("Failed to encode prop %d of %d/%d: %v", prop, space, id, err)
+				}
+			}
+		}
+	}
+	return b, nil
+}
+
+func (e BinaryObjectEncoder) Decode(b []byte) (ObjectStateMsg, error) {
+	r := &byteReader { b: b }
+	msg := ObjectStateMsg {
+		T: MessageType(r.byte()),
+		S: SeqNumType(r.uvarint()),
+		Os: make(ObjectPropMap),
+	}
+
+	numSpaces := r.uvarint()
+	for i := uint64(0); i < numSpaces && r.err == nil; i += 1 {
+		space := SpaceType(r.byte())
+		msg.Os[space] = make(map[IdType]PropMap)
+
+		numObjects := r.uvarint()
+		for j := uint64(0); j < numObjects && r.err == nil; j += 1 {
+			id := IdType(r.uvarint())
+			mask := r.uvarint()
+
+			props := make(PropMap)
+			for bitset := mask; bitset != 0; bitset &= bitset - 1 {
+				prop := Prop(bits.TrailingZeros64(bitset))
+				props[prop] = readProp(r, prop)
+			}
+			msg.Os[space][id] = props
+		}
+	}
+
+	if r.err != nil {
+		return ObjectStateMsg{}, r.err
+	}
+	return msg, nil
+}
+
+func appendProp(b []byte, prop Prop, value interface{}) ([]byte, error) {
+	var ok bool
+	switch prop {
+	case attributesProp:
+		var attributes map[AttributeType]bool
+		if attributes, ok = value.(map[AttributeType]bool); ok {
+			keys, values := uint64(0), uint64(0)
+			for attribute, set := range(attributes) {
+				keys |= 1 << attribute
+				if set {
+					values |= 1 << attribute
+				}
+			}
+			b = appendUvarint(appendUvarint(b, keys), values)
+		}
+	case byteAttributesProp:
+		var attributes map[ByteAttributeType]uint8
+		if attributes, ok = value.(map[ByteAttributeType]uint8); ok {
+			mask := byteAttributeMask(attributes)
+			b = appendUvarint(b, mask)
+			for ; mask != 0; mask &= mask - 1 {
+				b = append(b, attributes[ByteAttributeType(bits.TrailingZeros64(mask))])
+			}
+		}
+	case intAttributesProp:
+		var attributes map[IntAttributeType]int
+		if attributes, ok = value.(map[IntAttributeType]int); ok {
+			mask := intAttributeMask(attributes)
+			b = appendUvarint(b, mask)
+			for ; mask != 0; mask &= mask - 1 {
+				b = appendVarint(b, int64(attributes[IntAttributeType(bits.TrailingZeros64(mask))]))
+			}
+		}
+	case floatAttributesProp:
+		var attributes map[FloatAttributeType]float64
+		if attributes, ok = value.(map[FloatAttributeType]float64); ok {
+			mask := floatAttributeMask(attributes)
+			b = appendUvarint(b, mask)
+			for ; mask != 0; mask &= mask - 1 {
+				b = appendUint32(b, math.Float32bits(float32(attributes[FloatAttributeType(bits.TrailingZeros64(mask))])))
+			}
+		}
+	case dimProp, posProp, velProp, accProp, jerkProp, dirProp, endProp:
+		var vec Vec2
+		if vec, ok = value.(Vec2); ok {
+			b = appendVarint(b, int64(math.Round(vec.X * vec2Precision)))
+			b = appendVarint(b, int64(math.Round(vec.Y * vec2Precision)))
+		}
+	case keysProp:
+		var keys map[KeyType]bool
+		if keys, ok = value.(map[KeyType]bool); ok {
+			pressed, values := uint64(0), uint64(0)
+			for key, down := range(keys) {
+				pressed |= 1 << key
+				if down {
+					values |= 1 << key
+				}
+			}
+			b = appendUvarint(appendUvarint(b, pressed), values)
+		}
+	case nameProp:
+		var name string
+		if name, ok = value.(string); ok {
+			b = appendUvarint(b, uint64(len(name)))
+			b = append(b, name...)
+		}
+	case ownerProp, targetProp:
+		var sid SpacedId
+		if sid, ok = value.(SpacedId); ok {
+			b = append(b, byte(sid.S))
+			b = appendUvarint(b, uint64(sid.Id))
+		}
+	default:
+		return nil, errors.New("unsupported prop")
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T", value)
+	}
+	return b, nil
+}
+
+func readProp(r *byteReader, prop Prop) interface{} {
+	switch prop {
+	case attributesProp:
+		attributes := make(map[AttributeType]bool)
+		keys, values := r.uvarint(), r.uvarint()
+		for ; keys != 0; keys &= keys - 1 {
+			attribute := bits.TrailingZeros64(keys)
+			attributes[AttributeType(attribute)] = values & (1 << attribute) != 0
+		}
+		return attributes
+	case byteAttributesProp:
+		attributes := make(map[ByteAttributeType]uint8)
+		for mask := r.uvarint(); mask != 0; mask &= mask - 1 {
+			attributes[ByteAttributeType(bits.TrailingZeros64(mask))] = r.byte()
+		}
+		return attributes
+	case intAttributesProp:
+		attributes := make(map[IntAttributeType]int)
+		for mask := r.uvarint(); mask != 0; mask &= mask - 1 {
+			attributes[IntAttributeType(bits.TrailingZeros64(mask))] = int(r.varint())
+		}
+		return attributes
+	case floatAttributesProp:
+		attributes := make(map[FloatAttributeType]float64)
+		for mask := r.uvarint(); mask != 0; mask &= mask - 1 {
+			attributes[FloatAttributeType(bits.TrailingZeros64(mask))] = float64(math.Float32frombits(r.uint32()))
+		}
+		return attributes
+	case dimProp, posProp, velProp, accProp, jerkProp, dirProp, endProp:
+		x := float64(r.varint()) / vec2Precision
+		y := float64(r.varint()) / vec2Precision
+		return NewVec2(x, y)
+	case keysProp:
+		keys := make(map[KeyType]bool)
+		pressed, values := r.uvarint(), r.uvarint()
+		for ; pressed != 0; pressed &= pressed - 1 {
+			key := bits.TrailingZeros64(pressed)
+			keys[KeyType(key)] = values & (1 << key) != 0
+		}
+		return keys
+	case nameProp:
+		return r.string()
+	case ownerProp, targetProp:
+		space := SpaceType(r.byte())
+		return Id(space, IdType(r.uvarint()))
+	}
+
+	r.fail(fmt.Errorf("Cannot decode prop %d", prop))
+	return nil
+}
+
+func byteAttributeMask(attributes map[ByteAttributeType]uint8) uint64 {
+	mask := uint64(0)
+	for attribute := range(attributes) {
+		mask |= 1 << attribute
+	}
+	return mask
+}
+
+func intAttributeMask(attributes map[IntAttributeType]int) uint64 {
+	mask := uint64(0)
+	for attribute := range(attributes) {
+		mask |= 1 << attribute
+	}
+	return mask
+}
+
+func floatAttributeMask(attributes map[FloatAttributeType]float64) uint64 {
+	mask := uint64(0)
+	for attribute := range(attributes) {
+		mask |= 1 << attribute
+	}
+	return mask
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// Remembers the first error so decoding can check once at the end
+type byteReader struct {
+	b []byte
+	err error
+}
+
+func (r *byteReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.b = nil
+}
+
+func (r *byteReader) byte() byte {
+	if len(r.b) < 1 {
+		r.fail(errors.New("Unexpected end of object data"))
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *byteReader) string() string {
+	n := r.uvarint()
+	if uint64(len(r.b)) < n {
+		r.fail(errors.New("Unexpected end of object data"))
+		return ""
+	}
+	v := string(r.b[:n])
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *byteReader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.fail(errors.New("Unexpected end of object data"))
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *byteReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail(errors.New("Malformed uvarint in object data"))
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *byteReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail(errors.New("Malformed varint in object data"))
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// A full level with a few players running around and shooting
+func newTestObjectState(tb testing.TB) ObjectStateMsg {
+	h := newTestHarness(tb, birdTownLevel)
+	for i := 0; i < 8; i += 1 {
+		h.addPlayer(IdType(i), uint8(1 + i % 2))
+	}
+	h.step(1)
+	for i := 0; i < 30; i += 1 {
+		for id := 0; id < 8; id += 1 {
+			h.keys(IdType(id), NewVec2(float64(i), 5), rightKey, jumpKey, mouseClick)
+		}
+		h.step(1)
+	}
+	return h.game.createObjectDataMsg()
+}
+
+func TestBinaryObjectEncoderValues(t *testing.T) {
+	msg := ObjectStateMsg {
+		T: objectUpdateType,
+		S: 300,
+		Os: ObjectPropMap {
+			playerSpace: {
+				3: PropMap {
+					attributesProp: map[AttributeType]bool {deadAttribute: true, vipAttribute: false},
+					byteAttributesProp: map[ByteAttributeType]uint8 {teamByteAttribute: 2, healthByteAttribute: 75},
+					intAttributesProp: map[IntAttributeType]int {killIntAttribute: 4, colorIntAttribute: 0xff00ff},
+					floatAttributesProp: map[FloatAttributeType]float64 {posZFloatAttribute: -0.5},
+					posProp: NewVec2(12.3456, -7.25),
+					keysProp: map[KeyType]bool {leftKey: true, jumpKey: false},
+					nameProp: "b #3",
+				},
+			},
+			rocketSpace: {
+				200: PropMap {
+					ownerProp: Id(playerSpace, 3),
+					velProp: NewVec2(-30, 0.0004),
+				},
+			},
+		},
+	}
+
+	encoder := BinaryObjectEncoder{}
+	b, err := encoder.Encode(&msg)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	decoded, err := encoder.Decode(b)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+
+	if decoded.T != msg.T || decoded.S != msg.S {
+		t.Errorf("expected header %d/%d, got %d/%d", msg.T, msg.S, decoded.T, decoded.S)
+	}
+
+	player := decoded.Os[playerSpace][3]
+	for _, prop := range([]Prop{attributesProp, byteAttributesProp, intAttributesProp, floatAttributesProp, keysProp, nameProp}) {
+		if !reflect.DeepEqual(player[prop], msg.Os[playerSpace][3][prop]) {
+			t.Errorf("prop %d: expected %v, got %v", prop, msg.Os[playerSpace][3][prop], player[prop])
+		}
+	}
+	if pos := player[posProp].(Vec2); math.Abs(pos.X - 12.346) > 1e-9 || pos.Y != -7.25 {
+		t.Errorf("expected quantized pos (12.346, -7.25), got %v", pos)
+	}
+
+	rocket := decoded.Os[rocketSpace][200]
+	if owner := rocket[ownerProp].(SpacedId); owner != Id(playerSpace, 3) {
+		t.Errorf("expected owner %v, got %v", Id(playerSpace, 3), owner)
+	}
+	if vel := rocket[velProp].(Vec2); vel.X != -30 || vel.Y != 0 {
+		t.Errorf("expected quantized vel (-30, 0), got %v", vel)
+	}
+}
+
+func TestBinaryObjectEncoderGame(t *testing.T) {
+	msg := newTestObjectState(t)
+	encoder := BinaryObjectEncoder{}
+
+	b, err := encoder.Encode(&msg)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	decoded, err := encoder.Decode(b)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+
+	// Quantization happens once, so encoding again should be lossless
+	again, err := encoder.Encode(&decoded)
+	if err != nil {
+		t.Fatalf("failed to encode decoded message: %v", err)
+	}
+	redecoded, err := encoder.Decode(again)
+	if err != nil {
+		t.Fatalf("failed to decode again: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, redecoded) {
+		t.Errorf("decoding is not stable")
+	}
+
+	for space, objects := range(msg.Os) {
+		for id, props := range(objects) {
+			if len(decoded.Os[space][id]) != len(props) {
+				t.Errorf("%d/%d: expected %d props, got %d", space, id, len(props), len(decoded.Os[space][id]))
+			}
+		}
+	}
+}
+
+func TestBinaryObjectEncoderErrors(t *testing.T) {
+	encoder := BinaryObjectEncoder{}
+
+	bad := ObjectStateMsg {
+		T: objectDataType,
+		Os: ObjectPropMap {
+			playerSpace: {0: PropMap {posProp: "not a vec"}},
+		},
+	}
+	if _, err := encoder.Encode(&bad); err == nil {
+		t.Errorf("expected error for wrong prop type")
+	}
+
+	msg := newTestObjectState(t)
+	b, _ := encoder.Encode(&msg)
+	if _, err := encoder.Decode(b[:len(b) / 2]); err == nil {
+		t.Errorf("expected error for truncated message")
+	}
+}
+
+func benchmarkObjectEncoder(b *testing.B, encoder ObjectStateEncoder) {
+	msg := newTestObjectState(b)
+	encoded, err := encoder.Encode(&msg)
+	if err != nil {
+		b.Fatalf("failed to encode: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		encoder.Encode(&msg)
+	}
+	b.ReportMetric(float64(len(encoded)), "bytes/msg")
+}
+
+func BenchmarkMsgpackObjectEncoder(b *testing.B) {
+	benchmarkObjectEncoder(b, MsgpackObjectEncoder{})
+}
+
+func BenchmarkBinaryObjectEncoder(b *testing.B) {
+	benchmarkObjectEncoder(b, BinaryObjectEncoder{})
+}
+
+func BenchmarkBinaryObjectDecoder(b *testing.B) {
+	msg := newTestObjectState(b)
+	encoder := BinaryObjectEncoder{}
+	encoded, _ := encoder.Encode(&msg)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		encoder.Decode(encoded)
+	}
+}
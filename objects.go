@@ -39,11 +39,30 @@ func (b *Bomb) Update(grid *Grid, now time.Time) {
 
 type Pickup struct {
 	BaseObject
+	cooldownTimer Timer
 }
 
 func NewPickup(init Init) *Pickup {
 	pickup := &Pickup {
 		BaseObject: NewRec2Object(init),
+		cooldownTimer: NewTimer(15 * time.Second),
+	}
+	return pickup
+}
+
+// Single use pickup that refills armor, available again after a cooldown
+func NewArmorPickup(init Init) *Pickup {
+	pickup := NewPickup(init)
+	pickup.SetByteAttribute(typeByteAttribute, uint8(armorEquip))
+	return pickup
+}
+
+// Pickup for a weapon with the subtype it's paired with in its definition
+func NewWeaponPickup(init Init, equipType EquipType) *Pickup {
+	pickup := NewPickup(init)
+	pickup.SetByteAttribute(typeByteAttribute, uint8(equipType))
+	if def, ok := GetWeaponDefinition(equipType); ok {
+		pickup.SetByteAttribute(subtypeByteAttribute, uint8(def.subtype))
 	}
 	return pickup
 }
@@ -58,6 +77,27 @@ func (p Pickup) GetSubtype() EquipType {
 	return EquipType(typeByte)
 }
 
+func (p Pickup) Available() bool {
+	return !p.HasAttribute(takenAttribute)
+}
+
+func (p *Pickup) GetTimers() []*Timer {
+	return []*Timer {&p.cooldownTimer}
+}
+
+func (p *Pickup) Take() {
+	p.AddAttribute(takenAttribute)
+	p.cooldownTimer.Start()
+}
+
+func (p *Pickup) Update(grid *Grid, now time.Time) {
+	p.BaseObject.Update(grid, now)
+
+	if !isWasm && p.HasAttribute(takenAttribute) && !p.cooldownTimer.On() {
+		p.RemoveAttribute(takenAttribute)
+	}
+}
+
 type Portal struct {
 	BaseObject
 }
@@ -96,6 +136,10 @@ func (g *Goal) SetTeam(team uint8) {
 	g.SetIntAttribute(colorIntAttribute, vipColor)
 }
 
+func (g *Goal) GetTimers() []*Timer {
+	return []*Timer {&g.chargeTimer}
+}
+
 func (g *Goal) Update(grid *Grid, now time.Time) {
 	if isWasm {
 		return
@@ -106,6 +150,7 @@ func (g *Goal) Update(grid *Grid, now time.Time) {
 
 	colliders := grid.GetColliders(g)
 	hasPlayer := false
+	vip := InvalidId()
 	team, _ := g.GetByteAttribute(teamByteAttribute)
 	for len(colliders) > 0 {
 		collider := PopObject(&colliders)
@@ -114,6 +159,7 @@ func (g *Goal) Update(grid *Grid, now time.Time) {
 			if object.HasAttribute(vipAttribute) && object.grounded {
 				if playerTeam, ok := object.GetByteAttribute(teamByteAttribute); ok && playerTeam == team {
 					hasPlayer = true
+					vip = object.GetSpacedId()
 				}
 			}
 		}
@@ -123,6 +169,7 @@ func (g *Goal) Update(grid *Grid, now time.Time) {
 		if hasPlayer {
 			g.AddAttribute(chargingAttribute)
 			g.chargeTimer.Start()
+			grid.AddCombatEvent(NewVipEvent(vipEscortedCombatEvent, vip, team))
 		} else {
 			g.RemoveAttribute(chargingAttribute)
 			g.RemoveAttribute(chargedAttribute)
@@ -130,8 +177,9 @@ func (g *Goal) Update(grid *Grid, now time.Time) {
 		}
 	}
 
-	if g.HasAttribute(chargingAttribute) && g.chargeTimer.Finished() {
+	if g.HasAttribute(chargingAttribute) && g.chargeTimer.Finished() && !g.HasAttribute(chargedAttribute) {
 		g.AddAttribute(chargedAttribute)
+		grid.AddCombatEvent(NewVipEvent(goalChargedCombatEvent, vip, team))
 	}
 
 	if g.HasAttribute(chargedAttribute) {
@@ -139,6 +187,36 @@ func (g *Goal) Update(grid *Grid, now time.Time) {
 	}
 }
 
+// Short lived visual for a hitscan shot. Position is the start of the shot.
+type Tracer struct {
+	BaseObject
+}
+
+func NewTracer(init Init) *Tracer {
+	tracer := &Tracer {
+		BaseObject: NewRec2Object(init),
+	}
+	tracer.SetVariableTTL(120 * time.Millisecond)
+	return tracer
+}
+
+func (t *Tracer) SetEnd(end Vec2) {
+	t.SetInitProp(endProp, end)
+}
+
+func (t *Tracer) Update(grid *Grid, now time.Time) {
+	t.PrepareUpdate(now)
+	t.BaseObject.Update(grid, now)
+
+	if isWasm {
+		return
+	}
+
+	if t.Expired() {
+		grid.Delete(t.GetSpacedId())
+	}
+}
+
 type Spawn struct {
 	BaseObject
 }
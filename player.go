@@ -35,8 +35,27 @@ const (
 
 	bodySubProfile ProfileKey = 1
 	bodySubProfileOffsetY = 0.22
+	bodySubProfileHalfHeight = 0.53
+
+	// Top part of the body sub-profile that counts as the head
+	headRegionHeight = 0.4
+
+	maxArmor = 100
+	// Share of bullet and explosive damage taken by armor
+	armorShare = 0.6
+
+	fallDamageVel = -20.0
+	fallDamageMultiplier = 5.0
+	voidHeight = -7.0
 )
 
+var hitRegionMultipliers = map[HitRegion]float64 {
+	unknownRegion: 1.0,
+	bodyRegion: 1.0,
+	headRegion: 1.5,
+	legsRegion: 0.75,
+}
+
 type Player struct {
 	BaseObject
 	weapon *Weapon
@@ -53,10 +72,10 @@ type Player struct {
 func NewPlayer(init Init) *Player {
 	profile := NewRec2(init)
 	points := make([]Vec2, 4)
-	points[0] = NewVec2(0.48, -0.53)
-	points[1] = NewVec2(0.48, 0.53)
-	points[2] = NewVec2(-0.48, 0.53)
-	points[3] = NewVec2(-0.48, -0.53)
+	points[0] = NewVec2(0.48, -bodySubProfileHalfHeight)
+	points[1] = NewVec2(0.48, bodySubProfileHalfHeight)
+	points[2] = NewVec2(-0.48, bodySubProfileHalfHeight)
+	points[3] = NewVec2(-0.48, -bodySubProfileHalfHeight)
 
 	rotPoly := NewRotPoly(init, points)
 	subProfile := NewSubProfile(rotPoly)
@@ -100,15 +119,67 @@ func (p *Player) SetData(data Data) {
 	}
 
 	p.BaseObject.SetData(data)
-	if data.Has(keysProp) {
-		p.SetKeys(data.Get(keysProp).(map[KeyType]bool))
+	if keys, ok := data.Get(keysProp).(map[KeyType]bool); ok {
+		p.SetKeys(keys)
 	}
 }
 
+// Saved with snapshots, order has to stay the same
+func (p *Player) GetTimers() []*Timer {
+	return []*Timer {&p.jumpTimer, &p.jumpGraceTimer, &p.knockbackTimer, &p.respawnTimer}
+}
+
 func (p Player) Dead() bool {
 	return p.Health.Dead()
 }
 
+// Applies the hit region multiplier and armor before passing the damage to Health
+func (p *Player) TakeDamage(damage Damage) {
+	if isWasm || p.Dead() {
+		return
+	}
+
+	amount := float64(damage.GetAmount()) * hitRegionMultipliers[damage.GetRegion()]
+
+	// Armor stops attacks, not the environment
+	damageType := damage.GetType()
+	if armor, ok := p.GetByteAttribute(armorByteAttribute); ok && armor > 0 && (damageType == bulletDamage || damageType == explosiveDamage || damageType == meleeDamage) {
+		absorbed := Min(float64(armor), amount * armorShare)
+		amount -= absorbed
+		p.SetByteAttribute(armorByteAttribute, armor - uint8(absorbed))
+	}
+
+	damage.SetAmount(int(amount + 0.5))
+	p.Health.TakeDamage(damage)
+}
+
+// Region of the player closest to the point, based on the body sub-profile
+func (p Player) GetHitRegion(point Vec2) HitRegion {
+	body := p.GetSubProfile(bodySubProfile)
+	top := body.Pos().Y + bodySubProfileHalfHeight
+	bottom := body.Pos().Y - bodySubProfileHalfHeight
+
+	if point.Y >= top - headRegionHeight {
+		return headRegion
+	}
+	if point.Y >= bottom {
+		return bodyRegion
+	}
+	return legsRegion
+}
+
+func (p *Player) AddArmor() {
+	p.SetByteAttribute(armorByteAttribute, maxArmor)
+}
+
+// Whether an attack travelling along dir is stopped by the player's shield
+func (p Player) Blocks(dir Vec2) bool {
+	if !p.HasAttribute(shieldedAttribute) {
+		return false
+	}
+	return dir.Dot(p.Dir()) < 0
+}
+
 func (p *Player) UpdateScore(g *Grid) {
 	if deaths, ok := p.GetIntAttribute(deathIntAttribute); ok {
 		p.SetIntAttribute(deathIntAttribute, deaths + 1)
@@ -117,6 +188,13 @@ func (p *Player) UpdateScore(g *Grid) {
 	}
 
 	sid := p.Health.GetLastDamageId(lastDamageTime)
+	event := NewKillEvent(sid, p.GetSpacedId())
+
+	ticks := p.Health.GetLastTicks(lastDamageTime)
+	if len(ticks) > 0 {
+		event.Damage = ticks[len(ticks) - 1].damageType
+	}
+
 	object := g.Get(sid)
 	if object != nil {
 		if kills, ok := object.GetIntAttribute(killIntAttribute); ok {
@@ -124,7 +202,33 @@ func (p *Player) UpdateScore(g *Grid) {
 		} else {
 			object.SetIntAttribute(killIntAttribute, 1)
 		}
+
+		if killer, ok := object.(*Player); ok && killer.weapon != nil {
+			event.Weapon = killer.weapon.GetType()
+		}
 	}
+
+	// Everyone else who did damage recently gets an assist
+	assisted := make(map[SpacedId]bool)
+	for _, tick := range(ticks) {
+		if tick.sid.Invalid() || tick.sid == sid || tick.sid == p.GetSpacedId() || assisted[tick.sid] {
+			continue
+		}
+		assisted[tick.sid] = true
+
+		assister := g.Get(tick.sid)
+		if assister == nil {
+			continue
+		}
+		if assists, ok := assister.GetIntAttribute(assistIntAttribute); ok {
+			assister.SetIntAttribute(assistIntAttribute, assists + 1)
+		} else {
+			assister.SetIntAttribute(assistIntAttribute, 1)
+		}
+		event.Assists = append(event.Assists, tick.sid)
+	}
+
+	g.AddCombatEvent(event)
 }
 
 func (p *Player) SetTeam(team uint8) {
@@ -132,22 +236,40 @@ func (p *Player) SetTeam(team uint8) {
 	p.SetIntAttribute(colorIntAttribute, teamColors[team])
 }
 
+// Uses the team's spawn, or the neutral one if the level doesn't have one for the team (e.g. the lobby)
 func (p *Player) SetSpawn(g *Grid) {
 	team, _ := p.GetByteAttribute(teamByteAttribute)
 
+	var neutral Object
 	for _, spawn := range(g.GetObjects(spawnSpace)) {
-		if spawnTeam, ok := spawn.GetByteAttribute(teamByteAttribute); ok && team == spawnTeam {
-			pos := spawn.Pos()
-			pos.X += float64(int(p.GetId()) % int(spawn.Dim().X)) - spawn.Dim().X/2
-			p.SetInitPos(pos)
-			break
+		spawnTeam, ok := spawn.GetByteAttribute(teamByteAttribute)
+		if !ok {
+			continue
+		}
+		if team == spawnTeam {
+			p.setSpawnAt(spawn)
+			return
 		}
+		if spawnTeam == 0 {
+			neutral = spawn
+		}
+	}
+
+	if neutral != nil {
+		p.setSpawnAt(neutral)
 	}
 }
 
+func (p *Player) setSpawnAt(spawn Object) {
+	pos := spawn.Pos()
+	pos.X += float64(int(p.GetId()) % int(spawn.Dim().X)) - spawn.Dim().X/2
+	p.SetInitPos(pos)
+}
+
 func (p *Player) Respawn() {
 	p.Health.Respawn()
 	p.SetHealth(100)
+	p.SetByteAttribute(armorByteAttribute, 0)
 	p.RemoveAttribute(deadAttribute)
 	p.Keys.SetEnabled(true)
 
@@ -166,7 +288,9 @@ func (p *Player) Update(grid *Grid, now time.Time) {
 	}
 
 	// Handle health stuff
-	if p.Pos().Y < -7 {
+	if p.Pos().Y < voidHeight {
+		// TakeDamage records who gets credit on the server, the client still has to predict the death
+		p.TakeDamage(NewDamage(InvalidId(), voidDamage, p.GetHealth(), p.Pos()))
 		p.Die()
 	}
 
@@ -275,7 +399,15 @@ func (p *Player) Update(grid *Grid, now time.Time) {
 	pos.Add(p.Vel(), ts)
 	pos.Add(p.ExtVel(), ts)
 	p.SetPos(pos)
+
+	wasGrounded := p.grounded
+	fallVel := p.Vel().Y
 	p.checkCollisions(grid)
+	if !wasGrounded && p.grounded && fallVel < fallDamageVel {
+		damage := NewDamage(InvalidId(), fallDamage, int((fallDamageVel - fallVel) * fallDamageMultiplier), pos)
+		damage.SetRegion(legsRegion)
+		p.TakeDamage(damage)
+	}
 	grid.Upsert(p)
 }
 
@@ -304,7 +436,12 @@ func (p *Player) checkCollisions(grid *Grid) {
 		collider := PopObject(&colliders)
 		switch object := collider.(type) {
 		case *Pickup:
-			if !isWasm && p.KeyDown(interactKey) {
+			if !isWasm && object.GetType() == armorEquip {
+				if object.Available() && p.KeyDown(interactKey) {
+					object.Take()
+					p.AddArmor()
+				}
+			} else if !isWasm && p.KeyDown(interactKey) {
 				if p.weapon == nil || p.weapon.GetType() != object.GetType() {
 					if p.weapon != nil {
 						grid.Delete(p.weapon.GetSpacedId())
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+)
+
+func newTestRec2(pos Vec2, dim Vec2) *Rec2 {
+	return NewRec2(NewInit(Id(wallSpace, 0), pos, dim))
+}
+
+func newTestCircle(pos Vec2, diameter float64) *Circle {
+	return NewCircle(NewInit(Id(starSpace, 0), pos, NewVec2(diameter, diameter)))
+}
+
+// Unit square centered at pos, rotated to face dir
+func newTestRotPoly(pos Vec2, dir Vec2) *RotPoly {
+	init := NewInit(Id(playerSpace, 0), pos, NewVec2(1, 1))
+	init.SetInitDir(dir)
+	return NewRotPoly(init, []Vec2 {
+		NewVec2(-0.5, -0.5),
+		NewVec2(0.5, -0.5),
+		NewVec2(0.5, 0.5),
+		NewVec2(-0.5, 0.5),
+	})
+}
+
+func TestRec2Overlap(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		other Profile
+		hit bool
+	}{
+		{"rec2 overlapping", newTestRec2(NewVec2(0.5, 0.5), NewVec2(1, 1)), true},
+		{"rec2 contained", newTestRec2(NewVec2(0, 0), NewVec2(0.2, 0.2)), true},
+		{"rec2 apart", newTestRec2(NewVec2(2, 0), NewVec2(1, 1)), false},
+		{"rec2 apart diagonal", newTestRec2(NewVec2(1.1, 1.1), NewVec2(1, 1)), false},
+		{"circle overlapping side", newTestCircle(NewVec2(0.9, 0), 1), true},
+		{"circle apart", newTestCircle(NewVec2(2, 0), 1), false},
+		// Inside the bounding box but past the rounded corner
+		{"circle near corner", newTestCircle(NewVec2(0.9, 0.9), 1), false},
+		{"circle on corner", newTestCircle(NewVec2(0.8, 0.8), 1), true},
+		{"rotpoly overlapping", newTestRotPoly(NewVec2(0.8, 0), NewVec2(1, 0)), true},
+		{"rotpoly apart", newTestRotPoly(NewVec2(3, 0), NewVec2(1, 0)), false},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := newTestRec2(NewVec2(0, 0), NewVec2(1, 1))
+			if hit := rec.OverlapProfile(tc.other).GetHit(); hit != tc.hit {
+				t.Errorf("expected hit %t, got %t", tc.hit, hit)
+			}
+		})
+	}
+}
+
+func TestRec2OverlapAdjustment(t *testing.T) {
+	rec := newTestRec2(NewVec2(0, 0), NewVec2(1, 1))
+	other := newTestRec2(NewVec2(0.8, 0), NewVec2(1, 1))
+
+	result := rec.OverlapProfile(other)
+	if !result.GetHit() {
+		t.Fatalf("expected hit")
+	}
+	if adj := result.GetPosAdjustment(); adj.Area() <= 0 {
+		t.Errorf("expected a position adjustment, got %v", adj)
+	}
+}
+
+func TestCircleOverlap(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		other Profile
+		hit bool
+	}{
+		{"circle overlapping", newTestCircle(NewVec2(0.9, 0), 1), true},
+		{"circle touching", newTestCircle(NewVec2(1, 0), 1), true},
+		{"circle apart", newTestCircle(NewVec2(1.1, 0), 1), false},
+		{"circle apart diagonal", newTestCircle(NewVec2(0.8, 0.8), 1), false},
+		{"rec2 overlapping", newTestRec2(NewVec2(0.9, 0), NewVec2(1, 1)), true},
+		{"rec2 apart", newTestRec2(NewVec2(1.1, 0), NewVec2(1, 1)), false},
+		{"rotpoly overlapping", newTestRotPoly(NewVec2(0.8, 0), NewVec2(1, 1)), true},
+		{"rotpoly apart", newTestRotPoly(NewVec2(2, 0), NewVec2(1, 1)), false},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			circle := newTestCircle(NewVec2(0, 0), 1)
+			if hit := circle.OverlapProfile(tc.other).GetHit(); hit != tc.hit {
+				t.Errorf("expected hit %t, got %t", tc.hit, hit)
+			}
+		})
+	}
+}
+
+func TestRotPolyOverlap(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		dir Vec2
+		other Profile
+		hit bool
+	}{
+		{"rec2 overlapping", NewVec2(1, 0), newTestRec2(NewVec2(0.9, 0), NewVec2(1, 1)), true},
+		{"rec2 apart", NewVec2(1, 0), newTestRec2(NewVec2(1.1, 0), NewVec2(1, 1)), false},
+		// Rotating by 45 degrees pushes a corner out to ~0.71
+		{"rec2 rotated into", NewVec2(1, 1), newTestRec2(NewVec2(1.1, 0), NewVec2(1, 1)), true},
+		{"rec2 contained", NewVec2(1, 0), newTestRec2(NewVec2(0, 0), NewVec2(0.2, 0.2)), true},
+		{"circle overlapping", NewVec2(1, 0), newTestCircle(NewVec2(0.9, 0), 1), true},
+		{"circle apart", NewVec2(1, 0), newTestCircle(NewVec2(1.1, 0), 1), false},
+		{"rotpoly overlapping", NewVec2(1, 0), newTestRotPoly(NewVec2(0.9, 0), NewVec2(1, 0)), true},
+		{"rotpoly apart", NewVec2(1, 0), newTestRotPoly(NewVec2(1.1, 0), NewVec2(1, 0)), false},
+		{"rotpoly both rotated", NewVec2(1, 1), newTestRotPoly(NewVec2(1.3, 0), NewVec2(-1, 1)), true},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			poly := newTestRotPoly(NewVec2(0, 0), tc.dir)
+			if hit := poly.OverlapProfile(tc.other).GetHit(); hit != tc.hit {
+				t.Errorf("expected hit %t, got %t", tc.hit, hit)
+			}
+		})
+	}
+}
@@ -22,6 +22,9 @@ type IntersectResults struct {
 	ignored bool
 	t float64
 	tmax float64
+
+	// Surface normal at t
+	normal Vec2
 }
 
 func NewIntersectResults() IntersectResults {
@@ -30,10 +33,14 @@ func NewIntersectResults() IntersectResults {
 		ignored: false,
 		t: 1.0,
 		tmax: 0,
+		normal: NewVec2(0, 0),
 	}
 }
 
 func (ir *IntersectResults) Merge(other IntersectResults) {
+	if other.hit && (!ir.hit || other.t < ir.t) {
+		ir.normal = other.normal
+	}
 	ir.hit = ir.hit || other.hit
 
 	if other.hit {
@@ -8,6 +8,7 @@ type ExplosionOptions struct {
 	explode bool
 	size Vec2
 	color int
+	damage int
 }
 
 type Projectile struct {
@@ -44,6 +45,19 @@ func NewProjectile(object BaseObject) Projectile {
 	return p
 }
 
+func (p *Projectile) ApplyDefinition(def ProjectileDefinition) {
+	p.SetDamage(def.damage)
+	p.SetMaxSpeed(def.maxSpeed)
+	p.SetSticky(def.sticky)
+	p.SetExplosionOptions(def.explosionOptions)
+	if def.ttl > 0 {
+		p.SetVariableTTL(def.ttl)
+	}
+	if def.color != 0 {
+		p.SetIntAttribute(colorIntAttribute, def.color)
+	}
+}
+
 func (p *Projectile) SetDamage(damage int) {
 	p.damage = damage
 }
@@ -140,6 +154,11 @@ func (p *Projectile) SelfDestruct(grid *Grid) {
 		init := NewInit(grid.NextSpacedId(explosionSpace), p.Pos(), p.explosionOptions.size)	
 		explosion := NewExplosion(init)
 		explosion.SetIntAttribute(colorIntAttribute, p.explosionOptions.color)
+		explosion.SetOwner(p.GetOwner())
+		explosion.SetDamage(p.explosionOptions.damage)
+		if team, ok := p.GetByteAttribute(teamByteAttribute); ok {
+			explosion.SetByteAttribute(teamByteAttribute, team)
+		}
 		grid.Upsert(explosion)
 	}
 	grid.Delete(p.GetSpacedId())	
@@ -150,7 +169,15 @@ func (p *Projectile) Hit(grid *Grid, collider Object) {
 
 	switch object := collider.(type) {
 	case *Player:
-		object.TakeDamage(p.GetOwner(), p.GetDamage())
+		// Projectile has already stopped, so use which side of the player it's on
+		dir := object.Pos()
+		dir.Sub(p.Pos(), 1.0)
+		if object.Blocks(dir) {
+			return
+		}
+		damage := NewDamage(p.GetOwner(), bulletDamage, p.GetDamage(), p.Pos())
+		damage.SetRegion(object.GetHitRegion(p.Pos()))
+		object.TakeDamage(damage)
 	}
 }
 
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProjectileShield(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		shielded bool
+		dir Vec2
+		blocked bool
+	}{
+		{"facing shooter", true, NewVec2(-1, 0), true},
+		{"facing away", true, NewVec2(1, 0), false},
+		{"no shield", false, NewVec2(-1, 0), false},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHarness(t, lobbyLevel)
+			shooter := h.addPlayer(0, 1)
+			target := h.addPlayer(1, 2)
+			target.SetDir(tc.dir)
+			if tc.shielded {
+				target.AddAttribute(shieldedAttribute)
+			}
+			health := target.GetHealth()
+
+			// Fired from the left, so the pellet stops on the left side of the target
+			pos := target.Pos()
+			pos.X -= 0.5
+			pellet := h.grid().New(NewInit(h.grid().NextSpacedId(pelletSpace), pos, NewVec2(0.2, 0.2))).(*Pellet)
+			pellet.SetOwner(shooter.GetSpacedId())
+			pellet.SetVel(NewVec2(20, 0))
+			h.grid().Upsert(pellet)
+
+			pellet.Collide(target, h.grid())
+			pellet.SelfDestruct(h.grid())
+
+			if blocked := target.GetHealth() == health; blocked != tc.blocked {
+				t.Errorf("expected blocked = %t, health went from %d to %d", tc.blocked, health, target.GetHealth())
+			}
+		})
+	}
+}
@@ -5,13 +5,6 @@ import (
 	"time"
 )
 
-const (
-	tinyRange time.Duration = 500 * time.Millisecond
-	shortRange time.Duration = 700 * time.Millisecond
-	mediumRange time.Duration = 900 * time.Millisecond
-	longRange time.Duration = 1200 * time.Millisecond
-)
-
 type Pellet struct {
 	Projectile
 }
@@ -20,8 +13,7 @@ func NewPellet(init Init) *Pellet {
 	pellet := &Pellet {
 		Projectile: NewProjectile(NewCircleObject(init)),
 	}
-	pellet.SetVariableTTL(tinyRange)
-	pellet.SetDamage(10)
+	pellet.ApplyDefinition(GetProjectileDefinition(pelletSpace))
 	return pellet
 }
 
@@ -41,9 +33,7 @@ func NewBolt(init Init) *Bolt {
 	bolt := &Bolt {
 		Projectile: NewProjectile(NewBaseObject(init, profile)),
 	}
-	bolt.SetVariableTTL(shortRange)
-	bolt.SetDamage(10)
-	bolt.SetIntAttribute(colorIntAttribute, boltColor)
+	bolt.ApplyDefinition(GetProjectileDefinition(boltSpace))
 	return bolt
 }
 
@@ -51,14 +41,12 @@ func (b *Bolt) AddAttribute(attribute AttributeType) {
 	b.Projectile.AddAttribute(attribute)
 
 	if attribute == chargedAttribute {
-		b.SetIntAttribute(colorIntAttribute, chargedBoltColor)
-		b.SetVariableTTL(longRange)
-		b.SetDamage(80)
-		b.SetExplosionOptions(ExplosionOptions {
-			explode: true,
-			size: NewVec2(5, 5),
-			color: chargedBoltColor,
-		})
+		if charged := GetProjectileDefinition(boltSpace).charged; charged != nil {
+			b.SetIntAttribute(colorIntAttribute, charged.color)
+			b.SetVariableTTL(charged.ttl)
+			b.SetDamage(charged.damage)
+			b.SetExplosionOptions(charged.explosionOptions)
+		}
 	}
 }
 
@@ -70,14 +58,7 @@ func NewRocket(init Init) *Rocket {
 	rocket := &Rocket {
 		Projectile: NewProjectile(NewCircleObject(init)),
 	}
-	rocket.SetMaxSpeed(80)
-	rocket.SetVariableTTL(mediumRange)
-	rocket.SetExplosionOptions(ExplosionOptions {
-		explode: true,
-		size: NewVec2(4, 4),
-		color: rocketExplosionColor,
-	})
-	rocket.SetDamage(50)
+	rocket.ApplyDefinition(GetProjectileDefinition(rocketSpace))
 	return rocket
 }
 
@@ -95,15 +76,14 @@ func NewStar(init Init) *Star {
 	r := rand.New(rand.NewSource(UnixMilli()))
 	color := starColors[r.Intn(len(starColors))]
 
-	star.SetVariableTTL(shortRange)
-	star.SetExplosionOptions(ExplosionOptions {
-		explode: true,
-		size: NewVec2(1, 1),
-		color: color,
-	})
-	star.SetDamage(25)
-	star.SetSticky(true)
-	star.SetIntAttribute(colorIntAttribute, color)
+	def := GetProjectileDefinition(starSpace)
+	if def.explosionOptions.explode && def.explosionOptions.color == 0 {
+		def.explosionOptions.color = color
+	}
+	if def.color == 0 {
+		def.color = color
+	}
+	star.ApplyDefinition(def)
 	star.SetIntAttribute(secondaryColorIntAttribute, starSecondary)
 	return star
 }
@@ -121,9 +101,7 @@ func NewGrapplingHook(init Init) *GrapplingHook {
 		attractFactor: 4,
 	}
 
-	hook.SetVariableTTL(shortRange)
-	hook.SetDamage(0)
-	hook.SetSticky(true)
+	hook.ApplyDefinition(GetProjectileDefinition(grapplingHookSpace))
 	return hook
 }
 
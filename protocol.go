@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"strconv"
+)
+
+// Reads the protocol version from the connection params, e.g. version=2&game=0.1
+func checkProtocol(vars map[string]string) (int, error) {
+	if game, ok := vars["game"]; ok && game != gameVersion {
+		// Game logic can differ slightly as long as the messages are compatible
+		logger.Warn("%s is running game version %s, server is on %s", vars["name"], game, gameVersion)
+	}
+
+	stringVersion, ok := vars["version"]
+	if !ok {
+		return legacyProtocolVersion, nil
+	}
+
+	version, err := strconv.Atoi(stringVersion)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid protocol version: %s", stringVersion)
+	}
+	if version < minProtocolVersion {
+		return version, fmt.Errorf("Your game is outdated. Please refresh to download the latest stuff")
+	}
+	if version > protocolVersion {
+		return version, fmt.Errorf("Server is outdated, please try again in a few minutes")
+	}
+	return version, nil
+}
+
+// Tells the client which protocol the server will use, older clients don't know about versionType
+func acceptProtocol(ws *websocket.Conn, version int) error {
+	if version <= legacyProtocolVersion {
+		return nil
+	}
+	return ws.WriteMessage(websocket.BinaryMessage, Pack(newVersionMsg(true, "")))
+}
+
+func rejectProtocol(ws *websocket.Conn, version int, err error) {
+	logger.Warn("Rejected client with protocol version %d: %v", version, err)
+
+	if version > legacyProtocolVersion {
+		ws.WriteMessage(websocket.BinaryMessage, Pack(newVersionMsg(false, err.Error())))
+	}
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+	ws.Close()
+}
+
+// Sends the client to the node hosting the room, older clients can only be told to try again
+func redirectRoom(ws *websocket.Conn, version int, room string, host string) {
+	logger.With("room", room).Debug("redirecting client to %s", host)
+
+	if version < redirectProtocolVersion {
+		rejectProtocol(ws, version, fmt.Errorf("Room %s is hosted on another server, please refresh to update your game", room))
+		return
+	}
+
+	msg := newVersionMsg(false, "Room is hosted on " + host)
+	msg.Redirect = host
+	ws.WriteMessage(websocket.BinaryMessage, Pack(msg))
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, msg.Reason))
+	ws.Close()
+}
+
+func newVersionMsg(accepted bool, reason string) *VersionMsg {
+	return &VersionMsg {
+		T: versionType,
+		Accepted: accepted,
+		Protocol: protocolVersion,
+		MinProtocol: minProtocolVersion,
+		Game: gameVersion,
+		Reason: reason,
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Deployed clients depend on these, so changing one should fail here first
+func TestMessageTypeIds(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		msgType MessageType
+		id uint8
+	}{
+		{"ping", pingType, 1},
+		{"offer", offerType, 3},
+		{"answer", answerType, 4},
+		{"init", initType, 8},
+		{"join", joinType, 9},
+		{"chat", chatType, 14},
+		{"key", keyType, 15},
+		{"objectData", objectDataType, 17},
+		{"stateChange", stateChangeType, 22},
+		{"version", versionType, 23},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			if uint8(tc.msgType) != tc.id {
+				t.Errorf("expected id %d, got %d", tc.id, tc.msgType)
+			}
+		})
+	}
+}
+
+func TestCheckProtocol(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		vars map[string]string
+		version int
+		ok bool
+	}{
+		{"legacy client", map[string]string{}, legacyProtocolVersion, true},
+		{"current", map[string]string{"version": strconv.Itoa(protocolVersion)}, protocolVersion, true},
+		{"minimum", map[string]string{"version": strconv.Itoa(minProtocolVersion)}, minProtocolVersion, true},
+		{"old game version", map[string]string{"version": strconv.Itoa(protocolVersion), "game": "0.0"}, protocolVersion, true},
+		{"outdated", map[string]string{"version": strconv.Itoa(minProtocolVersion - 1)}, minProtocolVersion - 1, false},
+		{"newer than server", map[string]string{"version": strconv.Itoa(protocolVersion + 1)}, protocolVersion + 1, false},
+		{"malformed", map[string]string{"version": "two"}, 0, false},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			version, err := checkProtocol(tc.vars)
+			if ok := err == nil; ok != tc.ok {
+				t.Fatalf("expected ok %t, got error %v", tc.ok, err)
+			}
+			if version != tc.version {
+				t.Errorf("expected version %d, got %d", tc.version, version)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sort"
+)
+
+type RaycastHit struct {
+	hit bool
+	object Object
+
+	// Fraction along the line, 0 is the origin and 1 is the endpoint
+	t float64
+	point Vec2
+	normal Vec2
+}
+
+func NewRaycastHit() RaycastHit {
+	return RaycastHit {
+		hit: false,
+		object: nil,
+		t: 1.0,
+		point: NewVec2(0, 0),
+		normal: NewVec2(0, 0),
+	}
+}
+
+func (rh RaycastHit) GetHit() bool { return rh.hit }
+func (rh RaycastHit) GetObject() Object { return rh.object }
+func (rh RaycastHit) GetT() float64 { return rh.t }
+func (rh RaycastHit) GetPoint() Vec2 { return rh.point }
+func (rh RaycastHit) GetNormal() Vec2 { return rh.normal }
+
+// Closest object along the line that passes options.
+func (g *Grid) RaycastFirst(line Line, options ColliderOptions) RaycastHit {
+	first := NewRaycastHit()
+	for _, object := range(g.queryBroadphase(NewAABBFromLine(line), options)) {
+		hit := raycastObject(line, object)
+		if hit.hit && (!first.hit || hit.t < first.t) {
+			first = hit
+		}
+	}
+	return first
+}
+
+// Every object along the line that passes options, sorted from closest to farthest.
+func (g *Grid) RaycastAll(line Line, options ColliderOptions) []RaycastHit {
+	hits := make([]RaycastHit, 0)
+	for _, object := range(g.queryBroadphase(NewAABBFromLine(line), options)) {
+		if hit := raycastObject(line, object); hit.hit {
+			hits = append(hits, hit)
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].t < hits[j].t
+	})
+	return hits
+}
+
+// Objects that pass options and overlap the circle.
+func (g *Grid) QueryCircle(center Vec2, radius float64, options ColliderOptions) []Object {
+	circle := NewCircle(NewInit(InvalidId(), center, NewVec2(2 * radius, 2 * radius)))
+	return g.queryProfile(circle, options)
+}
+
+// Objects that pass options and overlap the axis aligned rectangle.
+func (g *Grid) QueryRect(center Vec2, dim Vec2, options ColliderOptions) []Object {
+	rect := NewRec2(NewInit(InvalidId(), center, dim))
+	return g.queryProfile(rect, options)
+}
+
+func (g *Grid) queryProfile(profile Profile, options ColliderOptions) []Object {
+	objects := make([]Object, 0)
+	for _, object := range(g.queryBroadphase(NewAABB(profile.Pos(), profile.Dim()), options)) {
+		if result := profile.OverlapProfile(object.GetProfile()); result.hit {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+func (g *Grid) queryBroadphase(box AABB, options ColliderOptions) []Object {
+	g.queried = g.broadphase.Query(box, g.queried[:0])
+
+	objects := make([]Object, 0, len(g.queried))
+	for _, object := range(g.queried) {
+		if object.HasAttribute(deletedAttribute) || !options.Evaluate(object) {
+			continue
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+func raycastObject(line Line, object Object) RaycastHit {
+	result := NewRaycastHit()
+
+	// Starting inside counts as an immediate hit
+	if object.Contains(line.Origin()).contains {
+		result.hit = true
+		result.object = object
+		result.t = 0
+		result.point = line.Origin()
+		result.normal = line.Ray()
+		result.normal.Normalize()
+		result.normal.Negate()
+		return result
+	}
+
+	isect := object.Intersects(line)
+	if !isect.hit {
+		return result
+	}
+
+	result.hit = true
+	result.object = object
+	result.t = isect.t
+	result.point = line.Point(isect.t)
+	result.normal = isect.normal
+	return result
+}
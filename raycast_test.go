@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+)
+
+// Player at the origin with walls at x = 3, 6 and 9 along the x axis
+func newRaycastTestGrid() (*Grid, Object, []Object) {
+	grid := NewGrid(4, 4)
+	caster := grid.New(NewInit(grid.NextSpacedId(playerSpace), NewVec2(0, 0), NewVec2(0.8, 1.44)))
+	grid.Upsert(caster)
+
+	walls := make([]Object, 0)
+	for _, x := range([]float64 {6, 3, 9}) {
+		wall := grid.New(NewInit(grid.NextSpacedId(wallSpace), NewVec2(x, 0), NewVec2(1, 1)))
+		grid.Upsert(wall)
+		walls = append(walls, wall)
+	}
+	return grid, caster, walls
+}
+
+func newRaycastTestOptions(caster Object) ColliderOptions {
+	options := NewColliderOptions()
+	options.SetSpaces(playerSpace, wallSpace)
+	options.SetIds(false, caster.GetSpacedId())
+	return options
+}
+
+func TestRaycastFirst(t *testing.T) {
+	grid, caster, walls := newRaycastTestGrid()
+	options := newRaycastTestOptions(caster)
+
+	for _, tc := range([]struct {
+		name string
+		line Line
+		expected Object
+		t float64
+	}{
+		{"closest", NewLine(NewVec2(0, 0), NewVec2(10, 0)), walls[1], 0.25},
+		{"reversed", NewLine(NewVec2(10, 0), NewVec2(-10, 0)), walls[2], 0.05},
+		{"starts inside", NewLine(NewVec2(6, 0), NewVec2(10, 0)), walls[0], 0},
+		{"too short", NewLine(NewVec2(0, 0), NewVec2(2, 0)), nil, 1},
+		{"above", NewLine(NewVec2(0, 2), NewVec2(10, 0)), nil, 1},
+		{"wrong way", NewLine(NewVec2(0, 0), NewVec2(-10, 0)), nil, 1},
+	}) {
+		hit := grid.RaycastFirst(tc.line, options)
+		if hit.GetHit() != (tc.expected != nil) || hit.GetObject() != tc.expected {
+			t.Errorf("%s: expected hit on %v, got %t", tc.name, tc.expected, hit.GetHit())
+			continue
+		}
+		if Abs(hit.GetT() - tc.t) > approxEpsilon {
+			t.Errorf("%s: expected t = %f, got %f", tc.name, tc.t, hit.GetT())
+		}
+	}
+}
+
+func TestRaycastAll(t *testing.T) {
+	grid, caster, walls := newRaycastTestGrid()
+	options := newRaycastTestOptions(caster)
+
+	for _, tc := range([]struct {
+		name string
+		line Line
+		expected []Object
+	}{
+		{"sorted", NewLine(NewVec2(0, 0), NewVec2(10, 0)), []Object {walls[1], walls[0], walls[2]}},
+		{"reversed", NewLine(NewVec2(10, 0), NewVec2(-10, 0)), []Object {walls[2], walls[0], walls[1]}},
+		{"partial", NewLine(NewVec2(0, 0), NewVec2(6, 0)), []Object {walls[1], walls[0]}},
+		{"miss", NewLine(NewVec2(0, 2), NewVec2(10, 0)), []Object {}},
+	}) {
+		hits := grid.RaycastAll(tc.line, options)
+		if len(hits) != len(tc.expected) {
+			t.Errorf("%s: expected %d hits, got %d", tc.name, len(tc.expected), len(hits))
+			continue
+		}
+		for i, hit := range(hits) {
+			if hit.GetObject() != tc.expected[i] {
+				t.Errorf("%s: expected hit %d on %v, got %v", tc.name, i, tc.expected[i].GetSpacedId(), hit.GetObject().GetSpacedId())
+			}
+		}
+	}
+}
+
+func TestQueryShapes(t *testing.T) {
+	grid, caster, walls := newRaycastTestGrid()
+	options := newRaycastTestOptions(caster)
+	all := NewColliderOptions()
+	all.SetSpaces(playerSpace, wallSpace)
+
+	for _, tc := range([]struct {
+		name string
+		query func() []Object
+		expected []Object
+	}{
+		{"circle", func() []Object { return grid.QueryCircle(NewVec2(3, 0), 1, options) }, []Object {walls[1]}},
+		{"circle between", func() []Object { return grid.QueryCircle(NewVec2(4.5, 0), 1.1, options) }, []Object {walls[1], walls[0]}},
+		{"circle miss", func() []Object { return grid.QueryCircle(NewVec2(4.5, 0), 0.5, options) }, []Object {}},
+		{"circle excludes caster", func() []Object { return grid.QueryCircle(NewVec2(0, 0), 1, options) }, []Object {}},
+		{"circle includes caster", func() []Object { return grid.QueryCircle(NewVec2(0, 0), 1, all) }, []Object {caster}},
+		{"rect", func() []Object { return grid.QueryRect(NewVec2(7.5, 0), NewVec2(4, 1), options) }, []Object {walls[0], walls[2]}},
+		{"rect miss", func() []Object { return grid.QueryRect(NewVec2(7.5, 2), NewVec2(4, 1), options) }, []Object {}},
+		{"rect excludes caster", func() []Object { return grid.QueryRect(NewVec2(1.5, 0), NewVec2(4, 1), options) }, []Object {walls[1]}},
+	}) {
+		results := tc.query()
+		if len(results) != len(tc.expected) {
+			t.Errorf("%s: expected %d objects, got %d", tc.name, len(tc.expected), len(results))
+			continue
+		}
+		found := make(map[Object]bool)
+		for _, object := range(results) {
+			found[object] = true
+		}
+		for _, object := range(tc.expected) {
+			if !found[object] {
+				t.Errorf("%s: expected %v", tc.name, object.GetSpacedId())
+			}
+		}
+	}
+}
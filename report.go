@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+type ErrorKindType string
+const (
+	errorKind ErrorKindType = "error"
+	panicKind ErrorKindType = "panic"
+)
+
+// Logged with one field per value so failures can be searched and counted
+type ErrorReport struct {
+	Time time.Time `json:"time"`
+	Kind ErrorKindType `json:"kind"`
+	Room string `json:"room"`
+	Client string `json:"client,omitempty"`
+	MsgType MessageType `json:"msgType,omitempty"`
+	Error string `json:"error"`
+	Stack string `json:"stack,omitempty"`
+}
+
+// Client can be nil for errors that affect the whole room
+func NewErrorReport(r *Room, c *Client, msgType MessageType, err error) ErrorReport {
+	report := ErrorReport {
+		Time: time.Now(),
+		Kind: errorKind,
+		Room: r.name,
+		MsgType: msgType,
+		Error: err.Error(),
+	}
+	if c != nil {
+		report.Client = c.GetDisplayName()
+	}
+	return report
+}
+
+// Call with the result of recover()
+func NewPanicReport(r *Room, c *Client, msgType MessageType, recovered interface{}) ErrorReport {
+	report := NewErrorReport(r, c, msgType, fmt.Errorf("%v", recovered))
+	report.Kind = panicKind
+	report.Stack = string(debug.Stack())
+	return report
+}
+
+func (er ErrorReport) Log() {
+	l := logger.With("room", er.Room).With("kind", er.Kind)
+	if er.Client != "" {
+		l = l.With("client", er.Client)
+	}
+	if er.MsgType != 0 {
+		l = l.With("msgType", er.MsgType)
+	}
+	if er.Stack != "" {
+		l = l.With("stack", er.Stack)
+	}
+	l.Error("%s", er.Error)
+}
@@ -42,25 +42,12 @@ func (rb *RoofBlock) LoadTemplate(template BlockTemplate) {
 
 	switch (template) {
 	case weaponsBlockTemplate:
-		uzi := NewPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x - width / 3, y + rb.thick), NewVec2(1.2, 1.2), bottomCardinal))
-		uzi.SetByteAttribute(typeByteAttribute, uint8(uziWeapon))
-		uzi.SetByteAttribute(subtypeByteAttribute, uint8(grapplingHookWeapon))
-		rb.objects = append(rb.objects, uzi)
-
-		star := NewPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x - width / 9, y + rb.thick), NewVec2(1.2, 1.2), bottomCardinal))
-		star.SetByteAttribute(typeByteAttribute, uint8(starWeapon))
-		star.SetByteAttribute(subtypeByteAttribute, uint8(boosterEquip))
-		rb.objects = append(rb.objects, star)
-
-		bazooka := NewPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x + width / 9, y + rb.thick), NewVec2(1.2, 1.2), bottomCardinal))
-		bazooka.SetByteAttribute(typeByteAttribute, uint8(bazookaWeapon))
-		bazooka.SetByteAttribute(subtypeByteAttribute, uint8(jetpackEquip))
-		rb.objects = append(rb.objects, bazooka)
-
-		sniper := NewPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x + width / 3, y + rb.thick), NewVec2(1.2, 1.2), bottomCardinal))
-		sniper.SetByteAttribute(typeByteAttribute, uint8(sniperWeapon))
-		sniper.SetByteAttribute(subtypeByteAttribute, uint8(chargerEquip))
-		rb.objects = append(rb.objects, sniper)
+		spacing := width / float64(len(templateWeapons))
+		for i, weaponType := range(templateWeapons) {
+			offset := (float64(i) + 0.5) * spacing - width / 2
+			pickup := NewWeaponPickup(NewInitC(Id(pickupSpace, 0), NewVec2(x + offset, y + rb.thick), NewVec2(1.2, 1.2), bottomCardinal), weaponType)
+			rb.objects = append(rb.objects, pickup)
+		}
 
 		rb.occupied.AddAll(bottomLeftCardinal, bottomCardinal, bottomRightCardinal) 
 	}
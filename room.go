@@ -1,16 +1,21 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
-	"log"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	isWasm bool = false
+
+	maxRoomPlayers int = 16
 )
 
 type Room struct {
@@ -33,47 +38,103 @@ type Room struct {
 	statTicker *time.Ticker
 
 	chat *Chat
+	recorder *MatchRecorder
+
+	// Nil when voice uses a full mesh of peer connections
+	sfu *VoiceSFU
+
+	log Logger
+	slowFPSSampler *LogSampler
 
 	incoming chan IncomingMsg
 	incomingQueue []IncomingMsg
+	snapshot chan chan []byte
+	snapshotTimer Timer
+	// Pending writes to storage
+	saving sync.WaitGroup
+	// Closed by Close to stop the room, e.g. once it's been moved to another node
+	stop chan struct{}
+	stopOnce sync.Once
+	// Closed when run returns so other goroutines stop sending to the room
+	done chan struct{}
+
+	options RoomOptions
+	// Clients counted against maxPlayers, changed atomically since joins happen outside the room's goroutine
+	members int32
+
+	// Read by the room directory outside of the room's goroutine
+	infoMutex sync.Mutex
+	info RoomInfo
+}
+
+// Listed by the room directory, private rooms are listed without a name
+type RoomInfo struct {
+	Name string `json:"name,omitempty"`
+	Host string `json:"host,omitempty"`
+	Players int `json:"players"`
+	MaxPlayers int `json:"maxPlayers"`
+	Public bool `json:"public"`
+	Locked bool `json:"locked"`
+	State GameStateType `json:"state"`
+	Level LevelIdType `json:"level"`
+}
+
+// Set by whoever creates the room, e.g. password=abc&private=1&max=8
+type RoomOptions struct {
+	password [sha256.Size]byte
+	hasPassword bool
+	public bool
+	maxPlayers int
 }
 
 var rooms = make(map[string]*Room)
-func CreateOrJoinRoom(vars map[string]string, ws *websocket.Conn) {
+var roomsMutex sync.Mutex
+
+func CreateOrJoinRoom(vars map[string]string, ws *websocket.Conn, protocol int) {
 	roomName := vars["room"]
+
+	roomsMutex.Lock()
 	_, roomExists := rooms[roomName]
 
+	// Rooms made by matchmaking can only be joined with a token
+	reservation, reserved := matchmaker.GetReservation(roomName, vars["token"])
+	if !reserved && matchmaker.HasRoom(roomName) {
+		roomsMutex.Unlock()
+		logger.With("room", roomName).Warn("rejected %s without a valid token", vars["name"])
+		ws.Close()
+		return
+	}
+
 	if !roomExists {
-		rooms[roomName] = &Room {
-			name: roomName,
-
-			nextClientId: 0,
-			clients: make(map[IdType]*Client),
-			register: make(chan *Client),
-			registerQueue: make([]*Client, 0),
-			init: make(chan *Client),
-			initQueue: make([]*Client, 0),
-			unregister: make(chan *Client),
-			unregisterQueue: make([]*Client, 0),
-			deleteTimer: NewTimer(30 * time.Second),
-
-			game: NewGame(),
-			ticker: time.NewTicker(frameTime),
-			gameTicks: 0,
-			statTicker: time.NewTicker(1 * time.Second),
-
-			chat: NewChat(),
-
-			incoming: make(chan IncomingMsg),
-			incomingQueue: make([]IncomingMsg, 0),
+		rooms[roomName] = newRoom(roomName, vars)
+		if reserved {
+			rooms[roomName].game.GetGrid().SetRequiredPlayers(matchmaker.GetNumReservations(roomName), matchStartTimeout)
+			rooms[roomName].options.public = false
+			rooms[roomName].options.maxPlayers = matchmaker.GetNumReservations(roomName)
 		}
-		rooms[roomName].game.LoadLevel(lobbyLevel, 0)
+		rooms[roomName].updateInfo()
 		go rooms[roomName].run()
 	}
 
 	r := rooms[roomName]
+	// Hold the lock until the client is counted so concurrent joins can't overfill the room
+	if err := r.checkJoin(vars, reserved); err != nil {
+		roomsMutex.Unlock()
+		r.log.Warn("rejected %s: %v", vars["name"], err)
+		rejectJoin(ws, err.Error())
+		return
+	}
+
 	clientId := r.nextClientId
-	if stringId, idOk := vars["id"]; idOk {
+	if reserved {
+		if _, ok := r.clients[reservation.id]; ok {
+			roomsMutex.Unlock()
+			r.log.Warn("%s is already connected", reservation.name)
+			ws.Close()
+			return
+		}
+		clientId = reservation.id
+	} else if stringId, idOk := vars["id"]; idOk {
 		intId, err := strconv.Atoi(stringId)
 		if err == nil {
 			id := IdType(intId)
@@ -83,17 +144,151 @@ func CreateOrJoinRoom(vars map[string]string, ws *websocket.Conn) {
 		}
 	}
 
-	client := NewClient(r, ws, vars["name"], clientId)
+	name := vars["name"]
+	if reserved {
+		name = reservation.name
+	}
+	client := NewClient(r, ws, name, clientId)
+	client.protocol = protocol
+	client.objectEncoding = parseObjectEncoding(vars, protocol)
+	if reserved {
+		client.team = reservation.team
+	}
 	if clientId >= r.nextClientId {
 		r.nextClientId = clientId + 1
 	}
-	r.register <- client
+
+	client.member = true
+	atomic.AddInt32(&r.members, 1)
+	roomsMutex.Unlock()
+
+	if !r.sendRegister(client) {
+		rejectJoin(ws, "Room closed")
+	}
+}
+
+// Starts in the lobby, the caller registers the room and runs it
+func newRoom(roomName string, vars map[string]string) *Room {
+	r := &Room {
+		name: roomName,
+
+		nextClientId: 0,
+		clients: make(map[IdType]*Client),
+		register: make(chan *Client),
+		registerQueue: make([]*Client, 0),
+		init: make(chan *Client),
+		initQueue: make([]*Client, 0),
+		unregister: make(chan *Client),
+		unregisterQueue: make([]*Client, 0),
+		deleteTimer: NewTimer(30 * time.Second),
+
+		game: NewGame(),
+		ticker: time.NewTicker(frameTime),
+		gameTicks: 0,
+		statTicker: time.NewTicker(1 * time.Second),
+
+		chat: NewChat(),
+		recorder: NewMatchRecorder(roomName),
+		sfu: parseVoiceSFU(vars),
+
+		log: logger.With("room", roomName),
+		slowFPSSampler: NewLogSampler(30 * time.Second),
+
+		incoming: make(chan IncomingMsg),
+		incomingQueue: make([]IncomingMsg, 0),
+		snapshot: make(chan chan []byte),
+		snapshotTimer: NewTimer(snapshotInterval),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+
+		options: parseRoomOptions(vars),
+		members: 0,
+	}
+	r.game.LoadLevel(lobbyLevel, 0)
+	r.game.GetGrid().SetTeamOptions(parseTeamOptions(vars))
+	r.game.GetGrid().SetRoundOptions(parseRoundOptions(vars))
+	return r
+}
+
+func parseRoomOptions(vars map[string]string) RoomOptions {
+	options := RoomOptions {
+		hasPassword: false,
+		public: true,
+		maxPlayers: maxRoomPlayers,
+	}
+
+	if password := vars["password"]; password != "" {
+		options.password = sha256.Sum256([]byte(password))
+		options.hasPassword = true
+	}
+	if private, err := strconv.ParseBool(vars["private"]); err == nil {
+		options.public = !private
+	}
+	if max, err := strconv.Atoi(vars["max"]); err == nil && max > 0 && max <= maxRoomPlayers {
+		options.maxPlayers = max
+	}
+	return options
+}
+
+func (ro RoomOptions) CheckPassword(password string) bool {
+	if !ro.hasPassword {
+		return true
+	}
+	hash := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(hash[:], ro.password[:]) == 1
+}
+
+// Matchmaking tokens skip the password, the matchmaker already limits who has one
+func (r *Room) checkJoin(vars map[string]string, reserved bool) error {
+	if !reserved && !r.options.CheckPassword(vars["password"]) {
+		return errors.New("Wrong password for this room")
+	}
+	if int(atomic.LoadInt32(&r.members)) >= r.options.maxPlayers {
+		return fmt.Errorf("Room is full (%d players)", r.options.maxPlayers)
+	}
+	return nil
+}
+
+// Close reason is shown to the player
+func rejectJoin(ws *websocket.Conn, reason string) {
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason))
+	ws.Close()
 }
 
 func (r *Room) run() {
 	defer func() {
-		r.print("deleted room")
-		delete(rooms, r.name)
+		// Game state can't be trusted after a panic, so close the room instead of taking down the server
+		reason := "Room closed"
+		if p := recover(); p != nil {
+			NewPanicReport(r, nil, unknownType, p).Log()
+			reason = "Server error"
+		}
+
+		// Drop the save while the name is still ours so it can't delete a new room's save
+		r.deleteSnapshot()
+
+		// Remove the room before kicking so reconnects make a new one
+		r.log.Info("deleted room")
+		roomsMutex.Lock()
+		if rooms[r.name] == r {
+			delete(rooms, r.name)
+		}
+		roomsMutex.Unlock()
+		matchmaker.ReleaseRoom(r.name)
+
+		r.ticker.Stop()
+		r.statTicker.Stop()
+		// Nothing reads the room's channels from here on
+		close(r.done)
+		for _, client := range(r.clients) {
+			client.Kick(reason)
+		}
+		for _, client := range(r.registerQueue) {
+			client.Kick(reason)
+		}
+		for _, client := range(r.initQueue) {
+			client.Kick(reason)
+		}
 	}()
 
 	for {
@@ -106,16 +301,32 @@ func (r *Room) run() {
 			r.unregisterQueue = append(r.unregisterQueue, client)
 		case imsg := <-r.incoming:
 			r.incomingQueue = append(r.incomingQueue, imsg)
+		case reply := <-r.snapshot:
+			reply <- Pack(r.takeSnapshot())
+		case <-r.stop:
+			return
 		case _ = <-r.ticker.C:
 			updates := r.game.Update()
+			r.recordMatch(updates)
 			r.sendGameState(updates)
 			r.gameTicks += 1
+
+			if r.sfu != nil && r.gameTicks % voiceRoutingTicks == 0 {
+				r.sfu.UpdateRoutes(r.game.GetGrid())
+			}
 		case _ = <-r.statTicker.C:
+			r.updateInfo()
 			if len(r.clients) == 0 {
 				continue
 			}
+			if !r.snapshotTimer.On() {
+				r.saveSnapshot()
+				r.snapshotTimer.Start()
+			}
 			if r.gameTicks < 60 {
-				r.print(fmt.Sprintf("slow FPS: %d", r.gameTicks))
+				if ok, skipped := r.slowFPSSampler.Sample(time.Now()); ok {
+					r.log.With("fps", r.gameTicks).With("skipped", skipped).Warn("slow FPS")
+				}
 			}
 			r.gameTicks = 0
 		default:
@@ -123,7 +334,7 @@ func (r *Room) run() {
 				for _, client := range(r.registerQueue) {
 					err := r.registerClient(client)
 					if err != nil {
-						r.unregister <- client
+						r.unregisterQueue = append(r.unregisterQueue, client)
 					}
 				}
 				r.registerQueue = r.registerQueue[:0]
@@ -133,7 +344,7 @@ func (r *Room) run() {
 				for _, client := range(r.initQueue) {
 					err := r.initClient(client)
 					if err != nil {
-						r.unregister <- client
+						r.unregisterQueue = append(r.unregisterQueue, client)
 					}
 				}
 				r.initQueue = r.initQueue[:0]
@@ -148,7 +359,7 @@ func (r *Room) run() {
 
 			if len(r.clients) == 0 {
 				if !r.deleteTimer.Started() {
-					r.print("started countdown to delete room")
+					r.log.Info("started countdown to delete room")
 					r.deleteTimer.Start()
 				}
 
@@ -156,19 +367,13 @@ func (r *Room) run() {
 					return
 				}
 			} else if r.deleteTimer.Started() {
-				r.print("stopping deletion due to reconnect")
+				r.log.Info("stopping deletion due to reconnect")
 				r.deleteTimer.Stop()
 			}
 
 			if len(r.incomingQueue) > 0 {
 				for _, imsg := range(r.incomingQueue) {
-					msg := Msg{}
-					err := Unpack(imsg.b, &msg)
-					if err != nil {
-						r.print(fmt.Sprintf("unpacking error: %v", err))
-						continue
-					}
-					r.processMsg(msg, imsg.client)
+					r.processIncoming(imsg)
 				}
 				r.incomingQueue = r.incomingQueue[:0]
 			}
@@ -176,9 +381,129 @@ func (r *Room) run() {
 	}
 }
 
+// Kicks everyone and deletes the room, returns once it has stopped
+func (r *Room) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+}
+
+// Sends from other goroutines give up once the room has stopped instead of blocking forever
+func (r *Room) sendRegister(client *Client) bool {
+	select {
+	case r.register <- client:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+func (r *Room) sendInit(client *Client) bool {
+	select {
+	case r.init <- client:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+func (r *Room) sendUnregister(client *Client) bool {
+	select {
+	case r.unregister <- client:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+func (r *Room) sendIncoming(imsg IncomingMsg) bool {
+	select {
+	case r.incoming <- imsg:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// Rooms hosted by this process
+func GetRoomInfos() []RoomInfo {
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	infos := make([]RoomInfo, 0, len(rooms))
+	for _, r := range(rooms) {
+		info := r.GetInfo()
+		if !info.Public {
+			info.Name = ""
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (r *Room) GetInfo() RoomInfo {
+	r.infoMutex.Lock()
+	defer r.infoMutex.Unlock()
+	return r.info
+}
+
+// Called from the room's goroutine, the directory can be a second behind
+func (r *Room) updateInfo() {
+	r.infoMutex.Lock()
+	defer r.infoMutex.Unlock()
+
+	state, _ := r.game.GetGrid().GetGameState()
+	r.info = RoomInfo {
+		Name: r.name,
+		Host: cluster.Self(),
+		Players: len(r.clients),
+		MaxPlayers: r.options.maxPlayers,
+		Public: r.options.public,
+		Locked: r.options.hasPassword,
+		State: state,
+		Level: r.game.level.GetId(),
+	}
+}
+
+// Set by whoever creates the room, e.g. teams=kd&shuffle=1&afk=30
+func parseTeamOptions(vars map[string]string) TeamOptions {
+	options := NewTeamOptions()
+
+	switch vars["teams"] {
+	case "portal":
+		options.assignment = portalTeamAssignment
+	case "count":
+		options.assignment = countTeamAssignment
+	case "kd":
+		options.assignment = kdTeamAssignment
+	}
+
+	if shuffle, err := strconv.ParseBool(vars["shuffle"]); err == nil {
+		options.shuffle = shuffle
+	}
+	if seconds, err := strconv.Atoi(vars["afk"]); err == nil && seconds >= 0 {
+		options.afkTimeout = time.Duration(seconds) * time.Second
+	}
+	return options
+}
+
+// Also set by the room creator, e.g. time=120&freeze=3 in seconds
+func parseRoundOptions(vars map[string]string) RoundOptions {
+	options := NewRoundOptions()
+
+	if seconds, err := strconv.Atoi(vars["time"]); err == nil && seconds >= 0 {
+		options.timeLimit = time.Duration(seconds) * time.Second
+	}
+	if seconds, err := strconv.Atoi(vars["freeze"]); err == nil && seconds >= 0 {
+		options.freeze = time.Duration(seconds) * time.Second
+	}
+	return options
+}
+
 func (r *Room) registerClient(client *Client) error {
 	err := client.InitWebRTC(func() {
-		r.init <- client
+		r.sendInit(client)
 	})
 	if err != nil {
 		return err
@@ -202,18 +527,20 @@ func (r *Room) initClient(client *Client) error {
 		return err
 	}
 
+	r.recorder.SetName(client.id, client.name)
+
 	playerId := Id(playerSpace, client.id)
 	if !r.game.Has(playerId) {
 
 		player := r.game.Add(NewInit(playerId, NewVec2(0, 0), NewVec2(0.8, 1.44))).(*Player)
 		player.SetInitProp(nameProp, client.GetDisplayName())
-		player.SetTeam(0)
+		player.SetTeam(client.team)
 		player.SetSpawn(r.game.GetGrid())
 		player.Respawn()
 	} else {
 		player := r.game.Get(playerId)
 		player.RemoveTTL()
-		r.print(fmt.Sprintf("%s reconnected", client.GetDisplayName()))
+		r.log.Info("%s reconnected", client.GetDisplayName())
 	}
 	playerInitMsg := r.game.createPlayerInitMsg(client.id)
 	err = client.Send(&playerInitMsg)
@@ -231,12 +558,19 @@ func (r *Room) initClient(client *Client) error {
 		client.Send(&chatMsg)
 	}
 
-	r.print(fmt.Sprintf("%s joined, total clients = %d", client.GetDisplayName(), len(r.clients)))
+	r.log.Info("%s joined, total clients = %d", client.GetDisplayName(), len(r.clients))
 	return nil
 }
 
 func (r *Room) unregisterClient(client *Client) error {
+	if r.sfu != nil && client.voice {
+		r.sfu.RemoveClient(client)
+	}
 	client.Close()
+	if client.member {
+		client.member = false
+		atomic.AddInt32(&r.members, -1)
+	}
 	if _, ok := r.clients[client.id]; ok {
 		err := r.updateClients(leftType, client)
 		if err != nil {
@@ -249,10 +583,28 @@ func (r *Room) unregisterClient(client *Client) error {
 			player.SetConstantTTL(10 * time.Second)
 		}
 	}
-	r.print(fmt.Sprintf("unregistered %s, total=%d", client.GetDisplayName(), len(r.clients)))
+	r.log.Info("unregistered %s, total=%d", client.GetDisplayName(), len(r.clients))
 	return nil
 }
 
+// A message that panics only disconnects its sender
+func (r *Room) processIncoming(imsg IncomingMsg) {
+	msg := Msg{}
+	defer func() {
+		if p := recover(); p != nil {
+			NewPanicReport(r, imsg.client, msg.T, p).Log()
+			imsg.client.Kick("Malformed message")
+		}
+	}()
+
+	err := Unpack(imsg.b, &msg)
+	if err != nil {
+		NewErrorReport(r, imsg.client, unknownType, fmt.Errorf("unpacking error: %v", err)).Log()
+		return
+	}
+	r.processMsg(msg, imsg.client)
+}
+
 func (r* Room) processMsg(msg Msg, c* Client) error {
 	var err error
 
@@ -265,6 +617,8 @@ func (r* Room) processMsg(msg Msg, c* Client) error {
 		c.Send(&outMsg)
 	case offerType:
 		err = c.processWebRTCOffer(msg.JSON)
+	case answerType:
+		err = c.processWebRTCAnswer(msg.JSON)
 	case candidateType:
 		err = c.processWebRTCCandidate(msg.JSON)
 	case joinVoiceType:
@@ -279,21 +633,43 @@ func (r* Room) processMsg(msg Msg, c* Client) error {
 		outMsg := r.chat.ProcessChatMsg(c, msg.Chat)
 		r.send(&outMsg)
 	case keyType:
-		r.game.ProcessKeyMsg(c.id, msg.Key)
+		r.processKeyMsg(c, msg.Key)
 	default:
-		r.print(fmt.Sprintf("unknown message type %d", msg.T))
+		c.log.With("msgType", msg.T).Warn("unknown message type")
 	}
 
 	if err != nil {
-		r.print(fmt.Sprintf("error when processing message: %v", err))
+		NewErrorReport(r, c, msg.T, err).Log()
 	}
 	return err
 }
 
+func (r *Room) processKeyMsg(c *Client, keyMsg KeyMsg) {
+	err := c.keyValidator.Validate(keyMsg, time.Now())
+	if err == errStaleKeyMsg {
+		return
+	}
+	if err != nil {
+		// Only log the first violation in each window to avoid spam
+		if c.keyValidator.Violations() == 1 {
+			c.log.With("msgType", keyType).Warn("dropped key message: %v", err)
+		}
+		if c.keyValidator.ShouldKick() {
+			c.log.With("msgType", keyType).Warn("kicking after %d invalid key messages, last: %v", c.keyValidator.Violations(), err)
+			c.Kick("Too many invalid messages")
+		}
+		return
+	}
+
+	r.game.ProcessKeyMsg(c.id, keyMsg)
+}
+
 func (r *Room) updateClients(msgType MessageType, client *Client) error {
 	msg := r.createClientMsg(msgType, client, false)
 
 	if msgType == initType {
+		ice := NewICEConfig(fmt.Sprintf("%s/%d", r.name, client.id))
+		msg.ICE = &ice
 		return client.Send(&msg)
 	} else {
 		r.send(&msg)
@@ -306,6 +682,7 @@ func (r *Room) createClientMsg(msgType MessageType, c *Client, voice bool) Clien
 		T: msgType,
 		Client: c.GetClientData(),
 		Clients: make(map[IdType]ClientData, 0),
+		SFU: voice && r.sfu != nil,
 	}
 	for id, client := range r.clients {
 		if (msgType == leftType && id == c.id) || (voice && !client.voice) {
@@ -320,12 +697,20 @@ func (r *Room) addVoiceClient(c *Client) error {
 	msg := r.createClientMsg(joinVoiceType, c, true)
 	r.send(&msg)
 	c.voice = true
+
+	if r.sfu != nil {
+		return r.sfu.AddSubscriber(c)
+	}
 	return nil
 }
 
 func (r *Room) removeVoiceClient(c *Client) error {
 	msg := r.createClientMsg(leftVoiceType, c, true)
 	r.send(&msg)
+
+	if r.sfu != nil && c.voice {
+		r.sfu.RemoveClient(c)
+	}
 	c.voice = false
 	return nil
 }
@@ -339,7 +724,10 @@ func (r *Room) forwardVoiceMessage(msgType MessageType, c *Client, msg JSONPeerM
 	}
 
 	id := msg.To
-	client := r.clients[msg.To]
+	client, ok := r.clients[id]
+	if !ok {
+		return fmt.Errorf("Cannot forward voice message to missing client %d", id)
+	}
 
 	if !client.voice || c.id == id {
 		return nil
@@ -355,6 +743,29 @@ func (r *Room) send(msg interface{}) {
 	}
 }
 
+// Skips clients that don't know about the message
+func (r *Room) sendProtocol(msg interface{}, version int) {
+	b := Pack(msg)
+	for _, c := range(r.clients) {
+		if c.SupportsProtocol(version) {
+			c.SendBytes(b)
+		}
+	}
+}
+
+// Sends the older form of a message to the clients sendProtocol skips
+func (r *Room) sendBeforeProtocol(msg interface{}, version int) {
+	var b []byte
+	for _, c := range(r.clients) {
+		if !c.SupportsProtocol(version) {
+			if b == nil {
+				b = Pack(msg)
+			}
+			c.SendBytes(b)
+		}
+	}
+}
+
 func (r *Room) sendGameState(updates map[GameUpdateType]bool) {
 	if update, ok := updates[levelGameUpdate]; ok && update {
 		level := r.game.createLevelInitMsg()
@@ -363,15 +774,46 @@ func (r *Room) sendGameState(updates map[GameUpdateType]bool) {
 
 	if update, ok := updates[gameStateUpdate]; ok && update {
 		gameState := r.game.createGameStateMsg()
-		r.send(&gameState)
+		r.sendProtocol(&gameState, roundTimerProtocolVersion)
+
+		legacyGameState := gameState.withoutRoundTimer()
+		r.sendBeforeProtocol(&legacyGameState, roundTimerProtocolVersion)
+	}
+
+	if update, ok := updates[stateChangeGameUpdate]; ok && update {
+		changes := r.game.createStateChangeMsg()
+		r.sendProtocol(&changes, stateChangeProtocolVersion)
+	}
+
+	if update, ok := updates[combatEventGameUpdate]; ok && update {
+		events := r.game.createCombatEventMsg()
+		r.sendProtocol(&events, combatEventProtocolVersion)
 	}
 
 	if update, ok := updates[objectGameUpdate]; ok && update {
 		state := r.game.createObjectDataMsg()
-		r.sendUDP(&state)
+		r.sendObjectState(&state, true)
 
 		if updates, ok := r.game.createObjectUpdateMsg(); ok {
-			r.send(&updates)
+			r.sendObjectState(&updates, false)
+		}
+	}
+}
+
+// Only transitions count, game state updates are also sent every second while the round timer runs
+func (r *Room) recordMatch(updates map[GameUpdateType]bool) {
+	if update, ok := updates[stateChangeGameUpdate]; !ok || !update {
+		return
+	}
+
+	for _, change := range(r.game.GetStateChanges()) {
+		switch change.To {
+		case activeGameState:
+			r.recorder.Start(r.game)
+		case victoryGameState:
+			r.recorder.Record(r.game)
+		case lobbyGameState:
+			r.recorder.Abandon()
 		}
 	}
 }
@@ -383,10 +825,39 @@ func (r *Room) sendUDP(msg interface{}) {
 	}
 }
 
-func (r Room) print(message string) {
-   	var sb strings.Builder
-   	sb.WriteString(r.name)
-   	sb.WriteString(": ")
-   	sb.WriteString(message)
-	log.Printf(sb.String())
-}
\ No newline at end of file
+// Encodes the message at most once for each encoding used by the clients
+func (r *Room) sendObjectState(msg *ObjectStateMsg, udp bool) {
+	encoded := make(map[ObjectEncodingType][]byte)
+	for _, c := range(r.clients) {
+		b, ok := encoded[c.objectEncoding]
+		if !ok {
+			var err error
+			b, err = objectEncoders[c.objectEncoding].Encode(msg)
+			if err != nil {
+				r.downgradeObjectEncoding(c.objectEncoding, msg.T, err)
+				b, ok = encoded[msgpackObjectEncoding]
+				if !ok {
+					b = Pack(msg)
+					encoded[msgpackObjectEncoding] = b
+				}
+			}
+			encoded[c.objectEncoding] = b
+		}
+
+		if udp {
+			c.SendObjectDataUDP(b)
+		} else {
+			c.SendBytes(b)
+		}
+	}
+}
+
+// Switches every client off an encoding that failed so the error is only reported once, clients detect msgpack on their own
+func (r *Room) downgradeObjectEncoding(encoding ObjectEncodingType, msgType MessageType, err error) {
+	r.log.With("encoding", encoding).With("msgType", msgType).Error("switching clients to msgpack: %v", err)
+	for _, c := range(r.clients) {
+		if c.objectEncoding == encoding {
+			c.objectEncoding = msgpackObjectEncoding
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Room without a run loop, messages are processed by calling it directly
+func newTestRoom() *Room {
+	return &Room {
+		name: "test",
+		clients: make(map[IdType]*Client),
+		unregister: make(chan *Client, 8),
+		game: NewGame(),
+		chat: NewChat(),
+		options: parseRoomOptions(map[string]string{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Connects a real websocket so the client can be kicked, returns the browser's end
+func newTestClient(t *testing.T, r *Room, id IdType) (*Client, *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ws, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade: %v", err)
+			return
+		}
+		conns <- ws
+	}))
+	t.Cleanup(server.Close)
+
+	browser, _, err := websocket.DefaultDialer.Dial("ws" + strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { browser.Close() })
+
+	client := NewClient(r, <-conns, "test", id)
+	r.clients[id] = client
+	return client, browser
+}
+
+func TestProcessIncomingRecoversFromPanic(t *testing.T) {
+	r := newTestRoom()
+	bad, badBrowser := newTestClient(t, r, 0)
+	good, _ := newTestClient(t, r, 1)
+
+	// WebRTC isn't initialized yet, so the offer dereferences a nil PeerConnection
+	r.processIncoming(IncomingMsg {
+		b: Pack(JSONMsg {T: offerType, JSON: map[string]interface{} {"sdp": "v=0"}}),
+		client: bad,
+	})
+
+	badBrowser.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := badBrowser.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("expected sender to be kicked, got %v", err)
+	}
+
+	if good.closed {
+		t.Errorf("expected other clients to stay connected")
+	}
+	r.processIncoming(IncomingMsg {
+		b: Pack(ChatMsg {T: chatType, M: "still here"}),
+		client: good,
+	})
+}
+
+func TestClientRecoversFromPanic(t *testing.T) {
+	captureLogs(t, errorLogLevel + 1, false)
+
+	r := newTestRoom()
+	c, browser := newTestClient(t, r, 0)
+
+	// Stands in for a Pion callback
+	func() {
+		defer c.recoverPanic(candidateType)
+		var ice *webrtc.ICECandidate
+		ice.ToJSON()
+	}()
+
+	browser.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := browser.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("expected client to be kicked, got %v", err)
+	}
+}
+
+func TestSendGameStateByProtocol(t *testing.T) {
+	r := newTestRoom()
+	_, legacyBrowser := newTestClient(t, r, 0)
+	current, currentBrowser := newTestClient(t, r, 1)
+	current.protocol = protocolVersion
+
+	r.game.grid.gameMode.(*VipMode).overtime = true
+	r.game.grid.gameMode.(*VipMode).stateMachine.ForceState(activeGameState)
+	r.game.grid.AddCombatEvent(CombatEvent {T: killCombatEvent})
+	r.sendGameState(map[GameUpdateType]bool {
+		gameStateUpdate: true,
+		combatEventGameUpdate: true,
+	})
+
+	read := func(browser *websocket.Conn) (Msg, PropMap) {
+		t.Helper()
+		browser.SetReadDeadline(time.Now().Add(time.Second))
+		_, b, err := browser.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		msg := Msg{}
+		gameState := GameStateMsg{}
+		Unpack(b, &msg)
+		Unpack(b, &gameState)
+		return msg, gameState.G
+	}
+
+	if msg, props := read(legacyBrowser); msg.T != gameStateType || props[overtimeProp] != nil {
+		t.Errorf("expected game state without round timer props, got %d %v", msg.T, props)
+	}
+	if msg, props := read(currentBrowser); msg.T != gameStateType || props[overtimeProp] != true {
+		t.Errorf("expected game state with round timer props, got %d %v", msg.T, props)
+	}
+	if msg, _ := read(currentBrowser); msg.T != combatEventType {
+		t.Errorf("expected combat events, got %d", msg.T)
+	}
+
+	legacyBrowser.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, b, err := legacyBrowser.ReadMessage(); err == nil {
+		t.Errorf("expected legacy client not to get combat events, got %v", b)
+	}
+}
+
+func TestWebsocketFrameSkip(t *testing.T) {
+	r := newTestRoom()
+	c, browser := newTestClient(t, r, 0)
+	c.transport = websocketTransport
+
+	// Only object data is skipped, everything else still has to arrive
+	for i := 0; i < websocketFrameSkip; i += 1 {
+		c.SendObjectDataUDP(Pack(PingMsg {T: objectDataType}))
+		c.SendBytesUDP(Pack(PingMsg {T: pingType}))
+	}
+
+	received := make(map[MessageType]int)
+	for i := 0; i < websocketFrameSkip + 1; i += 1 {
+		browser.SetReadDeadline(time.Now().Add(time.Second))
+		_, b, err := browser.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		msg := Msg{}
+		Unpack(b, &msg)
+		received[msg.T] += 1
+	}
+	if received[objectDataType] != 1 || received[pingType] != websocketFrameSkip {
+		t.Errorf("expected 1 object data and %d pings, got %v", websocketFrameSkip, received)
+	}
+}
+
+func TestForwardVoiceMessageToMissingClient(t *testing.T) {
+	r := newTestRoom()
+	c, _ := newTestClient(t, r, 0)
+
+	err := r.forwardVoiceMessage(voiceOfferType, c, JSONPeerMsg {To: 7})
+	if err == nil {
+		t.Errorf("expected error for missing client")
+	}
+}
+
+func TestSendObjectStateDowngradesEncoding(t *testing.T) {
+	r := newTestRoom()
+	binary, binaryBrowser := newTestClient(t, r, 0)
+	binary.objectEncoding = binaryObjectEncoding
+	_, msgpackBrowser := newTestClient(t, r, 1)
+
+	bad := ObjectStateMsg {
+		T: objectDataType,
+		Os: ObjectPropMap {
+			playerSpace: {0: PropMap {posProp: "not a vec"}},
+		},
+	}
+	r.sendObjectState(&bad, false)
+
+	for _, browser := range([]*websocket.Conn {binaryBrowser, msgpackBrowser}) {
+		browser.SetReadDeadline(time.Now().Add(time.Second))
+		_, b, err := browser.ReadMessage()
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if _, err := (MsgpackObjectEncoder{}).Decode(b); err != nil {
+			t.Errorf("expected msgpack object state, got %v", err)
+		}
+	}
+	if binary.objectEncoding != msgpackObjectEncoding {
+		t.Errorf("expected client to be switched to msgpack, got %d", binary.objectEncoding)
+	}
+}
+
+func TestParseUint16(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		value interface{}
+		expected uint16
+		ok bool
+	}{
+		{"int8", int8(1), 1, true},
+		{"uint8", uint8(200), 200, true},
+		{"int16", int16(1000), 1000, true},
+		{"int64", int64(65535), 65535, true},
+		{"negative", int8(-1), 0, false},
+		{"too big", int32(70000), 0, false},
+		{"string", "1", 0, false},
+		{"nil", nil, 0, false},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			value, ok := parseUint16(tc.value)
+			if ok != tc.ok || value != tc.expected {
+				t.Errorf("expected %d, %t, got %d, %t", tc.expected, tc.ok, value, ok)
+			}
+		})
+	}
+}
+
+func TestParseRoomOptions(t *testing.T) {
+	options := parseRoomOptions(map[string]string {"password": "abc", "private": "1", "max": "4"})
+	if options.public || options.maxPlayers != 4 || !options.hasPassword {
+		t.Errorf("unexpected options %+v", options)
+	}
+	if !options.CheckPassword("abc") || options.CheckPassword("abd") || options.CheckPassword("") {
+		t.Errorf("expected only the right password to pass")
+	}
+
+	defaults := parseRoomOptions(map[string]string {"max": "100"})
+	if !defaults.public || defaults.maxPlayers != maxRoomPlayers || !defaults.CheckPassword("anything") {
+		t.Errorf("unexpected defaults %+v", defaults)
+	}
+}
+
+func TestCheckJoin(t *testing.T) {
+	r := newTestRoom()
+	r.options = parseRoomOptions(map[string]string {"password": "abc", "max": "1"})
+
+	if err := r.checkJoin(map[string]string {"password": "abd"}, false); err == nil {
+		t.Errorf("expected wrong password to be rejected")
+	}
+	if err := r.checkJoin(map[string]string {}, true); err != nil {
+		t.Errorf("expected reserved client to skip the password, got %v", err)
+	}
+
+	c, _ := newTestClient(t, r, 0)
+	c.member = true
+	r.members = 1
+	if err := r.checkJoin(map[string]string {"password": "abc"}, false); err == nil {
+		t.Errorf("expected full room to be rejected")
+	}
+
+	// Unregistering twice only frees the slot once
+	r.unregisterClient(c)
+	r.unregisterClient(c)
+	if r.members != 0 {
+		t.Errorf("expected 0 members, got %d", r.members)
+	}
+	if err := r.checkJoin(map[string]string {"password": "abc"}, false); err != nil {
+		t.Errorf("expected slot to be free after leaving, got %v", err)
+	}
+}
+
+func TestRecordMatchStats(t *testing.T) {
+	prev := storage
+	storage = NewMemoryStorage()
+	defer func() { storage = prev }()
+
+	h := newVipHarness(t)
+	r := newTestRoom()
+	r.game = h.game
+	r.recorder = NewMatchRecorder("test")
+	for i := 0; i < 4; i += 1 {
+		r.recorder.SetName(IdType(i), fmt.Sprintf("player%d", i))
+	}
+
+	step := func(ticks int) {
+		for i := 0; i < ticks; i += 1 {
+			h.now = h.now.Add(frameTime)
+			r.recordMatch(h.game.UpdateAt(h.now))
+		}
+	}
+	kill := func(killer IdType, victim IdType) {
+		h.player(victim).TakeDamage(NewDamage(h.player(killer).GetSpacedId(), bulletDamage, 1000, h.player(victim).Pos()))
+	}
+
+	stats := func(name string) PlayerStats {
+		stats, _, _ := storage.GetPlayerStats(name)
+		return stats
+	}
+
+	// Someone on the other team kills the VIP, then waits for the next round
+	mode := h.grid().gameMode.(*VipMode)
+	kills := make(map[IdType]int)
+	deaths := make(map[IdType]int)
+	playRound := func() {
+		t.Helper()
+		for i := 0; i < 300 && h.state() != activeGameState; i += 1 {
+			step(1)
+		}
+		if state := h.state(); state != activeGameState {
+			t.Fatalf("expected active state, got %d", state)
+		}
+
+		vip := mode.vip.GetId()
+		vipTeam, _ := mode.vip.GetByteAttribute(teamByteAttribute)
+		killer := IdType(0)
+		for ; killer < 4; killer += 1 {
+			if team, _ := h.player(killer).GetByteAttribute(teamByteAttribute); team != vipTeam {
+				break
+			}
+		}
+		kill(killer, vip)
+		kills[killer] += 1
+		deaths[vip] += 1
+
+		step(2)
+		if state := h.state(); state != victoryGameState {
+			t.Fatalf("expected victory state, got %d", state)
+		}
+	}
+
+	playRound()
+	time.Sleep(50 * time.Millisecond)
+	if s := stats("player0"); s.Matches != 0 {
+		t.Fatalf("expected rounds before the end of the match not to be recorded, got %+v", s)
+	}
+
+	// Skip ahead so the next round decides the match
+	mode.teamScores[1], mode.teamScores[2] = vipMaxScore - 1, vipMaxScore - 1
+	playRound()
+	if !h.grid().MatchOver() {
+		t.Fatalf("expected match to be over")
+	}
+
+	for i := IdType(0); i < 4; i += 1 {
+		name := fmt.Sprintf("player%d", i)
+		var s PlayerStats
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+			if s = stats(name); s.Matches > 0 {
+				break
+			}
+		}
+		if s.Matches != 1 || s.Kills != kills[i] || s.Deaths != deaths[i] {
+			t.Errorf("expected %s to have 1 match with %d kills and %d deaths, got %+v", name, kills[i], deaths[i], s)
+		}
+	}
+}
+
+func TestRunRecoversFromPanic(t *testing.T) {
+	captureLogs(t, errorLogLevel + 1, false)
+
+	r := newRoom("panic", map[string]string{})
+	client, browser := newTestClient(t, r, 0)
+	roomsMutex.Lock()
+	rooms[r.name] = r
+	roomsMutex.Unlock()
+
+	// The next frame dereferences the missing game
+	r.game = nil
+	stopped := make(chan struct{})
+	go func() {
+		r.run()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected room to stop after a panic")
+	}
+
+	roomsMutex.Lock()
+	_, ok := rooms[r.name]
+	roomsMutex.Unlock()
+	if ok {
+		t.Errorf("expected room to be removed")
+	}
+
+	browser.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := browser.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("expected client to be kicked, got %v", err)
+	}
+
+	// Senders that raced with the panic give up instead of blocking
+	sent := make(chan bool, 3)
+	go func() {
+		sent <- r.sendIncoming(IncomingMsg {client: client})
+		sent <- r.sendUnregister(client)
+		sent <- r.sendRegister(client)
+	}()
+	for i := 0; i < 3; i += 1 {
+		select {
+		case ok := <-sent:
+			if ok {
+				t.Errorf("expected send to a stopped room to fail")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected send to a stopped room not to block")
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"github.com/pion/webrtc/v3"
+	"strconv"
+	"sync"
+)
+
+type VoiceRoutingType uint8
+const (
+	unknownVoiceRouting VoiceRoutingType = iota
+	allVoiceRouting
+	teamVoiceRouting
+	proximityVoiceRouting
+)
+
+const (
+	voiceProximity float64 = 16
+	// Game ticks between routing updates
+	voiceRoutingTicks int = 15
+	maxRTPPacketSize int = 1500
+)
+
+// Audio from one publisher to one subscriber, muted when routing says they can't hear each other
+type voiceRoute struct {
+	track *webrtc.TrackLocalStaticRTP
+	sender *webrtc.RTPSender
+	enabled bool
+}
+
+// Forwards each voice client's audio track to the other voice clients over their existing PeerConnection,
+// so clients only upload once instead of once per peer
+type VoiceSFU struct {
+	mutex sync.Mutex
+	routing VoiceRoutingType
+
+	publishers map[IdType]*webrtc.TrackRemote
+	subscribers map[IdType]*Client
+
+	// Keyed by publisher, then subscriber
+	routes map[IdType]map[IdType]*voiceRoute
+}
+
+func NewVoiceSFU(routing VoiceRoutingType) *VoiceSFU {
+	return &VoiceSFU {
+		routing: routing,
+		publishers: make(map[IdType]*webrtc.TrackRemote),
+		subscribers: make(map[IdType]*Client),
+		routes: make(map[IdType]map[IdType]*voiceRoute),
+	}
+}
+
+// Starts sending everyone else's audio to the client
+func (sfu *VoiceSFU) AddSubscriber(c *Client) error {
+	sfu.mutex.Lock()
+	sfu.subscribers[c.id] = c
+	for id, remote := range(sfu.publishers) {
+		if id == c.id {
+			continue
+		}
+		if err := sfu.addRoute(id, remote, c); err != nil {
+			sfu.mutex.Unlock()
+			return err
+		}
+	}
+	sfu.mutex.Unlock()
+
+	return c.Renegotiate()
+}
+
+// Called when the client's audio track arrives, forwards it until the track ends
+func (sfu *VoiceSFU) Publish(c *Client, remote *webrtc.TrackRemote) {
+	sfu.mutex.Lock()
+	sfu.publishers[c.id] = remote
+	renegotiate := make([]*Client, 0)
+	for id, subscriber := range(sfu.subscribers) {
+		if id == c.id {
+			continue
+		}
+		if err := sfu.addRoute(c.id, remote, subscriber); err != nil {
+			c.log.Error("failed to forward voice to %s: %v", subscriber.GetDisplayName(), err)
+			continue
+		}
+		renegotiate = append(renegotiate, subscriber)
+	}
+	sfu.mutex.Unlock()
+
+	for _, subscriber := range(renegotiate) {
+		if err := subscriber.Renegotiate(); err != nil {
+			subscriber.log.Error("renegotiation error: %v", err)
+		}
+	}
+
+	c.log.Info("publishing voice")
+	sfu.forward(c.id, remote)
+}
+
+// Stops sending and receiving audio for the client
+func (sfu *VoiceSFU) RemoveClient(c *Client) {
+	sfu.mutex.Lock()
+	renegotiate := make(map[IdType]*Client)
+
+	for id, route := range(sfu.routes[c.id]) {
+		if subscriber, ok := sfu.subscribers[id]; ok {
+			subscriber.wrtc.RemoveTrack(route.sender)
+			renegotiate[id] = subscriber
+		}
+	}
+	delete(sfu.routes, c.id)
+	delete(sfu.publishers, c.id)
+
+	// The client is leaving voice, so only the remaining subscribers need new offers
+	for _, routes := range(sfu.routes) {
+		if route, ok := routes[c.id]; ok {
+			c.wrtc.RemoveTrack(route.sender)
+			delete(routes, c.id)
+		}
+	}
+	delete(sfu.subscribers, c.id)
+	sfu.mutex.Unlock()
+
+	for _, client := range(renegotiate) {
+		if err := client.Renegotiate(); err != nil {
+			client.log.Error("renegotiation error: %v", err)
+		}
+	}
+}
+
+// Mutes routes based on teams or player positions
+func (sfu *VoiceSFU) UpdateRoutes(g *Grid) {
+	sfu.mutex.Lock()
+	defer sfu.mutex.Unlock()
+
+	for from, routes := range(sfu.routes) {
+		for to, route := range(routes) {
+			route.enabled = sfu.canHear(g, from, to)
+		}
+	}
+}
+
+func (sfu *VoiceSFU) canHear(g *Grid, from IdType, to IdType) bool {
+	if sfu.routing == allVoiceRouting {
+		return true
+	}
+
+	speaker := g.Get(Id(playerSpace, from))
+	listener := g.Get(Id(playerSpace, to))
+	if speaker == nil || listener == nil {
+		return false
+	}
+
+	switch sfu.routing {
+	case teamVoiceRouting:
+		speakerTeam, _ := speaker.GetByteAttribute(teamByteAttribute)
+		listenerTeam, _ := listener.GetByteAttribute(teamByteAttribute)
+		return speakerTeam == listenerTeam
+	case proximityVoiceRouting:
+		return speaker.DistSqr(listener) <= voiceProximity * voiceProximity
+	}
+	return false
+}
+
+// Requires the lock
+func (sfu *VoiceSFU) addRoute(from IdType, remote *webrtc.TrackRemote, to *Client) error {
+	// The stream id tells the subscriber who is talking
+	track, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, "voice" + strconv.Itoa(int(from)), strconv.Itoa(int(from)))
+	if err != nil {
+		return err
+	}
+
+	sender, err := to.wrtc.AddTrack(track)
+	if err != nil {
+		return err
+	}
+
+	// RTCP has to be read for interceptors to work
+	go func() {
+		buf := make([]byte, maxRTPPacketSize)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, ok := sfu.routes[from]; !ok {
+		sfu.routes[from] = make(map[IdType]*voiceRoute)
+	}
+	sfu.routes[from][to.id] = &voiceRoute {
+		track: track,
+		sender: sender,
+		enabled: sfu.routing == allVoiceRouting,
+	}
+	return nil
+}
+
+func (sfu *VoiceSFU) forward(from IdType, remote *webrtc.TrackRemote) {
+	buf := make([]byte, maxRTPPacketSize)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			return
+		}
+
+		sfu.mutex.Lock()
+		if sfu.publishers[from] != remote {
+			sfu.mutex.Unlock()
+			return
+		}
+		for _, route := range(sfu.routes[from]) {
+			if route.enabled {
+				route.track.Write(buf[:n])
+			}
+		}
+		sfu.mutex.Unlock()
+	}
+}
+
+// Set by the room creator, e.g. voice=sfu&voiceRouting=team
+func parseVoiceSFU(vars map[string]string) *VoiceSFU {
+	if vars["voice"] != "sfu" {
+		return nil
+	}
+
+	switch vars["voiceRouting"] {
+	case "team":
+		return NewVoiceSFU(teamVoiceRouting)
+	case "proximity":
+		return NewVoiceSFU(proximityVoiceRouting)
+	}
+	return NewVoiceSFU(allVoiceRouting)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+)
+
+type Shield struct {
+	owner SpacedId
+	state PartStateType
+	pressed bool
+	juice int
+	exhausted bool
+
+	maxJuice int
+	minJuice int
+}
+
+func NewShield(owner SpacedId, def ShieldDefinition) *Shield {
+	return &Shield {
+		owner: owner,
+		state: unknownPartState,
+		pressed: false,
+		juice: def.maxJuice,
+		exhausted: false,
+
+		maxJuice: def.maxJuice,
+		minJuice: def.minJuice,
+	}
+}
+
+func (s Shield) State() PartStateType {
+	return s.state
+}
+
+func (s *Shield) SetPressed(pressed bool) {
+	s.pressed = pressed
+}
+
+func (s *Shield) Update(grid *Grid, now time.Time) {
+	player := grid.Get(s.owner)
+	if player == nil {
+		return
+	}
+
+	if s.exhausted && s.juice >= s.minJuice {
+		s.exhausted = false
+	}
+
+	if !s.pressed || s.exhausted {
+		player.RemoveAttribute(shieldedAttribute)
+		if s.juice < s.maxJuice {
+			s.juice += 1
+		}
+
+		if s.exhausted {
+			s.state = rechargingPartState
+		} else {
+			s.state = readyPartState
+		}
+		return
+	}
+
+	s.state = activePartState
+	player.AddAttribute(shieldedAttribute)
+	s.juice -= 1
+
+	if s.juice <= 0 {
+		s.exhausted = true
+	}
+}
+
+func (s Shield) OnDelete(grid *Grid) {
+	player := grid.Get(s.owner)
+	if player == nil {
+		return
+	}
+	player.RemoveAttribute(shieldedAttribute)
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	snapshotVersion int = 1
+
+	snapshotTimeout time.Duration = 5 * time.Second
+	// How often rooms with players are saved to storage
+	snapshotInterval time.Duration = 10 * time.Second
+	// Saved rooms older than this are dropped on startup since their players are gone
+	maxSnapshotAge time.Duration = 5 * time.Minute
+	// Restored players are removed unless their client reconnects in time
+	restoreReconnectTimeout time.Duration = 30 * time.Second
+)
+
+// Short lived objects that depend on whoever spawned them aren't saved
+var skipSnapshotSpaces = map[SpaceType]bool {
+	explosionSpace: true,
+	bombSpace: true,
+	pelletSpace: true,
+	boltSpace: true,
+	rocketSpace: true,
+	starSpace: true,
+	grapplingHookSpace: true,
+	tracerSpace: true,
+}
+
+// Objects with timers that should keep running after a restore
+type TimedObject interface {
+	GetTimers() []*Timer
+}
+
+// Enough of a room to rebuild it in another process, e.g. after a crash or to move it to another node
+type RoomSnapshot struct {
+	Version int
+	Name string
+	Time int64
+	NextClientId IdType
+
+	Password [sha256.Size]byte
+	HasPassword bool
+	Public bool
+	MaxPlayers int
+	// Unknown when voice uses a full mesh
+	VoiceRouting VoiceRoutingType
+
+	Level LevelIdType
+	Seed LevelSeedType
+	GameMode GameModeSnapshot
+
+	Objects []ObjectSnapshot
+	LevelObjects []LevelObjectSnapshot
+	Chat []ChatMsg
+}
+
+type ObjectSnapshot struct {
+	Id SpacedId
+	InitPos Vec2
+	InitDim Vec2
+	HasInitDir bool
+	InitDir Vec2
+	Name string
+
+	Pos Vec2
+	Vel Vec2
+	Acc Vec2
+	Dir Vec2
+	Dim Vec2
+	Owner SpacedId
+
+	Attributes AttributeSnapshot
+	Health HealthSnapshot
+	TTL ExpirationSnapshot
+	Timers []TimerSnapshot
+	KeysEnabled bool
+}
+
+// Level objects are rebuilt from the seed, so only their state is saved. Their ids depend on which levels
+// were loaded before, so they're matched by where they were placed instead.
+type LevelObjectSnapshot struct {
+	Space SpaceType
+	InitPos Vec2
+
+	Attributes AttributeSnapshot
+	Timers []TimerSnapshot
+}
+
+// Waits for the room's goroutine to take the snapshot between frames
+func (r *Room) RequestSnapshot() ([]byte, error) {
+	reply := make(chan []byte, 1)
+
+	select {
+	case r.snapshot <- reply:
+	case <-r.done:
+		return nil, fmt.Errorf("room %s has stopped", r.name)
+	case <-time.After(snapshotTimeout):
+		return nil, fmt.Errorf("timed out requesting snapshot of room %s", r.name)
+	}
+
+	select {
+	case b := <-reply:
+		return b, nil
+	case <-time.After(snapshotTimeout):
+		return nil, fmt.Errorf("timed out waiting for snapshot of room %s", r.name)
+	}
+}
+
+// Starts a room from a snapshot, players get a short window to reconnect with their old ids
+func RestoreRoom(b []byte) (*Room, error) {
+	snapshot := RoomSnapshot{}
+	if err := Unpack(b, &snapshot); err != nil {
+		return nil, fmt.Errorf("unpacking snapshot: %v", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, snapshotVersion)
+	}
+	if snapshot.Name == "" {
+		return nil, errors.New("snapshot is missing a room name")
+	}
+	age := time.Duration(UnixMilli() - snapshot.Time) * time.Millisecond
+	if age > maxSnapshotAge {
+		return nil, fmt.Errorf("snapshot of room %s is %v old", snapshot.Name, age)
+	}
+	// Players would be redirected to the owner and never reach the room
+	if !cluster.IsLocal(snapshot.Name) {
+		return nil, fmt.Errorf("room %s belongs to %s", snapshot.Name, cluster.Owner(snapshot.Name))
+	}
+
+	roomsMutex.Lock()
+	defer roomsMutex.Unlock()
+
+	if _, ok := rooms[snapshot.Name]; ok {
+		return nil, fmt.Errorf("room %s already exists", snapshot.Name)
+	}
+
+	r := newRoom(snapshot.Name, map[string]string{})
+	if err := r.restore(snapshot); err != nil {
+		return nil, err
+	}
+
+	rooms[r.name] = r
+	r.updateInfo()
+	r.log.With("age", age.String()).Info("restored room with %d objects", len(snapshot.Objects))
+	go r.run()
+	return r, nil
+}
+
+// Restores rooms saved by a previous run of this process, e.g. after a crash
+func RestoreSavedRooms() {
+	snapshots, err := storage.GetRoomSnapshots()
+	if err != nil {
+		logger.Error("failed to load saved rooms: %v", err)
+		return
+	}
+
+	for name, b := range(snapshots) {
+		l := logger.With("room", name)
+		if _, err := RestoreRoom(b); err != nil {
+			l.Warn("dropping saved room: %v", err)
+			if err := storage.DeleteRoomSnapshot(name); err != nil {
+				l.Error("failed to delete saved room: %v", err)
+			}
+		}
+	}
+}
+
+// Called from the room's goroutine, written to storage in the background like match records
+func (r *Room) saveSnapshot() {
+	b := Pack(r.takeSnapshot())
+
+	s := storage
+	r.saving.Add(1)
+	go func() {
+		defer r.saving.Done()
+		if err := s.SaveRoomSnapshot(r.name, b); err != nil {
+			r.log.Error("failed to save snapshot: %v", err)
+		}
+	}()
+}
+
+// Waits for pending saves so a stopped room doesn't come back on restart
+func (r *Room) deleteSnapshot() {
+	r.saving.Wait()
+	if err := storage.DeleteRoomSnapshot(r.name); err != nil {
+		r.log.Error("failed to delete snapshot: %v", err)
+	}
+}
+
+// Called from the room's goroutine
+func (r *Room) takeSnapshot() RoomSnapshot {
+	grid := r.game.GetGrid()
+	snapshot := RoomSnapshot {
+		Version: snapshotVersion,
+		Name: r.name,
+		Time: UnixMilli(),
+		NextClientId: r.nextClientId,
+
+		Password: r.options.password,
+		HasPassword: r.options.hasPassword,
+		Public: r.options.public,
+		MaxPlayers: r.options.maxPlayers,
+		VoiceRouting: unknownVoiceRouting,
+
+		Level: r.game.level.GetId(),
+		Seed: r.game.level.GetSeed(),
+		GameMode: grid.SnapshotGameMode(),
+
+		Objects: make([]ObjectSnapshot, 0),
+		LevelObjects: make([]LevelObjectSnapshot, 0),
+		Chat: make([]ChatMsg, len(r.chat.chatQueue)),
+	}
+	copy(snapshot.Chat, r.chat.chatQueue)
+	if r.sfu != nil {
+		snapshot.VoiceRouting = r.sfu.routing
+	}
+
+	for _, object := range(grid.GetAllObjects()) {
+		if object.HasAttribute(deletedAttribute) || skipSnapshotSpaces[object.GetSpace()] {
+			continue
+		}
+
+		if object.HasAttribute(fromLevelAttribute) {
+			snapshot.LevelObjects = append(snapshot.LevelObjects, LevelObjectSnapshot {
+				Space: object.GetSpace(),
+				InitPos: object.InitPos(),
+				Attributes: object.SnapshotAttributes(),
+				Timers: snapshotTimers(object),
+			})
+			continue
+		}
+		snapshot.Objects = append(snapshot.Objects, snapshotObject(object))
+	}
+
+	// Restore in id order so owners come back the same way every time
+	sort.Slice(snapshot.Objects, func(i, j int) bool {
+		a, b := snapshot.Objects[i].Id, snapshot.Objects[j].Id
+		if a.S != b.S {
+			return a.S < b.S
+		}
+		return a.Id < b.Id
+	})
+	return snapshot
+}
+
+func snapshotObject(object Object) ObjectSnapshot {
+	snapshot := ObjectSnapshot {
+		Id: object.GetSpacedId(),
+		InitPos: object.InitPos(),
+		InitDim: object.InitDim(),
+		HasInitDir: object.HasInitDir(),
+		InitDir: object.InitDir(),
+
+		Pos: object.Pos(),
+		Vel: object.Vel(),
+		Acc: object.Acc(),
+		Dir: object.Dir(),
+		Dim: object.Dim(),
+		Owner: object.GetOwner(),
+
+		Attributes: object.SnapshotAttributes(),
+		Health: object.SnapshotHealth(),
+		TTL: object.SnapshotTTL(),
+		Timers: snapshotTimers(object),
+		KeysEnabled: true,
+	}
+
+	if data := object.GetInitData(); data.Has(nameProp) {
+		snapshot.Name, _ = data.Get(nameProp).(string)
+	}
+	if player, ok := object.(*Player); ok {
+		snapshot.KeysEnabled = player.Keys.Enabled()
+	}
+	return snapshot
+}
+
+func snapshotTimers(object Object) []TimerSnapshot {
+	timed, ok := object.(TimedObject)
+	if !ok {
+		return nil
+	}
+
+	timers := timed.GetTimers()
+	snapshots := make([]TimerSnapshot, len(timers))
+	for i, timer := range(timers) {
+		snapshots[i] = timer.Snapshot()
+	}
+	return snapshots
+}
+
+func restoreTimers(object Object, snapshots []TimerSnapshot) {
+	timed, ok := object.(TimedObject)
+	if !ok {
+		return
+	}
+
+	timers := timed.GetTimers()
+	for i := 0; i < len(timers) && i < len(snapshots); i++ {
+		timers[i].Restore(snapshots[i])
+	}
+}
+
+// Called before the room's goroutine starts. Weapon ammo and reloads aren't saved and start over.
+func (r *Room) restore(snapshot RoomSnapshot) error {
+	grid := r.game.GetGrid()
+
+	r.nextClientId = snapshot.NextClientId
+	r.options = RoomOptions {
+		password: snapshot.Password,
+		hasPassword: snapshot.HasPassword,
+		public: snapshot.Public,
+		maxPlayers: snapshot.MaxPlayers,
+	}
+	r.sfu = nil
+	if snapshot.VoiceRouting != unknownVoiceRouting {
+		r.sfu = NewVoiceSFU(snapshot.VoiceRouting)
+	}
+
+	// Level objects go in after everything else so their new ids can't collide with saved ones
+	r.game.level.Clear(grid)
+	for _, objectSnapshot := range(snapshot.Objects) {
+		if err := restoreObject(grid, objectSnapshot); err != nil {
+			return err
+		}
+	}
+	r.game.LoadLevel(snapshot.Level, snapshot.Seed)
+	restoreLevelObjects(grid, snapshot.LevelObjects)
+
+	for _, object := range(grid.GetObjects(weaponSpace)) {
+		if player, ok := grid.Get(object.GetOwner()).(*Player); ok {
+			player.weapon = object.(*Weapon)
+		}
+	}
+	for _, object := range(grid.GetObjects(equipSpace)) {
+		if player, ok := grid.Get(object.GetOwner()).(*Player); ok {
+			player.equip = object.(*Equip)
+		}
+	}
+	// Same as a disconnect, reconnecting with the old id removes the TTL
+	for _, player := range(grid.GetObjects(playerSpace)) {
+		player.SetConstantTTL(restoreReconnectTimeout)
+	}
+
+	grid.RestoreGameMode(snapshot.GameMode)
+
+	r.chat.chatQueue = make([]ChatMsg, len(snapshot.Chat))
+	copy(r.chat.chatQueue, snapshot.Chat)
+	return nil
+}
+
+func restoreObject(grid *Grid, snapshot ObjectSnapshot) error {
+	object := grid.New(NewInit(snapshot.Id, snapshot.InitPos, snapshot.InitDim))
+	if object == nil {
+		return fmt.Errorf("unable to restore object %+v", snapshot.Id)
+	}
+
+	if snapshot.HasInitDir {
+		object.SetInitDir(snapshot.InitDir)
+	}
+	if snapshot.Name != "" {
+		object.SetInitProp(nameProp, snapshot.Name)
+	}
+	if snapshot.Owner.Valid() {
+		object.SetOwner(snapshot.Owner)
+	}
+
+	// Parts are only created when the type changes, so set it before the attributes do
+	equipType := EquipType(snapshot.Attributes.ByteAttributes[typeByteAttribute])
+	equipSubtype := EquipType(snapshot.Attributes.ByteAttributes[subtypeByteAttribute])
+	switch equip := object.(type) {
+	case *Weapon:
+		equip.SetType(equipType, equipSubtype)
+	case *Equip:
+		equip.SetType(equipType, equipSubtype)
+	}
+
+	object.RestoreAttributes(snapshot.Attributes)
+	object.SetPos(snapshot.Pos)
+	object.SetVel(snapshot.Vel)
+	object.SetAcc(snapshot.Acc)
+	object.SetDir(snapshot.Dir)
+	object.SetDim(snapshot.Dim)
+	object.RestoreHealth(snapshot.Health)
+	object.RestoreTTL(snapshot.TTL)
+	restoreTimers(object, snapshot.Timers)
+
+	if player, ok := object.(*Player); ok {
+		player.Keys.SetEnabled(snapshot.KeysEnabled)
+	}
+
+	grid.Upsert(object)
+	return nil
+}
+
+func restoreLevelObjects(grid *Grid, snapshots []LevelObjectSnapshot) {
+	type levelKey struct {
+		space SpaceType
+		pos Vec2
+	}
+
+	objects := make(map[levelKey]Object)
+	for _, object := range(grid.GetAllObjects()) {
+		if object.HasAttribute(fromLevelAttribute) {
+			objects[levelKey {object.GetSpace(), object.InitPos()}] = object
+		}
+	}
+
+	for _, snapshot := range(snapshots) {
+		object, ok := objects[levelKey {snapshot.Space, snapshot.InitPos}]
+		if !ok {
+			continue
+		}
+		object.RestoreAttributes(snapshot.Attributes)
+		restoreTimers(object, snapshot.Timers)
+	}
+}
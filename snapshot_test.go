@@ -0,0 +1,285 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Saves a room in the middle of a round and restores it into a new one
+func restoreTestRoom(t *testing.T, r *Room) *Room {
+	t.Helper()
+
+	snapshot := RoomSnapshot{}
+	if err := Unpack(Pack(r.takeSnapshot()), &snapshot); err != nil {
+		t.Fatalf("failed to unpack snapshot: %v", err)
+	}
+
+	restored := newTestRoom()
+	if err := restored.restore(snapshot); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	return restored
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	h := newVipHarness(t)
+	h.step(2)
+	if state := h.state(); state != activeGameState {
+		t.Fatalf("expected active state, got %d", state)
+	}
+
+	r := newTestRoom()
+	r.game = h.game
+	r.nextClientId = 4
+	r.options = parseRoomOptions(map[string]string {"password": "abc", "max": "6"})
+	r.chat.chatQueue = append(r.chat.chatQueue, ChatMsg {T: chatType, Id: 1, M: "gg"})
+
+	player := h.player(1)
+	player.SetInitProp(nameProp, "one")
+	player.SetHealth(42)
+	weapon := h.grid().New(NewInit(h.grid().NextSpacedId(weaponSpace), player.Pos(), player.Dim())).(*Weapon)
+	weapon.SetOwner(player.GetSpacedId())
+	weapon.SetType(uziWeapon, unknownEquip)
+	h.grid().Upsert(weapon)
+	player.weapon = weapon
+	h.grid().gameMode.(*VipMode).teamScores[2] = 3
+
+	restored := restoreTestRoom(t, r)
+	grid := restored.game.GetGrid()
+
+	if restored.game.level.GetId() != birdTownLevel || restored.game.level.GetSeed() != h.game.level.GetSeed() {
+		t.Errorf("expected level %d with seed %d, got %d with %d", birdTownLevel, h.game.level.GetSeed(), restored.game.level.GetId(), restored.game.level.GetSeed())
+	}
+	if restored.nextClientId != 4 {
+		t.Errorf("expected next client id 4, got %d", restored.nextClientId)
+	}
+	if !restored.options.CheckPassword("abc") || restored.options.CheckPassword("") || restored.options.maxPlayers != 6 {
+		t.Errorf("expected room options to be restored, got %+v", restored.options)
+	}
+	if len(restored.chat.chatQueue) != 1 || restored.chat.chatQueue[0].M != "gg" {
+		t.Errorf("expected chat history, got %+v", restored.chat.chatQueue)
+	}
+
+	for i := 0; i < 4; i += 1 {
+		original := h.player(IdType(i))
+		object := grid.Get(original.GetSpacedId())
+		if object == nil {
+			t.Fatalf("expected player %d to be restored", i)
+		}
+		restoredPlayer := object.(*Player)
+
+		if restoredPlayer.Pos() != original.Pos() || restoredPlayer.GetHealth() != original.GetHealth() {
+			t.Errorf("expected player %d at %+v with %d health, got %+v with %d", i, original.Pos(), original.GetHealth(), restoredPlayer.Pos(), restoredPlayer.GetHealth())
+		}
+		team, _ := restoredPlayer.GetByteAttribute(teamByteAttribute)
+		originalTeam, _ := original.GetByteAttribute(teamByteAttribute)
+		if team != originalTeam || restoredPlayer.HasAttribute(vipAttribute) != original.HasAttribute(vipAttribute) {
+			t.Errorf("expected player %d to keep team %d and VIP status", i, originalTeam)
+		}
+		if restoredPlayer.Keys.Enabled() != original.Keys.Enabled() {
+			t.Errorf("expected player %d to keep keys enabled = %t", i, original.Keys.Enabled())
+		}
+		if restoredPlayer.SnapshotTTL().Mode != constantExpirationMode {
+			t.Errorf("expected player %d to wait for a reconnect", i)
+		}
+	}
+
+	restoredPlayer := grid.Get(player.GetSpacedId()).(*Player)
+	if name, _ := restoredPlayer.GetInitData().Get(nameProp).(string); name != "one" {
+		t.Errorf("expected name to be restored, got %q", name)
+	}
+	if restoredPlayer.weapon == nil || restoredPlayer.weapon.GetSpacedId() != weapon.GetSpacedId() || restoredPlayer.weapon.GetType() != uziWeapon {
+		t.Errorf("expected player to hold the restored weapon")
+	}
+
+	mode := grid.gameMode.(*VipMode)
+	original := h.grid().gameMode.(*VipMode)
+	if state, _ := grid.GetGameState(); state != activeGameState {
+		t.Errorf("expected active state, got %d", state)
+	}
+	if mode.teamScores[2] != 3 {
+		t.Errorf("expected team 2 to keep its score, got %v", mode.teamScores)
+	}
+	if mode.vip == nil || mode.vip.GetSpacedId() != original.vip.GetSpacedId() {
+		t.Errorf("expected VIP %+v", original.vip.GetSpacedId())
+	}
+	if len(mode.players) != 4 || len(mode.teams[1]) != 2 || len(mode.teams[2]) != 2 {
+		t.Errorf("expected 4 players on 2 teams, got %d, %d", len(mode.teams[1]), len(mode.teams[2]))
+	}
+	if diff := mode.freezeTimer.Remaining() - original.freezeTimer.Remaining(); math.Abs(float64(diff)) > float64(time.Second) {
+		t.Errorf("expected freeze timer to keep running, off by %v", diff)
+	}
+}
+
+func TestRestoreRoomErrors(t *testing.T) {
+	captureLogs(t, errorLogLevel + 1, false)
+	roomsMutex.Lock()
+	rooms["taken"] = newTestRoom()
+	roomsMutex.Unlock()
+	defer func() {
+		roomsMutex.Lock()
+		delete(rooms, "taken")
+		roomsMutex.Unlock()
+	}()
+
+	prev := cluster
+	cluster = NewCluster(testNodes[0], testNodes)
+	defer func() { cluster = prev }()
+	remote := ""
+	for _, name := range(testRoomNames(20)) {
+		if !cluster.IsLocal(name) {
+			remote = name
+			break
+		}
+	}
+	if remote == "" {
+		t.Fatalf("expected a room owned by another node")
+	}
+
+	now := UnixMilli()
+	for _, tc := range([]struct {
+		name string
+		b []byte
+	}{
+		{"garbage", []byte {1, 2, 3}},
+		{"old version", Pack(RoomSnapshot {Version: snapshotVersion + 1, Name: "abcd", Time: now})},
+		{"missing name", Pack(RoomSnapshot {Version: snapshotVersion, Time: now})},
+		{"existing room", Pack(RoomSnapshot {Version: snapshotVersion, Name: "taken", Time: now})},
+		{"stale", Pack(RoomSnapshot {Version: snapshotVersion, Name: "abcd", Time: now - maxSnapshotAge.Milliseconds() - 1000})},
+		{"other node", Pack(RoomSnapshot {Version: snapshotVersion, Name: remote, Time: now})},
+	}) {
+		if _, err := RestoreRoom(tc.b); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+// Empty rooms are deleted after the timeout, which frees the name for the restore
+func newSnapshotTestRoom(name string, vars map[string]string) *Room {
+	r := newRoom(name, vars)
+	r.deleteTimer = NewTimer(50 * time.Millisecond)
+
+	player := r.game.Add(NewInit(Id(playerSpace, 0), NewVec2(0, 0), NewVec2(0.8, 1.44))).(*Player)
+	player.SetInitProp(nameProp, "zero")
+	player.SetTeam(1)
+	player.SetSpawn(r.game.GetGrid())
+	player.Respawn()
+	r.nextClientId = 1
+	return r
+}
+
+func TestRequestSnapshotRestoreRoom(t *testing.T) {
+	captureLogs(t, errorLogLevel + 1, false)
+	prev := storage
+	storage = NewMemoryStorage()
+	defer func() { storage = prev }()
+
+	r := newSnapshotTestRoom("snapshot0", map[string]string {"voice": "sfu", "voiceRouting": "team"})
+	roomsMutex.Lock()
+	rooms[r.name] = r
+	roomsMutex.Unlock()
+	go r.run()
+
+	b, err := r.RequestSnapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+
+	select {
+	case <-r.done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected empty room to be deleted")
+	}
+	if _, err := r.RequestSnapshot(); err == nil {
+		t.Errorf("expected snapshot of a stopped room to fail")
+	}
+
+	restored, err := RestoreRoom(b)
+	if err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	defer restored.Close()
+	roomsMutex.Lock()
+	registered := rooms[r.name]
+	roomsMutex.Unlock()
+	if registered != restored {
+		t.Errorf("expected restored room to be registered")
+	}
+
+	// Read the state back through the restored room's goroutine
+	b, err = restored.RequestSnapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot of restored room: %v", err)
+	}
+	snapshot := RoomSnapshot{}
+	if err := Unpack(b, &snapshot); err != nil {
+		t.Fatalf("failed to unpack: %v", err)
+	}
+
+	if snapshot.VoiceRouting != teamVoiceRouting {
+		t.Errorf("expected team voice routing, got %d", snapshot.VoiceRouting)
+	}
+	if snapshot.NextClientId != 1 {
+		t.Errorf("expected next client id 1, got %d", snapshot.NextClientId)
+	}
+	if len(snapshot.Objects) != 1 || snapshot.Objects[0].Name != "zero" || snapshot.Objects[0].TTL.Mode != constantExpirationMode {
+		t.Errorf("expected player waiting for a reconnect, got %+v", snapshot.Objects)
+	}
+}
+
+func TestRestoreSavedRooms(t *testing.T) {
+	captureLogs(t, errorLogLevel + 1, false)
+	prev := storage
+	storage = NewMemoryStorage()
+	defer func() { storage = prev }()
+
+	saved := newTestRoom()
+	saved.name = "saved0"
+	stale := newTestRoom()
+	stale.name = "saved1"
+	staleSnapshot := stale.takeSnapshot()
+	staleSnapshot.Time -= maxSnapshotAge.Milliseconds() + 1000
+
+	storage.SaveRoomSnapshot(saved.name, Pack(saved.takeSnapshot()))
+	storage.SaveRoomSnapshot(stale.name, Pack(staleSnapshot))
+	storage.SaveRoomSnapshot("saved2", []byte {1, 2, 3})
+
+	RestoreSavedRooms()
+
+	roomsMutex.Lock()
+	restored, ok := rooms["saved0"]
+	_, restoredStale := rooms["saved1"]
+	roomsMutex.Unlock()
+	if !ok || restoredStale {
+		t.Fatalf("expected only the fresh room to be restored, got %t, %t", ok, restoredStale)
+	}
+
+	snapshots, _ := storage.GetRoomSnapshots()
+	if _, ok := snapshots["saved0"]; !ok || len(snapshots) != 1 {
+		t.Errorf("expected rooms that can't be restored to be dropped, got %d saved", len(snapshots))
+	}
+
+	restored.Close()
+	if snapshots, _ := storage.GetRoomSnapshots(); len(snapshots) != 0 {
+		t.Errorf("expected closed room to be dropped, got %d saved", len(snapshots))
+	}
+}
+
+func TestSaveSnapshot(t *testing.T) {
+	prev := storage
+	storage = NewMemoryStorage()
+	defer func() { storage = prev }()
+
+	r := newTestRoom()
+	r.saveSnapshot()
+	r.saving.Wait()
+	if snapshots, _ := storage.GetRoomSnapshots(); len(snapshots[r.name]) == 0 {
+		t.Fatalf("expected room to be saved")
+	}
+
+	r.deleteSnapshot()
+	if snapshots, _ := storage.GetRoomSnapshots(); len(snapshots) != 0 {
+		t.Errorf("expected snapshot to be deleted")
+	}
+}
\ No newline at end of file
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Hooks for a single game state, nil hooks are skipped
+type StateOptions struct {
+	// The state is only entered if this returns true
+	canEnter func(g *Grid) bool
+	// Called when canEnter rejects the state
+	onReject func(g *Grid)
+
+	onEnter func(g *Grid)
+	onUpdate func(g *Grid)
+	onExit func(g *Grid)
+
+	// Timed states call onTimeout or move to next once the duration is over, 0 for no limit
+	duration time.Duration
+	next GameStateType
+	onTimeout func(g *Grid)
+}
+
+type StateChange struct {
+	From GameStateType
+	To GameStateType
+
+	// Milliseconds until a timed state ends, 0 otherwise
+	Duration int
+}
+
+type StateChangeMsg struct {
+	T MessageType
+	Cs []StateChange
+}
+
+type StateMachine struct {
+	state GameStateType
+	lastState GameStateType
+	entered bool
+
+	states map[GameStateType]StateOptions
+	transitions map[GameStateType]map[GameStateType]bool
+	timer Timer
+
+	changes []StateChange
+}
+
+func NewStateMachine(initial GameStateType) StateMachine {
+	return StateMachine {
+		state: initial,
+		lastState: unknownGameState,
+		entered: false,
+
+		states: make(map[GameStateType]StateOptions),
+		transitions: make(map[GameStateType]map[GameStateType]bool),
+		timer: NewTimer(0),
+
+		changes: make([]StateChange, 0),
+	}
+}
+
+func (sm *StateMachine) AddState(state GameStateType, options StateOptions) {
+	sm.states[state] = options
+}
+
+func (sm *StateMachine) AddTransition(from GameStateType, to ...GameStateType) {
+	if _, ok := sm.transitions[from]; !ok {
+		sm.transitions[from] = make(map[GameStateType]bool)
+	}
+	for _, state := range(to) {
+		sm.transitions[from][state] = true
+	}
+}
+
+func (sm StateMachine) CanTransition(from GameStateType, to GameStateType) bool {
+	if transitions, ok := sm.transitions[from]; ok {
+		return transitions[to]
+	}
+	return false
+}
+
+func (sm StateMachine) State() GameStateType {
+	return sm.state
+}
+
+// True if the state changed since the last update
+func (sm StateMachine) Changed() bool {
+	return sm.state != sm.lastState
+}
+
+// Runs the exit and enter hooks right away, leaving the state untouched if the transition isn't allowed
+func (sm *StateMachine) Transition(g *Grid, state GameStateType) error {
+	if !sm.CanTransition(sm.state, state) {
+		return fmt.Errorf("invalid transition from state %d to %d", sm.state, state)
+	}
+
+	options := sm.states[state]
+	if options.canEnter != nil && !options.canEnter(g) {
+		if options.onReject != nil {
+			options.onReject(g)
+		}
+		return fmt.Errorf("rejected transition from state %d to %d", sm.state, state)
+	}
+
+	if current, ok := sm.states[sm.state]; ok && sm.entered && current.onExit != nil {
+		current.onExit(g)
+	}
+
+	from := sm.state
+	sm.state = state
+	sm.enter(g, from)
+	return nil
+}
+
+// Sets the state without checks or hooks, e.g. to mirror the server
+func (sm *StateMachine) ForceState(state GameStateType) {
+	sm.state = state
+}
+
+// Resumes a saved state partway through without running its hooks
+func (sm *StateMachine) Restore(state GameStateType, timer TimerSnapshot) {
+	sm.state = state
+	sm.lastState = state
+	sm.entered = true
+	sm.timer.Restore(timer)
+}
+
+func (sm *StateMachine) Update(g *Grid) {
+	if !sm.entered {
+		sm.enter(g, unknownGameState)
+	}
+	sm.lastState = sm.state
+
+	options := sm.states[sm.state]
+	if sm.timer.Finished() {
+		sm.timer.Stop()
+		if options.onTimeout != nil {
+			options.onTimeout(g)
+		} else if err := sm.Transition(g, options.next); err != nil {
+			logger.Error("%v", err)
+		}
+		return
+	}
+
+	if options.onUpdate != nil {
+		options.onUpdate(g)
+	}
+}
+
+func (sm *StateMachine) PopChanges() []StateChange {
+	changes := sm.changes
+	sm.changes = make([]StateChange, 0)
+	return changes
+}
+
+func (sm *StateMachine) enter(g *Grid, from GameStateType) {
+	sm.entered = true
+	options := sm.states[sm.state]
+
+	sm.timer.Stop()
+	if options.duration > 0 {
+		sm.timer.SetDuration(options.duration)
+		sm.timer.Start()
+	}
+
+	sm.changes = append(sm.changes, StateChange {
+		From: from,
+		To: sm.state,
+		Duration: int(options.duration.Milliseconds()),
+	})
+
+	if options.onEnter != nil {
+		options.onEnter(g)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRating float64 = 1000
+	ratingK float64 = 32
+)
+
+// Where finished rounds and lifetime player stats are kept, shared by every room. Also keeps the latest
+// snapshot of every running room so they can be restored after a crash.
+type Storage interface {
+	RecordMatch(match MatchRecord) error
+	GetPlayerStats(name string) (PlayerStats, bool, error)
+
+	SaveRoomSnapshot(name string, snapshot []byte) error
+	DeleteRoomSnapshot(name string) error
+	GetRoomSnapshots() (map[string][]byte, error)
+
+	Close() error
+}
+
+var storage Storage = NewMemoryStorage()
+
+// Uses BoltDB at path, or keeps everything in memory if path is empty.
+func NewStorage(path string) (Storage, error) {
+	if path == "" {
+		logger.Warn("No stats database specified, stats will be lost on restart")
+		return NewMemoryStorage(), nil
+	}
+	return NewBoltStorage(path)
+}
+
+type MatchPlayerRecord struct {
+	Name string `json:"name"`
+	Team uint8 `json:"team"`
+	Kills int `json:"kills"`
+	Deaths int `json:"deaths"`
+	Assists int `json:"assists"`
+}
+
+type MatchRecord struct {
+	Room string `json:"room"`
+	Time time.Time `json:"time"`
+	Level LevelIdType `json:"level"`
+	Seed LevelSeedType `json:"seed"`
+
+	WinningTeam uint8 `json:"winningTeam"`
+	Scores map[uint8]int `json:"scores"`
+	Players []MatchPlayerRecord `json:"players"`
+}
+
+type PlayerStats struct {
+	Name string `json:"name"`
+	Matches int `json:"matches"`
+	Wins int `json:"wins"`
+	Kills int `json:"kills"`
+	Deaths int `json:"deaths"`
+	Assists int `json:"assists"`
+	Rating float64 `json:"rating"`
+	LastPlayed time.Time `json:"lastPlayed"`
+}
+
+func NewPlayerStats(name string) PlayerStats {
+	return PlayerStats {
+		Name: name,
+		Rating: defaultRating,
+	}
+}
+
+// Adds the match to the stats of everyone in it. Stats must already contain every player.
+func applyMatch(match MatchRecord, stats map[string]PlayerStats) {
+	ratingSums := make(map[uint8]float64)
+	counts := make(map[uint8]int)
+	for _, player := range(match.Players) {
+		ratingSums[player.Team] += stats[player.Name].Rating
+		counts[player.Team] += 1
+	}
+
+	// Team Elo using the average rating of each side
+	expected := make(map[uint8]float64)
+	if counts[1] > 0 && counts[2] > 0 {
+		avg1 := ratingSums[1] / float64(counts[1])
+		avg2 := ratingSums[2] / float64(counts[2])
+		expected[1] = 1 / (1 + math.Pow(10, (avg2 - avg1) / 400))
+		expected[2] = 1 - expected[1]
+	}
+
+	for _, player := range(match.Players) {
+		playerStats := stats[player.Name]
+		playerStats.Add(match, player)
+
+		if e, ok := expected[player.Team]; ok && match.WinningTeam != 0 {
+			score := 0.0
+			if player.Team == match.WinningTeam {
+				score = 1.0
+			}
+			playerStats.Rating += ratingK * (score - e)
+		}
+		stats[player.Name] = playerStats
+	}
+}
+
+func (ps *PlayerStats) Add(match MatchRecord, player MatchPlayerRecord) {
+	ps.Matches += 1
+	if player.Team != 0 && player.Team == match.WinningTeam {
+		ps.Wins += 1
+	}
+	ps.Kills += player.Kills
+	ps.Deaths += player.Deaths
+	ps.Assists += player.Assists
+	if match.Time.After(ps.LastPlayed) {
+		ps.LastPlayed = match.Time
+	}
+}
+
+type MemoryStorage struct {
+	mutex sync.Mutex
+	matches []MatchRecord
+	players map[string]PlayerStats
+	rooms map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage {
+		matches: make([]MatchRecord, 0),
+		players: make(map[string]PlayerStats),
+		rooms: make(map[string][]byte),
+	}
+}
+
+func (ms *MemoryStorage) RecordMatch(match MatchRecord) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.matches = append(ms.matches, match)
+
+	stats := make(map[string]PlayerStats)
+	for _, player := range(match.Players) {
+		playerStats, ok := ms.players[player.Name]
+		if !ok {
+			playerStats = NewPlayerStats(player.Name)
+		}
+		stats[player.Name] = playerStats
+	}
+
+	applyMatch(match, stats)
+	for name, playerStats := range(stats) {
+		ms.players[name] = playerStats
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) GetPlayerStats(name string) (PlayerStats, bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	stats, ok := ms.players[name]
+	return stats, ok, nil
+}
+
+func (ms *MemoryStorage) SaveRoomSnapshot(name string, snapshot []byte) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.rooms[name] = snapshot
+	return nil
+}
+
+func (ms *MemoryStorage) DeleteRoomSnapshot(name string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	delete(ms.rooms, name)
+	return nil
+}
+
+func (ms *MemoryStorage) GetRoomSnapshots() (map[string][]byte, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	snapshots := make(map[string][]byte)
+	for name, snapshot := range(ms.rooms) {
+		snapshots[name] = snapshot
+	}
+	return snapshots, nil
+}
+
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
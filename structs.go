@@ -34,7 +34,7 @@ type Line struct {
 
 func NewLine(origin Vec2, ray Vec2) Line {
 	if ray.X == 0 && ray.Y == 0 {
-		Log("0 length line!")
+		logger.Warn("0 length line")
 		ray.X = 1
 	}
 
@@ -60,6 +60,13 @@ func (l Line) Intersects(o Line) IntersectResults {
     	results.hit = true
     	results.t = t
     	results.tmax = t
+
+    	// Perpendicular of the other line, facing back towards this line's origin
+    	results.normal = NewVec2(-o.R.Y, o.R.X)
+    	results.normal.Normalize()
+    	if results.normal.Dot(l.R) > 0 {
+    		results.normal.Negate()
+    	}
     }
     return results
 }
@@ -4,6 +4,8 @@ import (
 	"time"
 )
 
+// Game time, tests replace it to simulate frames faster than real time
+var timeNow = time.Now
 
 type Timer struct {
 	started bool
@@ -30,7 +32,7 @@ func (t *Timer) SetDuration(duration time.Duration) {
 }
 
 func (t *Timer) Start() {
-	t.startTime = time.Now()
+	t.startTime = timeNow()
 	t.started = true
 }
 
@@ -59,12 +61,24 @@ func (t Timer) Finished() bool {
 	return t.Elapsed() > t.duration
 }
 
+func (t Timer) Remaining() time.Duration {
+	if !t.started {
+		return 0
+	}
+
+	remaining := t.duration - t.Elapsed()
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
 func (t Timer) Elapsed() time.Duration {
 	if !t.started {
 		return 0
 	}
 
-	elapsed := time.Now().Sub(t.startTime.Add(t.delay))
+	elapsed := timeNow().Sub(t.startTime.Add(t.delay))
 	return elapsed
 }
 
@@ -77,4 +91,31 @@ func (t Timer) Lerp(min float64, max float64) float64 {
 	ts := Max(float64(t.Elapsed()), 0) / float64(t.duration)
 
 	return min + ts * (max - min)
+}
+
+// Saved relative to now so the timer keeps running after a restore
+type TimerSnapshot struct {
+	Started bool
+	Elapsed time.Duration
+	Delay time.Duration
+	Duration time.Duration
+}
+
+func (t Timer) Snapshot() TimerSnapshot {
+	snapshot := TimerSnapshot {
+		Started: t.started,
+		Delay: t.delay,
+		Duration: t.duration,
+	}
+	if t.started {
+		snapshot.Elapsed = timeNow().Sub(t.startTime)
+	}
+	return snapshot
+}
+
+func (t *Timer) Restore(snapshot TimerSnapshot) {
+	t.started = snapshot.Started
+	t.startTime = timeNow().Add(-snapshot.Elapsed)
+	t.delay = snapshot.Delay
+	t.duration = snapshot.Duration
 }
\ No newline at end of file
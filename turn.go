@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"github.com/pion/turn/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	turnRealm string = "blockdudes3"
+
+	// Browsers reuse the credentials to refresh their allocation, so they have to outlast a session.
+	// Every connection gets new ones, but leaked credentials can use the relay until they expire.
+	turnCredentialTTL time.Duration = 12 * time.Hour
+)
+
+var stunURLs = []string {
+	"stun:stun.l.google.com:19302",
+	"stun:stun2.l.google.com:19302",
+	"stun:openrelay.metered.ca:80",
+}
+
+// Embedded TURN server for players behind symmetric NATs, nil if disabled
+var turnRelay *TurnRelay
+
+type TurnRelay struct {
+	server *turn.Server
+	url string
+	secret string
+
+	// Make clients use only relayed candidates, useful for testing relays on localhost
+	forceRelay bool
+}
+
+// Listens for UDP on the port and hands out relays on publicIP
+func NewTurnRelay(publicIP string, port int, forceRelay bool) (*TurnRelay, error) {
+	ip := net.ParseIP(publicIP)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid TURN public IP: %s", publicIP)
+	}
+
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:" + strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+
+	relay := &TurnRelay {
+		url: fmt.Sprintf("turn:%s:%d?transport=udp", publicIP, port),
+		secret: newToken(),
+		forceRelay: forceRelay,
+	}
+
+	relay.server, err = turn.NewServer(turn.ServerConfig {
+		Realm: turnRealm,
+		AuthHandler: relay.authenticate,
+		PacketConnConfigs: []turn.PacketConnConfig {
+			{
+				PacketConn: conn,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic {
+					RelayAddress: ip,
+					Address: "0.0.0.0",
+				},
+			},
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return relay, nil
+}
+
+func (tr *TurnRelay) Close() error {
+	return tr.server.Close()
+}
+
+// Ephemeral credentials in the TURN REST API format, username is expiry:name
+func (tr *TurnRelay) GetCredentials(name string) (string, string) {
+	expiry := time.Now().Add(turnCredentialTTL).Unix()
+	username := strconv.FormatInt(expiry, 10) + ":" + name
+	return username, tr.password(username)
+}
+
+func (tr *TurnRelay) authenticate(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+	parts := strings.SplitN(username, ":", 2)
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		logger.With("turn", srcAddr.String()).Warn("malformed username %s", username)
+		return nil, false
+	}
+	if expiry < time.Now().Unix() {
+		logger.With("turn", srcAddr.String()).Warn("expired credentials for %s", username)
+		return nil, false
+	}
+	return turn.GenerateAuthKey(username, realm, tr.password(username)), true
+}
+
+func (tr *TurnRelay) password(username string) string {
+	mac := hmac.New(sha1.New, []byte(tr.secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ICE config for the browser, including the relay if enabled
+func NewICEConfig(name string) ICEConfig {
+	config := ICEConfig {
+		Servers: []ICEServer {
+			{
+				URLs: stunURLs,
+			},
+		},
+		Policy: "all",
+	}
+
+	if turnRelay == nil {
+		return config
+	}
+
+	username, credential := turnRelay.GetCredentials(name)
+	config.Servers = append(config.Servers, ICEServer {
+		URLs: []string{turnRelay.url},
+		Username: username,
+		Credential: credential,
+	})
+	if turnRelay.forceRelay {
+		config.Policy = "relay"
+	}
+	return config
+}
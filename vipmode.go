@@ -2,6 +2,7 @@ package main
 
 import (
 	"math/rand"
+	"sort"
 	"time"
 )
 
@@ -16,7 +17,12 @@ type VipMode struct {
 
 	vip Object
 	nextVip map[uint8]int
-	restartTimer Timer
+
+	freezeTimer Timer
+	roundTimer Timer
+	overtime bool
+	// Remaining seconds last sent to clients
+	lastSecond int
 }
 
 func NewVipMode() *VipMode {
@@ -26,152 +32,218 @@ func NewVipMode() *VipMode {
 
 		vip: nil,
 		nextVip: make(map[uint8]int),
-		restartTimer: NewTimer(3 * time.Second),
+
+		freezeTimer: NewTimer(0),
+		roundTimer: NewTimer(0),
+		overtime: false,
+		lastSecond: 0,
 	}
-	mode.SetState(lobbyGameState)
+	mode.initStates()
 	return mode
 }
 
-func (vm *VipMode) Update(g *Grid) {
-	vm.BaseGameMode.Update(g)
+func (vm *VipMode) initStates() {
+	vm.stateMachine = NewStateMachine(lobbyGameState)
+
+	vm.stateMachine.AddState(lobbyGameState, StateOptions {
+		onEnter: vm.enterLobby,
+		onUpdate: vm.updateLobby,
+	})
+	vm.stateMachine.AddState(setupGameState, StateOptions {})
+	vm.stateMachine.AddState(activeGameState, StateOptions {
+		canEnter: vm.canStartRound,
+		onReject: vm.returnToLobby,
+		onEnter: vm.enterActive,
+		onUpdate: vm.updateActive,
+	})
+	vm.stateMachine.AddState(victoryGameState, StateOptions {
+		duration: 3 * time.Second,
+		onTimeout: vm.nextRound,
+	})
+
+	vm.stateMachine.AddTransition(lobbyGameState, setupGameState)
+	vm.stateMachine.AddTransition(setupGameState, lobbyGameState, activeGameState)
+	vm.stateMachine.AddTransition(activeGameState, victoryGameState, setupGameState)
+	vm.stateMachine.AddTransition(victoryGameState, activeGameState, setupGameState)
+}
 
-	if vm.state == unknownGameState {
-		return
-	}
+func (vm *VipMode) enterLobby(g *Grid) {
+	players := g.GetObjects(playerSpace)
+	vm.updateAfk(players)
 
-	if vm.state == lobbyGameState {
-		if vm.firstFrame {
-			for _, player := range(g.GetObjects(playerSpace)) {
-				player.RemoveAttribute(vipAttribute)
-				player.AddInternalAttribute(autoRespawnAttribute)
-				player.SetByteAttribute(teamByteAttribute, 0)
-				player.SetIntAttribute(colorIntAttribute, teamColors[0])
-				player.(*Player).SetSpawn(g)
-				player.Respawn()
-			}
+	for _, player := range(players) {
+		player.RemoveAttribute(vipAttribute)
+		player.AddInternalAttribute(autoRespawnAttribute)
+		if vm.teamOptions.assignment == portalTeamAssignment && !vm.teamOptions.shuffle {
+			player.(*Player).SetTeam(0)
 		}
+	}
 
-		vm.teams = make(map[uint8][]Object)
-		players := g.GetObjects(playerSpace)
-		for _, player := range(players) {
-			team, _ := player.GetByteAttribute(teamByteAttribute)
-			vm.teams[team] = append(vm.teams[team], player)
-		}
+	if vm.teamOptions.shuffle {
+		vm.shuffleTeams(players)
+	} else if vm.teamOptions.assignment == kdTeamAssignment {
+		vm.draftTeams(players)
+	}
 
-		if len(vm.teams[0]) > 0 {
-			return
-		}
+	for _, player := range(players) {
+		player.(*Player).SetSpawn(g)
+		player.Respawn()
+	}
+}
 
-		if len(vm.teams[1]) == 0 || len(vm.teams[2]) == 0 {
-			return
+func (vm *VipMode) updateLobby(g *Grid) {
+	players := g.GetObjects(playerSpace)
+	vm.updateAfk(players)
+
+	if vm.teamOptions.assignment == countTeamAssignment || vm.teamOptions.assignment == kdTeamAssignment {
+		vm.balanceTeams(players)
+	}
+
+	vm.teams = make(map[uint8][]Object)
+	active := 0
+	undecided := false
+	for _, player := range(players) {
+		team, _ := player.GetByteAttribute(teamByteAttribute)
+		vm.teams[team] = append(vm.teams[team], player)
+
+		if team != 0 {
+			active += 1
+		} else if !player.HasAttribute(afkAttribute) {
+			undecided = true
 		}
+	}
+
+	if undecided {
+		return
+	}
+
+	if len(vm.teams[1]) == 0 || len(vm.teams[2]) == 0 {
+		return
+	}
 
-		vm.players = make(map[SpacedId]Object)
-		for _, player := range(players) {
+	if !vm.hasRequiredPlayers(active) {
+		return
+	}
+
+	// AFK players sit out the match
+	vm.players = make(map[SpacedId]Object)
+	for _, player := range(players) {
+		if team, _ := player.GetByteAttribute(teamByteAttribute); team != 0 {
 			vm.players[player.GetSpacedId()] = player
 		}
+	}
 
-		vm.teamScores[1], vm.teamScores[2] = 0, 0
-		vm.nextVip[1], vm.nextVip[2] = 0, 0
-		vm.vip = nil
-		vm.config = GameModeConfig {
-			leftTeam: 1,
-			rightTeam: 2,
-			reverse: false,
-			nextState: activeGameState,
-			levelId: birdTownLevel,
-		}
-		vm.SetState(setupGameState)
-	} else if vm.state == activeGameState {
-		changed, valid := vm.checkChanges(g)
-
-		// TODO: split into helper fn
-		if changed {
-			vm.teams = make(map[uint8][]Object)
-			for _, player := range(vm.players) {
-				team, _ := player.GetByteAttribute(teamByteAttribute)
-				vm.teams[team] = append(vm.teams[team], player)
-			}
-		}
+	vm.teamScores[1], vm.teamScores[2] = 0, 0
+	vm.nextVip[1], vm.nextVip[2] = 0, 0
+	vm.vip = nil
+	vm.config = GameModeConfig {
+		leftTeam: 1,
+		rightTeam: 2,
+		reverse: false,
+		nextState: activeGameState,
+		levelId: birdTownLevel,
+	}
+	vm.SetState(g, setupGameState)
+}
 
-		if vm.firstFrame {
-			for _, player := range(vm.players) {
-				player.RemoveAttribute(vipAttribute)
-			}
+// Both teams need someone left for a round to start
+func (vm *VipMode) canStartRound(g *Grid) bool {
+	vm.checkChanges(g)
+	vm.updateTeams()
+	return len(vm.teams[vm.config.leftTeam]) > 0 && len(vm.teams[vm.config.rightTeam]) > 0
+}
 
-			vm.winningTeam = 0
-			for _, player := range(vm.players) {
-				player.(*Player).SetSpawn(g)
-				player.Respawn()
-				player.RemoveAttribute(autoRespawnAttribute)
-			}
+func (vm *VipMode) enterActive(g *Grid) {
+	for _, player := range(vm.players) {
+		player.RemoveAttribute(vipAttribute)
+	}
 
-			offense := vm.config.leftTeam
-			if vm.nextVip[offense] >= len(vm.teams[offense]) {
-				vm.nextVip[offense] = 0
-			}
-			vipIndex := vm.nextVip[offense]
-			vm.nextVip[offense] += 1
-
-			// TODO: fix nullptr, vm.teams can be empty
-			vm.vip = vm.teams[offense][vipIndex]
-			vm.vip.AddAttribute(vipAttribute)
-		} else if !valid {
-			vm.config.levelId = lobbyLevel
-			vm.config.nextState = lobbyGameState
-			vm.SetState(setupGameState)
-			return
-		}
+	vm.winningTeam = 0
+	for _, player := range(vm.players) {
+		player.(*Player).SetSpawn(g)
+		player.Respawn()
+		player.RemoveAttribute(autoRespawnAttribute)
+	}
 
-		vm.winningTeam = vm.getWinningTeam()
-		if vm.winningTeam != 0 {
-			vm.teamScores[vm.winningTeam] += 1
-			vm.SetState(victoryGameState)
-		}
-	} else if vm.state == victoryGameState {
-		if vm.firstFrame {
-			vm.restartTimer.Start()
-			return
-		}
-		if vm.restartTimer.On() {
-			return
-		}
+	offense := vm.config.leftTeam
+	if vm.nextVip[offense] >= len(vm.teams[offense]) {
+		vm.nextVip[offense] = 0
+	}
+	vipIndex := vm.nextVip[offense]
+	vm.nextVip[offense] += 1
 
-		if vm.teamScores[1] >= vipMaxScore || vm.teamScores[2] >= vipMaxScore {
-			vm.config.levelId = lobbyLevel
-			vm.config.nextState = lobbyGameState
-			vm.SetState(setupGameState)
-			return
-		}
+	vm.vip = vm.teams[offense][vipIndex]
+	vm.vip.AddAttribute(vipAttribute)
 
-		resetLevel := false
-		if vm.config.leftTeam == 1 {
-			if vm.config.reverse {
-				vm.config.reverse = false
-				resetLevel = true
-			} else {
-				vm.swapSides(g)
-			}
-		} else if vm.config.leftTeam == 2 {
-			if vm.config.reverse {
-				vm.swapSides(g)
-			} else {
-				vm.config.reverse = true
-			}
-		}
+	vm.startRound()
+}
+
+func (vm *VipMode) updateActive(g *Grid) {
+	if vm.checkChanges(g) {
+		vm.updateTeams()
+	}
 
-		if resetLevel {
-			vm.config.levelId = birdTownLevel
-			vm.config.nextState = activeGameState
-			vm.SetState(setupGameState)
+	if !vm.validGame() {
+		vm.returnToLobby(g)
+		return
+	}
+
+	vm.updateRoundTimer(g)
+
+	vm.winningTeam = vm.getWinningTeam()
+	if vm.winningTeam != 0 {
+		vm.teamScores[vm.winningTeam] += 1
+		g.AddCombatEvent(NewRoundWonEvent(vm.winningTeam, vm.teamScores))
+		vm.SetState(g, victoryGameState)
+	}
+}
+
+func (vm VipMode) MatchOver() bool {
+	return vm.teamScores[1] >= vipMaxScore || vm.teamScores[2] >= vipMaxScore
+}
+
+// Starts the next round once the victory screen is over
+func (vm *VipMode) nextRound(g *Grid) {
+	if vm.MatchOver() {
+		vm.returnToLobby(g)
+		return
+	}
+
+	resetLevel := false
+	if vm.config.leftTeam == 1 {
+		if vm.config.reverse {
+			vm.config.reverse = false
+			resetLevel = true
 		} else {
-			vm.SetState(activeGameState)
+			vm.swapSides(g)
+		}
+	} else if vm.config.leftTeam == 2 {
+		if vm.config.reverse {
+			vm.swapSides(g)
+		} else {
+			vm.config.reverse = true
 		}
 	}
+
+	if resetLevel {
+		vm.config.levelId = birdTownLevel
+		vm.config.nextState = activeGameState
+		vm.SetState(g, setupGameState)
+	} else {
+		vm.SetState(g, activeGameState)
+	}
+}
+
+func (vm *VipMode) returnToLobby(g *Grid) {
+	vm.config.levelId = lobbyLevel
+	vm.config.nextState = lobbyGameState
+	if vm.stateMachine.State() != setupGameState {
+		vm.SetState(g, setupGameState)
+	}
 }
 
 func (vm *VipMode) SetWinningTeam(team uint8) {
-	if vm.state != activeGameState || team == 0 {
+	if vm.stateMachine.State() != activeGameState || team == 0 {
 		return
 	}
 
@@ -184,10 +256,124 @@ func (vm VipMode) GetUpdates() Data {
 	if vm.vip != nil {
 		data.Set(vipProp, vm.vip.GetSpacedId())
 	}
+
+	if vm.stateMachine.State() == activeGameState {
+		if vm.freezeTimer.Started() {
+			data.Set(freezeTimeProp, int(vm.freezeTimer.Remaining().Milliseconds()))
+		}
+		if vm.roundTimer.Started() {
+			data.Set(roundTimeProp, int(vm.roundTimer.Remaining().Milliseconds()))
+		}
+		data.Set(overtimeProp, vm.overtime)
+	}
 	return data
 }
 
-func (vm VipMode) checkChanges(g *Grid) (bool, bool) {
+func (vm VipMode) Snapshot() GameModeSnapshot {
+	snapshot := vm.BaseGameMode.Snapshot()
+
+	if vm.vip != nil {
+		snapshot.Vip = vm.vip.GetSpacedId()
+	}
+	for team, index := range(vm.nextVip) {
+		snapshot.NextVip[team] = index
+	}
+	snapshot.FreezeTimer = vm.freezeTimer.Snapshot()
+	snapshot.RoundTimer = vm.roundTimer.Snapshot()
+	snapshot.Overtime = vm.overtime
+	return snapshot
+}
+
+func (vm *VipMode) Restore(g *Grid, snapshot GameModeSnapshot) {
+	vm.BaseGameMode.Restore(g, snapshot)
+
+	vm.vip = nil
+	if snapshot.Vip.Valid() {
+		vm.vip = g.Get(snapshot.Vip)
+	}
+	vm.nextVip = make(map[uint8]int)
+	for team, index := range(snapshot.NextVip) {
+		vm.nextVip[team] = index
+	}
+	vm.freezeTimer.Restore(snapshot.FreezeTimer)
+	vm.roundTimer.Restore(snapshot.RoundTimer)
+	vm.overtime = snapshot.Overtime
+	vm.lastSecond = 0
+}
+
+// Freezes everyone, then starts the round timer once the freeze is over
+func (vm *VipMode) startRound() {
+	vm.overtime = false
+	vm.roundTimer.Stop()
+	vm.roundTimer.SetDuration(vm.roundOptions.timeLimit)
+
+	vm.freezeTimer.SetDuration(vm.roundOptions.freeze)
+	vm.freezeTimer.Start()
+	for _, player := range(vm.players) {
+		player.(*Player).Keys.SetEnabled(false)
+	}
+}
+
+// Handles the pre-round freeze, the time limit and overtime
+func (vm *VipMode) updateRoundTimer(g *Grid) {
+	if vm.freezeTimer.Started() {
+		if vm.freezeTimer.On() {
+			vm.updateSecond(vm.freezeTimer.Remaining())
+			return
+		}
+
+		vm.freezeTimer.Stop()
+		for _, player := range(vm.players) {
+			if !player.HasAttribute(deadAttribute) {
+				player.(*Player).Keys.SetEnabled(true)
+			}
+		}
+		if vm.roundOptions.timeLimit > 0 {
+			vm.roundTimer.Start()
+		}
+		vm.updated = true
+	}
+
+	if !vm.roundTimer.Started() {
+		return
+	}
+
+	vm.updateSecond(vm.roundTimer.Remaining())
+	if vm.roundTimer.On() {
+		return
+	}
+
+	// Overtime lasts as long as the VIP stays on the goal, otherwise the defense wins
+	if vm.vipOnGoal(g) {
+		if !vm.overtime {
+			vm.overtime = true
+			vm.updated = true
+		}
+		return
+	}
+	vm.SetWinningTeam(vm.config.rightTeam)
+}
+
+// Only resend the timer when the displayed second changes
+func (vm *VipMode) updateSecond(remaining time.Duration) {
+	second := int(remaining.Seconds())
+	if second != vm.lastSecond {
+		vm.lastSecond = second
+		vm.updated = true
+	}
+}
+
+func (vm VipMode) vipOnGoal(g *Grid) bool {
+	for _, goal := range(g.GetObjects(goalSpace)) {
+		if goal.HasAttribute(chargingAttribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes players who left, returning true if any did
+func (vm *VipMode) checkChanges(g *Grid) bool {
 	changed := false
 	for sid, player := range(vm.players) {
 		if g.Get(sid) == nil || player.HasAttribute(deletedAttribute) {
@@ -195,9 +381,15 @@ func (vm VipMode) checkChanges(g *Grid) (bool, bool) {
 			changed = true
 		}
 	}
+	return changed
+}
 
-	valid := vm.validGame()
-	return changed, valid
+func (vm *VipMode) updateTeams() {
+	vm.teams = make(map[uint8][]Object)
+	for _, player := range(vm.players) {
+		team, _ := player.GetByteAttribute(teamByteAttribute)
+		vm.teams[team] = append(vm.teams[team], player)
+	}
 }
 
 func (vm VipMode) validGame() bool {
@@ -251,6 +443,121 @@ func (vm VipMode) getWinningTeam() uint8 {
 	return 0
 }
 
+// Moves idle players to team 0 and lets them back in once they press something
+func (vm *VipMode) updateAfk(players map[IdType]Object) {
+	if vm.teamOptions.afkTimeout <= 0 {
+		return
+	}
+
+	for _, object := range(players) {
+		player := object.(*Player)
+		idle := player.IdleTime() > vm.teamOptions.afkTimeout
+
+		if idle && !player.HasAttribute(afkAttribute) {
+			player.AddAttribute(afkAttribute)
+			player.SetTeam(0)
+		} else if !idle && player.HasAttribute(afkAttribute) {
+			player.RemoveAttribute(afkAttribute)
+		}
+	}
+}
+
+// Puts unassigned players on the smaller team, then evens out the team sizes
+func (vm *VipMode) balanceTeams(players map[IdType]Object) {
+	sizes := make(map[uint8][]*Player)
+	unassigned := make([]*Player, 0)
+	for _, object := range(sortedPlayers(players)) {
+		if object.HasAttribute(afkAttribute) {
+			continue
+		}
+		team, _ := object.GetByteAttribute(teamByteAttribute)
+		if team == 0 {
+			unassigned = append(unassigned, object)
+		} else {
+			sizes[team] = append(sizes[team], object)
+		}
+	}
+
+	for _, player := range(unassigned) {
+		team := uint8(1)
+		if len(sizes[2]) < len(sizes[1]) {
+			team = 2
+		}
+		player.SetTeam(team)
+		sizes[team] = append(sizes[team], player)
+	}
+
+	for len(sizes[1]) > len(sizes[2]) + 1 || len(sizes[2]) > len(sizes[1]) + 1 {
+		from, to := uint8(1), uint8(2)
+		if len(sizes[2]) > len(sizes[1]) {
+			from, to = 2, 1
+		}
+
+		// Move whoever joined the team last
+		last := len(sizes[from]) - 1
+		player := sizes[from][last]
+		sizes[from] = sizes[from][:last]
+		player.SetTeam(to)
+		sizes[to] = append(sizes[to], player)
+	}
+}
+
+// Random teams with even sizes
+func (vm *VipMode) shuffleTeams(players map[IdType]Object) {
+	active := vm.getActivePlayers(players)
+	vm.random.Shuffle(len(active), func(i, j int) {
+		active[i], active[j] = active[j], active[i]
+	})
+
+	for i, player := range(active) {
+		player.SetTeam(uint8(1 + i % 2))
+	}
+}
+
+// Sorts by K/D and alternates picks 1-2-2-1 so both teams get a similar share of the best players
+func (vm *VipMode) draftTeams(players map[IdType]Object) {
+	active := vm.getActivePlayers(players)
+	sort.SliceStable(active, func(i, j int) bool {
+		return getKD(active[i]) > getKD(active[j])
+	})
+
+	for i, player := range(active) {
+		if i % 4 == 0 || i % 4 == 3 {
+			player.SetTeam(1)
+		} else {
+			player.SetTeam(2)
+		}
+	}
+}
+
+func (vm VipMode) getActivePlayers(players map[IdType]Object) []*Player {
+	active := make([]*Player, 0)
+	for _, player := range(sortedPlayers(players)) {
+		if !player.HasAttribute(afkAttribute) {
+			active = append(active, player)
+		}
+	}
+	return active
+}
+
+// Players ordered by id since map iteration is random
+func sortedPlayers(players map[IdType]Object) []*Player {
+	sorted := make([]*Player, 0, len(players))
+	for _, player := range(players) {
+		sorted = append(sorted, player.(*Player))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetId() < sorted[j].GetId()
+	})
+	return sorted
+}
+
+func getKD(player Object) float64 {
+	kills, _ := player.GetIntAttribute(killIntAttribute)
+	deaths, _ := player.GetIntAttribute(deathIntAttribute)
+	return float64(kills) / Max(1, float64(deaths))
+}
+
 func (vm VipMode) getEnemyTeam(team uint8) uint8 {
 	if team <= 0 || team > vipModeTeams {
 		return 0
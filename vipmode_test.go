@@ -0,0 +1,185 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Two players per team in the lobby, players 0 and 2 on team 1
+func newVipHarness(t *testing.T) *testHarness {
+	h := newTestHarness(t, lobbyLevel)
+	h.grid().SetRoundOptions(parseRoundOptions(map[string]string {"time": "120", "freeze": "3"}))
+	for i := 0; i < 4; i += 1 {
+		h.addPlayer(IdType(i), 0)
+	}
+
+	// Entering the lobby resets teams, so pick them afterwards like walking into a portal
+	h.step(1)
+	for i := 0; i < 4; i += 1 {
+		h.player(IdType(i)).SetTeam(uint8(1 + i % 2))
+	}
+	return h
+}
+
+func TestVipModeWinningTeam(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		swapped bool
+		vip IdType
+		dead []IdType
+		winningTeam uint8
+		expected uint8
+	}{
+		{"nobody dead", false, 0, []IdType{}, 0, 0},
+		{"vip dead", false, 0, []IdType{0}, 0, 2},
+		{"offense alive without vip", false, 0, []IdType{0, 2}, 0, 2},
+		{"defense dead", false, 0, []IdType{1, 3}, 0, 1},
+		{"one defender left", false, 0, []IdType{1}, 0, 0},
+		{"swapped vip dead", true, 1, []IdType{1}, 0, 1},
+		{"swapped defense dead", true, 1, []IdType{0, 2}, 0, 2},
+		{"already decided", false, 0, []IdType{}, 1, 1},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newVipHarness(t)
+
+			vm := NewVipMode()
+			vm.config = GameModeConfig {
+				leftTeam: 1,
+				rightTeam: 2,
+			}
+			if tc.swapped {
+				vm.config.leftTeam, vm.config.rightTeam = 2, 1
+			}
+			for i := 0; i < 4; i += 1 {
+				player := h.player(IdType(i))
+				vm.players[player.GetSpacedId()] = player
+			}
+			h.player(tc.vip).AddAttribute(vipAttribute)
+			for _, id := range(tc.dead) {
+				h.player(id).AddAttribute(deadAttribute)
+			}
+			vm.winningTeam = tc.winningTeam
+
+			if winner := vm.getWinningTeam(); winner != tc.expected {
+				t.Errorf("expected team %d to win, got %d", tc.expected, winner)
+			}
+		})
+	}
+}
+
+func TestVipModeRound(t *testing.T) {
+	h := newVipHarness(t)
+
+	// Lobby, then setup loads the level
+	h.step(2)
+	if state := h.state(); state != activeGameState {
+		t.Fatalf("expected active state, got %d", state)
+	}
+
+	var vip *Player
+	for _, object := range(h.grid().GetObjects(playerSpace)) {
+		if object.HasAttribute(vipAttribute) {
+			vip = object.(*Player)
+		}
+	}
+	if vip == nil {
+		t.Fatalf("expected a VIP")
+	}
+	if team, _ := vip.GetByteAttribute(teamByteAttribute); team != 1 {
+		t.Errorf("expected VIP on team 1, got %d", team)
+	}
+
+	// Players die during their update, which runs after the game mode
+	vip.TakeDamage(NewDamage(h.player(1).GetSpacedId(), bulletDamage, 1000, h.player(1).Pos()))
+	h.step(2)
+
+	if state := h.state(); state != victoryGameState {
+		t.Fatalf("expected victory state, got %d", state)
+	}
+	if winner := h.grid().GetWinningTeam(); winner != 2 {
+		t.Errorf("expected team 2 to win, got %d", winner)
+	}
+	if score := h.grid().GetTeamScores()[2]; score != 1 {
+		t.Errorf("expected team 2 to score, got %d", score)
+	}
+}
+
+func TestVipModeEmptyTeam(t *testing.T) {
+	h := newVipHarness(t)
+	h.step(1)
+	if state := h.state(); state != setupGameState {
+		t.Fatalf("expected setup state, got %d", state)
+	}
+
+	// Offense leaves during setup, so the round can't start
+	h.game.Delete(Id(playerSpace, 0))
+	h.game.Delete(Id(playerSpace, 2))
+	h.step(1)
+	if state := h.state(); state != setupGameState {
+		t.Fatalf("expected round to be rejected, got %d", state)
+	}
+
+	h.step(1)
+	if state := h.state(); state != lobbyGameState {
+		t.Errorf("expected lobby state, got %d", state)
+	}
+}
+
+func TestVipModeInvalidTransition(t *testing.T) {
+	h := newVipHarness(t)
+	h.step(1)
+
+	if err := h.grid().SetGameState(victoryGameState); err == nil {
+		t.Errorf("expected setup to victory to be rejected")
+	}
+	if state := h.state(); state != setupGameState {
+		t.Errorf("expected state to stay setup, got %d", state)
+	}
+}
+
+func TestVipModeAfk(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		vars map[string]string
+		afk bool
+	}{
+		{"disabled by default", map[string]string {}, false},
+		{"disabled", map[string]string {"afk": "0"}, false},
+		{"enabled", map[string]string {"afk": "30"}, true},
+	}) {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHarness(t, lobbyLevel)
+			h.grid().SetTeamOptions(parseTeamOptions(tc.vars))
+			player := h.addPlayer(0, 0)
+			h.step(1)
+			player.SetTeam(1)
+
+			h.step(int(31 * time.Second / frameTime))
+			if afk := player.HasAttribute(afkAttribute); afk != tc.afk {
+				t.Errorf("expected afk = %t, got %t", tc.afk, afk)
+			}
+			if team, _ := player.GetByteAttribute(teamByteAttribute); (team == 0) != tc.afk {
+				t.Errorf("expected afk = %t, got team %d", tc.afk, team)
+			}
+		})
+	}
+}
+
+
+func TestParseRoundOptions(t *testing.T) {
+	for _, tc := range([]struct {
+		name string
+		vars map[string]string
+		timeLimit time.Duration
+		freeze time.Duration
+	}{
+		{"disabled by default", map[string]string {}, 0, 0},
+		{"enabled", map[string]string {"time": "120", "freeze": "3"}, 2 * time.Minute, 3 * time.Second},
+		{"invalid", map[string]string {"time": "-1", "freeze": "abc"}, 0, 0},
+	}) {
+		options := parseRoundOptions(tc.vars)
+		if options.timeLimit != tc.timeLimit || options.freeze != tc.freeze {
+			t.Errorf("%s: expected %v and %v freeze, got %v and %v", tc.name, tc.timeLimit, tc.freeze, options.timeLimit, options.freeze)
+		}
+	}
+}
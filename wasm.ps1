@@ -1,9 +1,10 @@
-[string[]]$src_files = @("game.go", "association.go", "attachment.go", "attribute.go", "balconyblock.go", "block.go", "blockgrid.go", "booster.go", "cardinal.go", "chance.go", "collideroptions.go", "color.go", "circle.go", "data.go", "equip.go", "equipcharger.go", "expiration.go", "explosion.go", "flag.go", "gamemode.go", "grid.go", "health.go", "hutblock.go", "init.go", "initprops.go", "jetpack.go", "keys.go", "launcher.go", "level.go", "light.go", "log.go", "mainblock.go", "msg.go", "object.go", "objectheap.go", "objects.go", "optional.go", "player.go", "profile.go", "profilemath.go", "projectile.go", "projectiles.go", "rec2.go", "roofblock.go", "rotpoly.go", "state.go", "structs.go", "subprofile.go", "timer.go", "util.go", "vipmode.go", "wall.go", "weapon.go")
+[string[]]$src_files = @("game.go", "association.go", "attachment.go", "attribute.go", "balconyblock.go", "block.go", "blockgrid.go", "booster.go", "broadphase.go", "cardinal.go", "chance.go", "collideroptions.go", "color.go", "circle.go", "combatevent.go", "data.go", "equip.go", "equipcharger.go", "expiration.go", "explosion.go", "flag.go", "gamemode.go", "grid.go", "hitscan.go", "health.go", "hutblock.go", "init.go", "initprops.go", "jetpack.go", "keys.go", "launcher.go", "level.go", "light.go", "melee.go", "log.go", "mainblock.go", "msg.go", "object.go", "objectheap.go", "objects.go", "optional.go", "player.go", "profile.go", "profilemath.go", "projectile.go", "projectiles.go", "raycast.go", "rec2.go", "roofblock.go", "rotpoly.go", "shield.go", "state.go", "statemachine.go", "structs.go", "subprofile.go", "timer.go", "util.go", "vipmode.go", "wall.go", "weapon.go", "weapondefinition.go")
 
 foreach ($file in $src_files) {
 	cp "$($file)" "wasm/tmp_$($file)"
 }
 
+cp "weapons.json" "wasm/weapons.json"
 cp "wasm/wasm_main.go" "wasm/wasm_main_copy.txt"
 
 $env:GOOS="js"
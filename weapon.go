@@ -6,17 +6,16 @@ type Weapon struct {
 }
 
 func NewWeaponPart(weapon *Weapon, equipType EquipType) EquipPart {
+	if def, ok := GetWeaponDefinition(equipType); ok {
+		if def.launcher != nil {
+			return NewLauncher(weapon, *def.launcher)
+		}
+		if def.hitscan != nil {
+			return NewHitscan(weapon, *def.hitscan)
+		}
+	}
+
 	switch equipType {
-	case uziWeapon:
-		return NewLauncher(weapon, pelletSpace)
-	case grapplingHookWeapon:
-		return NewLauncher(weapon, grapplingHookSpace)
-	case bazookaWeapon:
-		return NewLauncher(weapon, rocketSpace)
-	case sniperWeapon:
-		return NewLauncher(weapon, boltSpace)
-	case starWeapon:
-		return NewLauncher(weapon, starSpace)
 	case chargerEquip:
 		return NewEquipCharger(weapon)
 	}
@@ -63,15 +62,7 @@ func (w *Weapon) SetType(equipType EquipType, equipSubtype EquipType) {
 		w.parts[altMouseClick] = sub
 	}
 
-	switch equipType {
-	case bazookaWeapon:
-		w.SetShotOffset(NewVec2(0.3, 0))
-	case uziWeapon:
-	case grapplingHookWeapon:
-		w.SetShotOffset(NewVec2(0.5, 0))
-	case sniperWeapon:
-		w.SetShotOffset(NewVec2(0.6, 0))
-	case starWeapon:
-		w.SetShotOffset(NewVec2(0.1, 0))
+	if def, ok := GetWeaponDefinition(equipType); ok {
+		w.SetShotOffset(def.shotOffset)
 	}
 }
\ No newline at end of file
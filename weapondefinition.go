@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Default definitions, also used by the WASM build since it can't read files.
+//go:embed weapons.json
+var defaultWeaponDefinitions []byte
+
+var equipNames = map[string]EquipType {
+	"uzi": uziWeapon,
+	"grapplingHook": grapplingHookWeapon,
+	"bazooka": bazookaWeapon,
+	"sniper": sniperWeapon,
+	"star": starWeapon,
+	"laser": laserWeapon,
+	"booster": boosterEquip,
+	"charger": chargerEquip,
+	"jetpack": jetpackEquip,
+	"melee": meleeEquip,
+	"shield": shieldEquip,
+}
+
+var projectileNames = map[string]SpaceType {
+	"pellet": pelletSpace,
+	"bolt": boltSpace,
+	"rocket": rocketSpace,
+	"star": starSpace,
+	"grapplingHook": grapplingHookSpace,
+}
+
+type LauncherDefinition struct {
+	space SpaceType
+
+	maxAmmo int
+	ammoDuration time.Duration
+	reloadDuration time.Duration
+
+	size Vec2
+	relativeSpeed bool
+	deleteOnRelease bool
+	vel float64
+	acc float64
+	jerk float64
+	limit int
+	number int
+	spread float64
+
+	chargedSize Vec2
+	chargedVel float64
+}
+
+type HitscanDefinition struct {
+	maxAmmo int
+	ammoDuration time.Duration
+	reloadDuration time.Duration
+
+	damage int
+	distance float64
+	knockback float64
+	color int
+}
+
+type MeleeDefinition struct {
+	damage int
+	distance float64
+	knockback float64
+	// Full width of the swing in radians
+	arc float64
+	cooldown time.Duration
+}
+
+type ShieldDefinition struct {
+	maxJuice int
+	// Juice needed before the shield can be raised again after running out
+	minJuice int
+}
+
+type WeaponDefinition struct {
+	equipType EquipType
+	subtype EquipType
+	shotOffset Vec2
+
+	// At most one of these is set
+	launcher *LauncherDefinition
+	hitscan *HitscanDefinition
+	melee *MeleeDefinition
+	shield *ShieldDefinition
+}
+
+type ProjectileDefinition struct {
+	damage int
+	ttl time.Duration
+	maxSpeed float64
+	sticky bool
+	color int
+	explosionOptions ExplosionOptions
+
+	charged *ProjectileDefinition
+}
+
+var weaponDefinitions = make(map[EquipType]WeaponDefinition)
+var projectileDefinitions = make(map[SpaceType]ProjectileDefinition)
+
+// Sent to clients so their simulation matches, empty when using the defaults
+var weaponOverrides string
+
+func init() {
+	if err := LoadWeaponDefinitions(defaultWeaponDefinitions); err != nil {
+		panic(err)
+	}
+}
+
+func GetWeaponDefinition(equipType EquipType) (WeaponDefinition, bool) {
+	def, ok := weaponDefinitions[equipType]
+	return def, ok
+}
+
+func GetProjectileDefinition(space SpaceType) ProjectileDefinition {
+	if def, ok := projectileDefinitions[space]; ok {
+		return def
+	}
+	return NewProjectileDefinition()
+}
+
+func NewProjectileDefinition() ProjectileDefinition {
+	return ProjectileDefinition {
+		damage: 0,
+		ttl: 0,
+		maxSpeed: 100,
+		sticky: false,
+		color: 0,
+		explosionOptions: ExplosionOptions {
+			explode: false,
+		},
+		charged: nil,
+	}
+}
+
+// Same as LoadWeaponDefinitions, but clients are also sent the definitions when they load a level
+func OverrideWeaponDefinitions(b []byte) error {
+	if err := LoadWeaponDefinitions(b); err != nil {
+		return err
+	}
+	weaponOverrides = string(b)
+	return nil
+}
+
+// Replaces all definitions. Nothing is replaced if there's an error.
+// Empty loads the defaults, which is how clients drop a previous server's overrides.
+func LoadWeaponDefinitions(b []byte) error {
+	if len(b) == 0 {
+		b = defaultWeaponDefinitions
+	}
+
+	// Typos would otherwise silently fall back to zero values
+	var file weaponsFileJSON
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return err
+	}
+
+	weapons := make(map[EquipType]WeaponDefinition)
+	for name, weaponJSON := range(file.Weapons) {
+		def, err := weaponJSON.parse(name)
+		if err != nil {
+			return err
+		}
+		weapons[def.equipType] = def
+	}
+
+	projectiles := make(map[SpaceType]ProjectileDefinition)
+	for name, projectileJSON := range(file.Projectiles) {
+		space, ok := projectileNames[name]
+		if !ok {
+			return fmt.Errorf("unknown projectile %s", name)
+		}
+		def, err := projectileJSON.parse()
+		if err != nil {
+			return fmt.Errorf("projectile %s: %v", name, err)
+		}
+		projectiles[space] = def
+	}
+
+	weaponDefinitions = weapons
+	projectileDefinitions = projectiles
+	return nil
+}
+
+type weaponsFileJSON struct {
+	Weapons map[string]weaponJSON `json:"weapons"`
+	Projectiles map[string]projectileJSON `json:"projectiles"`
+}
+
+type weaponJSON struct {
+	Subtype string `json:"subtype"`
+	ShotOffset Vec2 `json:"shotOffset"`
+	Launcher *launcherJSON `json:"launcher"`
+	Hitscan *hitscanJSON `json:"hitscan"`
+	Melee *meleeJSON `json:"melee"`
+	Shield *shieldJSON `json:"shield"`
+}
+
+type launcherJSON struct {
+	Projectile string `json:"projectile"`
+	MaxAmmo int `json:"maxAmmo"`
+	AmmoMillis int `json:"ammoMillis"`
+	ReloadMillis int `json:"reloadMillis"`
+	Size Vec2 `json:"size"`
+	RelativeSpeed bool `json:"relativeSpeed"`
+	DeleteOnRelease bool `json:"deleteOnRelease"`
+	Vel float64 `json:"vel"`
+	Acc float64 `json:"acc"`
+	Jerk float64 `json:"jerk"`
+	Limit int `json:"limit"`
+	Number int `json:"number"`
+	SpreadDegrees float64 `json:"spreadDegrees"`
+	ChargedSize Vec2 `json:"chargedSize"`
+	ChargedVel float64 `json:"chargedVel"`
+}
+
+type hitscanJSON struct {
+	MaxAmmo int `json:"maxAmmo"`
+	AmmoMillis int `json:"ammoMillis"`
+	ReloadMillis int `json:"reloadMillis"`
+	Damage int `json:"damage"`
+	Distance float64 `json:"distance"`
+	Knockback float64 `json:"knockback"`
+	Color string `json:"color"`
+}
+
+type meleeJSON struct {
+	Damage int `json:"damage"`
+	Distance float64 `json:"distance"`
+	Knockback float64 `json:"knockback"`
+	ArcDegrees float64 `json:"arcDegrees"`
+	CooldownMillis int `json:"cooldownMillis"`
+}
+
+type shieldJSON struct {
+	MaxJuice int `json:"maxJuice"`
+	MinJuice int `json:"minJuice"`
+}
+
+type projectileJSON struct {
+	Damage int `json:"damage"`
+	TTLMillis int `json:"ttlMillis"`
+	MaxSpeed float64 `json:"maxSpeed"`
+	Sticky bool `json:"sticky"`
+	Color string `json:"color"`
+	Explosion *explosionJSON `json:"explosion"`
+	Charged *projectileJSON `json:"charged"`
+}
+
+type explosionJSON struct {
+	Size Vec2 `json:"size"`
+	Color string `json:"color"`
+	Damage int `json:"damage"`
+}
+
+func (wj weaponJSON) parse(name string) (WeaponDefinition, error) {
+	def := WeaponDefinition {
+		shotOffset: wj.ShotOffset,
+	}
+
+	var ok bool
+	if def.equipType, ok = equipNames[name]; !ok {
+		return def, fmt.Errorf("unknown weapon %s", name)
+	}
+	if len(wj.Subtype) > 0 {
+		if def.subtype, ok = equipNames[wj.Subtype]; !ok {
+			return def, fmt.Errorf("weapon %s: unknown subtype %s", name, wj.Subtype)
+		}
+	}
+
+	if wj.Launcher != nil {
+		lj := wj.Launcher
+		space, ok := projectileNames[lj.Projectile]
+		if !ok {
+			return def, fmt.Errorf("weapon %s: unknown projectile %s", name, lj.Projectile)
+		}
+
+		number := lj.Number
+		if number <= 0 {
+			number = 1
+		}
+
+		def.launcher = &LauncherDefinition {
+			space: space,
+			maxAmmo: lj.MaxAmmo,
+			ammoDuration: time.Duration(lj.AmmoMillis) * time.Millisecond,
+			reloadDuration: time.Duration(lj.ReloadMillis) * time.Millisecond,
+			size: lj.Size,
+			relativeSpeed: lj.RelativeSpeed,
+			deleteOnRelease: lj.DeleteOnRelease,
+			vel: lj.Vel,
+			acc: lj.Acc,
+			jerk: lj.Jerk,
+			limit: lj.Limit,
+			number: number,
+			spread: lj.SpreadDegrees * math.Pi / 180,
+			chargedSize: lj.ChargedSize,
+			chargedVel: lj.ChargedVel,
+		}
+	}
+
+	if wj.Hitscan != nil {
+		hj := wj.Hitscan
+		color, err := parseColor(hj.Color)
+		if err != nil {
+			return def, fmt.Errorf("weapon %s: %v", name, err)
+		}
+
+		def.hitscan = &HitscanDefinition {
+			maxAmmo: hj.MaxAmmo,
+			ammoDuration: time.Duration(hj.AmmoMillis) * time.Millisecond,
+			reloadDuration: time.Duration(hj.ReloadMillis) * time.Millisecond,
+			damage: hj.Damage,
+			distance: hj.Distance,
+			knockback: hj.Knockback,
+			color: color,
+		}
+	}
+
+	if wj.Melee != nil {
+		mj := wj.Melee
+		def.melee = &MeleeDefinition {
+			damage: mj.Damage,
+			distance: mj.Distance,
+			knockback: mj.Knockback,
+			arc: mj.ArcDegrees * math.Pi / 180,
+			cooldown: time.Duration(mj.CooldownMillis) * time.Millisecond,
+		}
+	}
+
+	if wj.Shield != nil {
+		def.shield = &ShieldDefinition {
+			maxJuice: wj.Shield.MaxJuice,
+			minJuice: wj.Shield.MinJuice,
+		}
+	}
+
+	parts := 0
+	for _, set := range([]bool {def.launcher != nil, def.hitscan != nil, def.melee != nil, def.shield != nil}) {
+		if set {
+			parts += 1
+		}
+	}
+	if parts > 1 {
+		return def, fmt.Errorf("weapon %s: can only be one of launcher, hitscan, melee or shield", name)
+	}
+	return def, nil
+}
+
+func (pj projectileJSON) parse() (ProjectileDefinition, error) {
+	def := NewProjectileDefinition()
+	def.damage = pj.Damage
+	def.ttl = time.Duration(pj.TTLMillis) * time.Millisecond
+	def.sticky = pj.Sticky
+	if pj.MaxSpeed > 0 {
+		def.maxSpeed = pj.MaxSpeed
+	}
+
+	var err error
+	if def.color, err = parseColor(pj.Color); err != nil {
+		return def, err
+	}
+
+	if pj.Explosion != nil {
+		def.explosionOptions.explode = true
+		def.explosionOptions.size = pj.Explosion.Size
+		def.explosionOptions.damage = pj.Explosion.Damage
+		if def.explosionOptions.color, err = parseColor(pj.Explosion.Color); err != nil {
+			return def, err
+		}
+	}
+
+	if pj.Charged != nil {
+		charged, err := pj.Charged.parse()
+		if err != nil {
+			return def, err
+		}
+		def.charged = &charged
+	}
+	return def, nil
+}
+
+// Accepts hex strings like "0xffa610", empty is 0
+func parseColor(color string) (int, error) {
+	if len(color) == 0 {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseInt(color, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid color %s", color)
+	}
+	return int(value), nil
+}
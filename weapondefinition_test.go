@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLoadWeaponDefinitions(t *testing.T) {
+	defer func() {
+		weaponOverrides = ""
+		LoadWeaponDefinitions(nil)
+	}()
+
+	for _, tc := range([]struct {
+		name string
+		b string
+	}{
+		{"garbage", "{"},
+		{"unknown field", `{"weapons": {"uzi": {"launcher": {"projectile": "pellet", "maxAmo": 3}}}}`},
+		{"unknown weapon", `{"weapons": {"spoon": {}}}`},
+		{"unknown projectile", `{"projectiles": {"spoon": {}}}`},
+		{"two parts", `{"weapons": {"melee": {"melee": {"damage": 1}, "shield": {"maxJuice": 1}}}}`},
+	}) {
+		if err := OverrideWeaponDefinitions([]byte(tc.b)); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+	if _, ok := GetWeaponDefinition(uziWeapon); !ok || weaponOverrides != "" {
+		t.Fatalf("expected defaults to be kept after errors")
+	}
+
+	override := `{"weapons": {"uzi": {"launcher": {"projectile": "pellet", "maxAmmo": 3}}}}`
+	if err := OverrideWeaponDefinitions([]byte(override)); err != nil {
+		t.Fatalf("failed to override: %v", err)
+	}
+	if def, ok := GetWeaponDefinition(uziWeapon); !ok || def.launcher.maxAmmo != 3 {
+		t.Errorf("expected overridden uzi")
+	}
+	if _, ok := GetWeaponDefinition(sniperWeapon); ok {
+		t.Errorf("expected overrides to replace all weapons")
+	}
+
+	// Clients load the same definitions with the level
+	g := NewGame()
+	if msg := g.createLevelInitMsg(); msg.W != override {
+		t.Errorf("expected overrides in level init, got %q", msg.W)
+	}
+
+	if err := LoadWeaponDefinitions(nil); err != nil {
+		t.Fatalf("failed to load defaults: %v", err)
+	}
+	if _, ok := GetWeaponDefinition(sniperWeapon); !ok {
+		t.Errorf("expected empty definitions to load the defaults")
+	}
+}